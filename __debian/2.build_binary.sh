@@ -10,7 +10,7 @@ done
 
 echo "Building binary from source"
 cd ../src
-CGO_ENABLED=0 go build -o ../__debian/${PKGDIR}/opt/bin/pgtools .
+CGO_ENABLED=0 go build -tags parquet -o ../__debian/${PKGDIR}/opt/bin/pgtools .
 strip ../__debian/${PKGDIR}/opt/bin/pgtools
 sudo chown 0:0 ../__debian/${PKGDIR}/opt/bin/pgtools
 
@@ -37,7 +37,7 @@ PGSQL multi-usage tool
 
 %build
 cd %{_sourcedir}/%{_name}-%{_version}/src
-PATH=$PATH:/opt/go/bin CGO_ENABLED=0 go build -o %{_sourcedir}/%{_binaryname} .
+PATH=$PATH:/opt/go/bin CGO_ENABLED=0 go build -tags parquet -o %{_sourcedir}/%{_binaryname} .
 strip %{_sourcedir}/%{_binaryname}
 
 %clean
@@ -56,4 +56,4 @@ if [ "$CHECK_PERMS" -eq 1 ]; then
 fi
 
 echo "Building ${OUTPUT}/${FULLNAME}"
-CGO_ENABLED=0 go build -o ${OUTPUT}/${FULLNAME} .
+CGO_ENABLED=0 go build -tags parquet -o ${OUTPUT}/${FULLNAME} .
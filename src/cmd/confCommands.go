@@ -6,14 +6,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
 
 	"pgtools/conf"
+	"pgtools/environment"
 	"pgtools/shared"
+	"pgtools/types"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -53,6 +58,22 @@ var confGetCmd = &cobra.Command{
 	Short: "Get one or more configuration parameters",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if environment.IsFleet(types.EnvConfigFile) {
+			runOnFleet(conf.Header, func(ctx context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError) {
+				pool, err := shared.OpenPool(ctx, cfg, "postgres")
+				if err != nil {
+					return nil, err
+				}
+				defer pool.Close()
+				settings, err := conf.CollectByNames(ctx, pool, args)
+				var rows []table.Row
+				for _, r := range settings {
+					rows = append(rows, conf.TableRow(r))
+				}
+				return rows, err
+			})
+			return
+		}
 		ctx, cancel := shared.CancellableContext()
 		defer cancel()
 
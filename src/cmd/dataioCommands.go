@@ -0,0 +1,92 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 17:00
+// Original filename: src/cmd/dataioCommands.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"pgtools/dataio"
+	"pgtools/environment"
+	"pgtools/types"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [-D DB] { --table SCHEMA.TABLE [--where COND] | --query SQL } -o FILE",
+	Short: "Export a table or a query to CSV, JSON Lines or Parquet",
+	Long: `Export a table (optionally filtered with --where) or the result of a query to CSV, JSON Lines or Parquet. The
+format is taken from --format, else from the output extension (.csv, .jsonl/.ndjson, .parquet). Timestamps are written
+in RFC 3339 (UTC for timestamptz), bytea in base64 (raw bytes in Parquet); numerics are strings unless --numeric decimal.
+With --chunk-rows or --chunk-size the output is split into numbered files: orders-0001.csv, orders-0002.csv...
+CSV and JSON Lines can go to stdout with -o -. Parquet is built in with -tags parquet, as
+build.sh and the packages do; a plain go build leaves it out.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := dataio.Export(cfg, defaultDatabase(types.ExportDatabase, cfg), &types.ExportOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [-D DB] FILE --table SCHEMA.TABLE",
+	Short: "Load a CSV or JSON Lines file into a table",
+	Long: `Stream a CSV or JSON Lines file (- for stdin) into a table with COPY. Input columns go to the table columns of the
+same name (CSV header or JSON keys), or of the same position for a CSV without header; --map SOURCE=COLUMN renames
+and --map SOURCE= skips. An unquoted --null marker is a NULL; --encoding converts from latin1, windows-1252...
+A row that cannot be read or converted stops the import, unless --rejects FILE is given: such rows are then written
+there as they were, and the others are loaded.
+With --create the table is created, with the types inferred from the first --sample rows (integer, bigint, numeric,
+boolean, date, timestamp, timestamptz, jsonb, else text), in the same transaction as the load.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := dataio.Import(cfg, defaultDatabase(types.ImportDatabase, cfg), args[0], &types.ImportOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&types.ExportDatabase, "dbname", "D", "", "Database to connect to")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Table, "table", "", "Table to export, schema.table (public by default)")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Query, "query", "", "Query whose result is exported")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Where, "where", "", "Condition filtering the --table rows")
+	exportCmd.Flags().StringVarP(&types.ExportOpts.Output, "output", "o", "-", "Output file (- for stdout)")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Format, "format", "", "Output format: csv|jsonl|parquet (default: from the output extension, else csv)")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Delimiter, "delimiter", ",", `CSV delimiter (\t for tabs)`)
+	exportCmd.Flags().StringVar(&types.ExportOpts.Quote, "quote", `"`, "CSV quote character")
+	exportCmd.Flags().BoolVar(&types.ExportOpts.Header, "header", true, "Write a CSV header line")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Null, "null", "", "How NULLs are written in CSV")
+	exportCmd.Flags().StringVar(&types.ExportOpts.Numeric, "numeric", "string", "How numerics are written in JSON Lines and Parquet: string|decimal")
+	exportCmd.Flags().Int64Var(&types.ExportOpts.ChunkRows, "chunk-rows", 0, "Start a new file every N rows")
+	exportCmd.Flags().StringVar(&types.ExportOpts.ChunkSize, "chunk-size", "", "Start a new file past this size (500MB, 2GB...)")
+
+	importCmd.Flags().StringVarP(&types.ImportDatabase, "dbname", "D", "", "Database to connect to")
+	importCmd.Flags().StringVar(&types.ImportOpts.Table, "table", "", "Table to load, schema.table (public by default)")
+	importCmd.Flags().StringVar(&types.ImportOpts.Format, "format", "", "Input format: csv|jsonl (default: from the file extension, else csv)")
+	importCmd.Flags().StringVar(&types.ImportOpts.Delimiter, "delimiter", ",", `CSV delimiter (\t for tabs)`)
+	importCmd.Flags().StringVar(&types.ImportOpts.Quote, "quote", `"`, "CSV quote character")
+	importCmd.Flags().BoolVar(&types.ImportOpts.Header, "header", true, "The CSV starts with a header line")
+	importCmd.Flags().StringVar(&types.ImportOpts.Null, "null", "", "Unquoted CSV value read as NULL")
+	importCmd.Flags().StringArrayVar(&types.ImportOpts.Map, "map", nil, "Map an input column to a table column, SOURCE=COLUMN (SOURCE= skips it; repeatable)")
+	importCmd.Flags().StringVar(&types.ImportOpts.Encoding, "encoding", "", "Encoding of the input (default UTF-8)")
+	importCmd.Flags().StringVar(&types.ImportOpts.Rejects, "rejects", "", "Write the rows that cannot be loaded to this file instead of failing")
+	importCmd.Flags().BoolVar(&types.ImportOpts.Create, "create", false, "Create the table from the inferred column types")
+	importCmd.Flags().IntVar(&types.ImportOpts.Sample, "sample", 1000, "Rows read to infer the types and the JSON keys")
+}
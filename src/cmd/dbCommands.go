@@ -12,6 +12,7 @@ import (
 	"pgtools/environment"
 	"pgtools/types"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
 	"github.com/spf13/cobra"
 )
 
@@ -20,7 +21,7 @@ var dbCmd = &cobra.Command{
 	Aliases: []string{"database"},
 	Short:   "Database sub-command",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Valid subcommands are: { show | backup | restore }")
+		fmt.Println("Valid subcommands are: { show | backup | restore | convert | validate | drill | clone | copy-table | diff | datadiff | create | alter | info | drop }")
 	},
 }
 
@@ -43,14 +44,31 @@ var backupCmd = &cobra.Command{
 }
 
 var restoreCmd = &cobra.Command{
-	Use:     "restore",
-	Short:   "Restores one or more databases",
+	Use:   "restore ARCHIVE [ARCHIVE ...]",
+	Short: "Restores one or more databases",
+	Long: `Restores pgtools archives (.sql, .sql.gz), pg_dump/pg_dumpall plain scripts and
+pg_dump custom-format archives (pg_dump -Fc). Use --list to show the contents of a custom-format archive.
+
+Ownership and privileges can be adjusted while restoring:
+  --no-owner             skip ALTER ... OWNER TO statements
+  --no-privileges        skip GRANT/REVOKE statements on objects
+  --owner-map OLD:NEW    give the objects owned by OLD to NEW (repeatable)
+  --role-map OLD:NEW     replace role OLD by NEW everywhere: owners, grantees, memberships (repeatable)`,
 	Aliases: []string{"load"},
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			fmt.Println("pgtools restore ARCHIVE_NAME")
 			os.Exit(1)
 		}
+		if types.RestoreList {
+			for _, arg := range args {
+				if err := db.ListArchive(arg); err != nil {
+					fmt.Printf("%s\n", err.Error())
+					os.Exit(err.Code)
+				}
+			}
+			return
+		}
 		cfg, err := environment.LoadConfig()
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
@@ -64,10 +82,204 @@ var restoreCmd = &cobra.Command{
 	},
 }
 
+var dbConvertCmd = &cobra.Command{
+	Use:   "convert INPUT.dump OUTPUT.sql[.gz]",
+	Short: "Convert a pg_dump custom-format archive to a pgtools archive",
+	Long: `Convert a pg_dump custom-format archive (pg_dump -Fc) into a plain SQL pgtools archive.
+If the output filename ends with .gz, the output is gzip-compressed.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := db.ConvertArchive(args[0], args[1]); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var dbValidateCmd = &cobra.Command{
+	Use:   "validate ARCHIVE [ARCHIVE ...]",
+	Short: "Check restored databases against the manifest of their backup",
+	Long: `Compare the databases restored from a pgtools archive with the manifest recorded by db backup:
+per-table row counts, sequence values, object counts by type and, if the backup was made with --hash,
+per-table content hashes. Use -e to pick the environment the archive was restored to.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		for _, arg := range args {
+			if err := db.ValidateArchive(cfg, arg); err != nil {
+				fmt.Printf("%s\n", err.Error())
+				os.Exit(err.Code)
+			}
+		}
+	},
+}
+
+var dbDrillCmd = &cobra.Command{
+	Use:   "drill ARCHIVE",
+	Short: "Restore an archive into a temporary database to prove it is restorable",
+	Long: `Restore ARCHIVE into a temporary database (pgtools_drill_XXXXXXXX) on the selected environment,
+validate it against the backup manifest, run the optional smoke-test SQL files, then drop the temporary database,
+whatever the outcome (including Ctrl-C). Timings and results are written to a JSON report.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := db.DrillArchive(cfg, args[0]); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var dbCloneCmd = &cobra.Command{
+	Use:   "clone SRCDB --to-env ENV [--as NEWNAME]",
+	Short: "Copy a database to another environment, without an intermediate file",
+	Long: `Copy SRCDB from the current environment (-e) to the environment given by --to-env.
+The schema is read from the source and replayed on the target, and table data is streamed between
+the two servers with COPY; nothing is written to disk. The source is read from a single consistent snapshot,
+even with --jobs. Ownership and privileges are not copied: the target objects belong to the target environment's user.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcCfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		dstCfg, err := environment.LoadEnvironment(types.CloneToEnv)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := db.CloneDatabase(srcCfg, dstCfg, args[0], types.CloneAs); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var dbCopyTableCmd = &cobra.Command{
+	Use:   "copy-table SRC_ENV:db.schema.table DST_ENV:db.schema.table",
+	Short: "Copy a table, or the result of a query, between databases or environments",
+	Long: `Stream the rows of a table (optionally filtered with --where) or of a --query from one database to another table,
+possibly on another environment. The ENV: prefix may be left out to use the current environment (-e), and the schema
+defaults to public. With --query, the source can be given as just ENV:db.
+The target is loaded in a single transaction: --create-target creates it from the source columns if missing,
+--truncate empties it first, and --upsert-on col1,col2 merges the rows on that key instead of appending them.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := db.ParseTableSpec(args[0], true)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		dst, err := db.ParseTableSpec(args[1], false)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		srcCfg, err := loadEnvOrCurrent(src.Env)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		dstCfg, err := loadEnvOrCurrent(dst.Env)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := db.CopyTable(srcCfg, dstCfg, src, dst); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var dbDiffCmd = &cobra.Command{
+	Use:   "diff ENV1:db1 ENV2:db2",
+	Short: "Compare the schemas of two databases",
+	Long: `Compare the schema of db2 with the schema of db1: schemas, extensions, types, sequences, functions, tables,
+columns, defaults, constraints, indexes, views and triggers. The ENV: prefix may be left out to use the current environment (-e).
+A summary of the differences is printed, followed by the SQL that brings db2 in line with db1 (or written to --output).
+The command exits with a non-zero status when the schemas differ.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		env1, db1, err := db.ParseDatabaseSpec(args[0])
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		env2, db2, err := db.ParseDatabaseSpec(args[1])
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		cfg1, err := loadEnvOrCurrent(env1)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		cfg2, err := loadEnvOrCurrent(env2)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := db.DiffSchemas(cfg1, cfg2, db1, db2); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var dbDataDiffCmd = &cobra.Command{
+	Use:   "datadiff ENV1:db1 ENV2:db2",
+	Short: "Compare the rows of two databases",
+	Long: `Check that db2 holds the same rows as db1, table by table. The ENV: prefix may be left out to use the current environment (-e).
+Tables are split into primary-key ranges of --chunk-size rows and each range is hashed on both servers; ranges that differ are narrowed down
+until the differing rows can be listed. Tables without a primary key are compared with a single hash.
+--repair writes the INSERT/UPDATE/DELETE statements that make db2 match db1. The command exits with a non-zero status when the data differs.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		env1, db1, err := db.ParseDatabaseSpec(args[0])
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		env2, db2, err := db.ParseDatabaseSpec(args[1])
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		cfg1, err := loadEnvOrCurrent(env1)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		cfg2, err := loadEnvOrCurrent(env2)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := db.DiffData(cfg1, cfg2, db1, db2); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
 var dbCreateCmd = &cobra.Command{
 	Use:   "create <dbname>",
 	Short: "Create an empty database",
-	Args:  cobra.ExactArgs(1),
+	Long: `Create an empty database. The CREATE DATABASE options (template, encoding, locales, tablespace, connection limit,
+strategy...) are checked against the server before anything is created. --grant ROLE[:PRIV,...] grants database privileges
+(CONNECT and TEMPORARY by default) once the database exists; --revoke-public removes the default PUBLIC access first.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := environment.LoadConfig()
 		if err != nil {
@@ -75,18 +287,75 @@ var dbCreateCmd = &cobra.Command{
 			os.Exit(err.Code)
 		}
 		name := args[0]
-		if nerr := db.CreateDatabase(cfg, name, types.CreateOwner); nerr != nil {
+		opts := types.CreateOpts
+		opts.Owner = types.CreateOwner
+		if cmd.Flags().Changed("connection-limit") {
+			opts.ConnectionLimit = &types.CreateConnLimit
+		}
+		created, nerr := db.CreateDatabaseWithOptions(cfg, name, &opts)
+		if nerr != nil {
 			fmt.Printf("%s\n", nerr.Error())
 			os.Exit(nerr.Code)
 		}
-		if types.CreateOwner != "" {
+		switch {
+		case !created:
+			fmt.Printf("Database %q already exists, skipped.\n", name)
+		case types.CreateOwner != "":
 			fmt.Printf("Database %q created with owner %q.\n", name, types.CreateOwner)
-		} else {
+		default:
 			fmt.Printf("Database %q created.\n", name)
 		}
 	},
 }
 
+var dbAlterCmd = &cobra.Command{
+	Use:   "alter <dbname>",
+	Short: "Rename a database or change its owner, limits, tablespace or settings",
+	Long: `Change the properties of an existing database. Several changes may be given at once; they are applied in order,
+the tablespace move and the rename last. Both of these need the database to have no other session: --force disconnects them first.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		opts := types.AlterOpts
+		if cmd.Flags().Changed("connection-limit") {
+			opts.ConnectionLimit = &types.AlterConnLimit
+		}
+		if cmd.Flags().Changed("allow-connections") {
+			opts.AllowConnections = &types.AlterAllowConnections
+		}
+		if cmd.Flags().Changed("is-template") {
+			opts.IsTemplate = &types.AlterIsTemplate
+		}
+		if nerr := db.AlterDatabase(cfg, args[0], &opts); nerr != nil {
+			fmt.Printf("%s\n", nerr.Error())
+			os.Exit(nerr.Code)
+		}
+	},
+}
+
+var dbInfoCmd = &cobra.Command{
+	Use:   "info <dbname>",
+	Short: "Show the full profile of a database",
+	Long: `Show the encoding, locale, owner, tablespace, size, connection limit and connections of a database,
+along with its settings, installed extensions, object counts, frozen XID age and last statistics reset.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if nerr := db.ShowDatabaseInfo(cfg, args[0], types.InfoJSON); nerr != nil {
+			fmt.Printf("%s\n", nerr.Error())
+			os.Exit(nerr.Code)
+		}
+	},
+}
+
 var dbDropCmd = &cobra.Command{
 	Use:   "drop <dbname>",
 	Short: "Drop a database",
@@ -111,17 +380,98 @@ var dbDropCmd = &cobra.Command{
 }
 
 func init() {
-	dbCmd.AddCommand(showCmd, backupCmd, restoreCmd, dbCreateCmd, dbDropCmd)
+	dbCmd.AddCommand(showCmd, backupCmd, restoreCmd, dbConvertCmd, dbValidateCmd, dbDrillCmd, dbCloneCmd, dbCopyTableCmd, dbDiffCmd, dbDataDiffCmd, dbCreateCmd, dbAlterCmd, dbInfoCmd, dbDropCmd)
 
 	backupCmd.PersistentFlags().BoolVarP(&types.UserRoles, "users", "u", false, "Backup global users/roles only")
 	backupCmd.PersistentFlags().BoolVarP(&types.AllDBs, "all", "a", false, "Backup all databases")
 	backupCmd.MarkFlagsMutuallyExclusive("all", "users")
+	backupCmd.Flags().BoolVar(&types.BackupHashes, "hash", false, "Record per-table content hashes in the manifest (reads every row twice)")
+	backupCmd.Flags().BoolVar(&types.BackupSchema, "schema", false, "Also write the DDL, so that the archive restores into an empty database")
 
 	restoreCmd.PersistentFlags().StringVarP(&types.LogLevel, "loglevel", "l", "error", "Log level: debug|info|error")
 	restoreCmd.PersistentFlags().BoolVarP(&types.UserRoles, "users", "u", false, "Backup global users/roles only")
+	restoreCmd.Flags().BoolVarP(&types.RestoreList, "list", "L", false, "List the contents of a custom-format archive instead of restoring it")
+	restoreCmd.Flags().StringVarP(&types.RestoreTarget, "target", "t", "", "Restore into this existing database instead of the one named in the archive")
+	restoreCmd.Flags().BoolVar(&types.RestoreNoOwner, "no-owner", false, "Do not restore object ownership")
+	restoreCmd.Flags().BoolVar(&types.RestoreNoPrivileges, "no-privileges", false, "Do not restore access privileges (GRANT/REVOKE)")
+	restoreCmd.Flags().StringArrayVar(&types.RestoreOwnerMap, "owner-map", nil, "Remap object owners, OLD:NEW (repeatable)")
+	restoreCmd.Flags().StringArrayVar(&types.RestoreRoleMap, "role-map", nil, "Remap role names in owners and grants, OLD:NEW (repeatable)")
+	restoreCmd.Flags().BoolVar(&types.RestoreValidate, "validate", false, "Check the restored databases against the archive manifest")
+	restoreCmd.Flags().StringVar(&types.ManifestFile, "manifest", "", "Manifest file to validate against (default: NAME.manifest.json next to NAME.sql[.gz])")
+	dbValidateCmd.Flags().StringVarP(&types.RestoreTarget, "target", "t", "", "Database the archive was restored into, if not the one it was backed up from")
+	dbValidateCmd.Flags().StringVar(&types.ManifestFile, "manifest", "", "Manifest file to validate against (default: NAME.manifest.json next to NAME.sql[.gz])")
+	dbDrillCmd.Flags().StringArrayVar(&types.DrillSmokeFiles, "smoke", nil, "SQL file to run against the restored database; any error fails the drill (repeatable)")
+	dbDrillCmd.Flags().StringVar(&types.DrillReport, "report", "", "JSON report file (default: drill-YYYYMMDD-HHMMSS.json)")
+	dbDrillCmd.Flags().StringVar(&types.ManifestFile, "manifest", "", "Manifest file to validate against (default: NAME.manifest.json next to NAME.sql[.gz])")
+	dbDrillCmd.Flags().BoolVar(&types.RestoreNoOwner, "no-owner", false, "Do not restore object ownership")
+	dbDrillCmd.Flags().BoolVar(&types.RestoreNoPrivileges, "no-privileges", false, "Do not restore access privileges (GRANT/REVOKE)")
+	dbDrillCmd.Flags().StringArrayVar(&types.RestoreOwnerMap, "owner-map", nil, "Remap object owners, OLD:NEW (repeatable)")
+	dbDrillCmd.Flags().StringArrayVar(&types.RestoreRoleMap, "role-map", nil, "Remap role names in owners and grants, OLD:NEW (repeatable)")
+	dbCloneCmd.Flags().StringVar(&types.CloneToEnv, "to-env", "", "Environment to clone the database to")
+	dbCloneCmd.Flags().StringVar(&types.CloneAs, "as", "", "Name of the database on the target (default: same as the source)")
+	dbCloneCmd.Flags().BoolVar(&types.CloneReplace, "replace", false, "Drop the target database first if it exists")
+	dbCloneCmd.Flags().BoolVar(&types.CloneSchemaOnly, "schema-only", false, "Copy the schema but no data")
+	addFilterFlags(dbCloneCmd)
+	dbCloneCmd.Flags().IntVarP(&types.Jobs, "jobs", "j", 1, "Number of tables copied in parallel")
+	_ = dbCloneCmd.MarkFlagRequired("to-env")
+	dbCopyTableCmd.Flags().StringVar(&types.CopyWhere, "where", "", "Only copy the source rows matching this condition")
+	dbCopyTableCmd.Flags().StringVar(&types.CopyQuery, "query", "", "Copy the result of this query instead of a table")
+	dbCopyTableCmd.Flags().BoolVar(&types.CopyCreateTarget, "create-target", false, "Create the target table from the source columns if it does not exist")
+	dbCopyTableCmd.Flags().BoolVar(&types.CopyTruncate, "truncate", false, "Empty the target table before loading")
+	dbCopyTableCmd.Flags().StringVar(&types.CopyUpsertOn, "upsert-on", "", "Merge on these key columns (comma-separated) instead of appending")
+	dbCopyTableCmd.MarkFlagsMutuallyExclusive("where", "query")
+	dbDiffCmd.Flags().StringVarP(&types.DiffOutput, "output", "o", "", "Write the migration SQL to this file instead of the terminal")
+	addFilterFlags(dbDiffCmd)
+	dbDataDiffCmd.Flags().IntVar(&types.DataDiffChunkSize, "chunk-size", 10000, "Number of rows per hashed primary-key range")
+	dbDataDiffCmd.Flags().StringVar(&types.DataDiffRepair, "repair", "", "Write the SQL that makes the second database match the first to this file")
+	dbDataDiffCmd.Flags().IntVar(&types.DataDiffMaxRows, "max-rows", 20, "Number of differing rows shown per table")
+	addFilterFlags(dbDataDiffCmd)
+	dbConvertCmd.Flags().StringVarP(&types.RestoreTarget, "target", "t", "", "Have the converted archive load into this existing database")
 	showCmd.PersistentFlags().BoolVarP(&types.Quiet, "quiet", "q", false, "Silent output")
 	dbCreateCmd.Flags().StringVarP(&types.CreateOwner, "owner", "o", "", "Owner role for the new database")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.Template, "template", "", "Template database to copy (default: template1)")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.Encoding, "encoding", "", "Character set encoding, e.g. UTF8")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.Locale, "locale", "", "Sets both LC_COLLATE and LC_CTYPE")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.LcCollate, "lc-collate", "", "Collation order (LC_COLLATE)")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.LcCtype, "lc-ctype", "", "Character classification (LC_CTYPE)")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.IcuLocale, "icu-locale", "", "Use the ICU provider with this locale (PostgreSQL 15+)")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.Tablespace, "tablespace", "", "Default tablespace of the new database")
+	dbCreateCmd.Flags().IntVar(&types.CreateConnLimit, "connection-limit", -1, "Maximum concurrent connections, -1 for no limit")
+	dbCreateCmd.Flags().BoolVar(&types.CreateOpts.IsTemplate, "is-template", false, "Mark the new database as a template")
+	dbCreateCmd.Flags().StringVar(&types.CreateOpts.Strategy, "strategy", "", "How the template is copied: wal_log or file_copy (PostgreSQL 15+)")
+	dbCreateCmd.Flags().BoolVar(&types.CreateOpts.IfNotExists, "if-not-exists", false, "Do nothing if the database already exists")
+	dbCreateCmd.Flags().StringArrayVar(&types.CreateOpts.Grants, "grant", nil, "Grant database privileges, ROLE[:PRIV,...] (repeatable; default CONNECT,TEMPORARY)")
+	dbCreateCmd.Flags().BoolVar(&types.CreateOpts.RevokePublic, "revoke-public", false, "Revoke the default PUBLIC privileges on the new database")
+
+	// alter flags
+	dbAlterCmd.Flags().StringVar(&types.AlterOpts.RenameTo, "rename", "", "New name of the database")
+	dbAlterCmd.Flags().StringVarP(&types.AlterOpts.Owner, "owner", "o", "", "New owner role")
+	dbAlterCmd.Flags().IntVar(&types.AlterConnLimit, "connection-limit", -1, "Maximum concurrent connections, -1 for no limit")
+	dbAlterCmd.Flags().BoolVar(&types.AlterAllowConnections, "allow-connections", true, "Allow or refuse new connections (--allow-connections=false)")
+	dbAlterCmd.Flags().BoolVar(&types.AlterIsTemplate, "is-template", false, "Mark or unmark the database as a template (--is-template=false)")
+	dbAlterCmd.Flags().StringVar(&types.AlterOpts.Tablespace, "tablespace", "", "Move the database to this tablespace")
+	dbAlterCmd.Flags().StringArrayVar(&types.AlterOpts.Set, "set", nil, "Set a configuration parameter for the database, name=value (repeatable)")
+	dbAlterCmd.Flags().StringArrayVar(&types.AlterOpts.Reset, "reset", nil, "Reset a configuration parameter, or ALL (repeatable)")
+	dbAlterCmd.Flags().BoolVarP(&types.AlterOpts.Force, "force", "f", false, "Disconnect the sessions before renaming or moving the database")
+
+	dbInfoCmd.Flags().BoolVar(&types.InfoJSON, "json", false, "Print the profile as JSON")
 
 	// drop flags
 	dbDropCmd.Flags().BoolVarP(&types.DropForce, "force", "f", false, "Force drop by disconnecting sessions")
 }
+
+// loadEnvOrCurrent loads the named environment, or the current one (-e) when env is empty
+func loadEnvOrCurrent(env string) (*types.DBConfig, *ce.CustomError) {
+	if env == "" {
+		return environment.LoadConfig()
+	}
+	return environment.LoadEnvironment(env)
+}
+
+// addFilterFlags registers the schema/table selection flags shared by the commands that walk a whole database
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&types.FilterSchemas, "schema", "n", nil, "Only include schemas matching this pattern (repeatable)")
+	cmd.Flags().StringArrayVarP(&types.FilterExcludeSchemas, "exclude-schema", "N", nil, "Exclude schemas matching this pattern (repeatable)")
+	cmd.Flags().StringArrayVarP(&types.FilterTables, "table", "t", nil, "Only include tables matching this pattern, schema.table or table (repeatable)")
+	cmd.Flags().StringArrayVarP(&types.FilterExcludeTables, "exclude-table", "T", nil, "Exclude tables matching this pattern (repeatable)")
+}
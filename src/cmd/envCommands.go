@@ -8,10 +8,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"pgtools/diag"
 	"pgtools/environment"
 	"pgtools/types"
 	"strings"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
 	"github.com/spf13/cobra"
 )
 
@@ -19,7 +21,7 @@ var envCmd = &cobra.Command{
 	Use:   "env",
 	Short: "Environment sub-command",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Valid subcommands are: { show | add | remove | info }")
+		fmt.Println("Valid subcommands are: { show | add | edit | set | copy | rename | remove | info | test | rekey | lock | unlock }")
 	},
 }
 
@@ -41,17 +43,98 @@ var envListCmd = &cobra.Command{
 var envAddCmd = &cobra.Command{
 	Use:     "add",
 	Aliases: []string{"create"},
-	Example: "pgtools env add [FILE[.json]]",
+	Example: "pgtools env add [FILE[.json]]\npgtools env add prod --host db1 --user admin --password-stdin --sslmode verify-full < secret",
 	Short:   "Adds the env FILE",
 	Long: `The extension (.json) is implied and will be added if missing.
-The default defaultEnv.json file will be used if no filename is provided.`,
+The default defaultEnv.json file will be used if no filename is provided.
+Without flags, the values are prompted for; with flags, the file is created from them (port 5432 and sslmode
+prefer by default) and an existing file is only replaced with --force.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			types.EnvConfigFile = "defaultEnv.json"
 		} else {
 			types.EnvConfigFile = strings.TrimSuffix(args[0], ".json") + ".json"
 		}
-		if err := environment.CreateConfig(); err != nil {
+		prompt := true
+		for _, name := range envAddFlags {
+			if cmd.Flags().Changed(name) {
+				prompt = false
+			}
+		}
+		var err *ce.CustomError
+		if prompt {
+			err = environment.CreateConfig()
+		} else {
+			err = environment.AddConfig(types.EnvConfigFile)
+		}
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// the env add flags that skip the prompts
+var envAddFlags = []string{"host", "port", "user", "password", "password-stdin", "sslmode", "sslrootcert", "sslcert", "sslkey", "sslpassword", "defaultdb", "comment",
+	"ssh-host", "ssh-port", "ssh-user", "ssh-key", "ssh-known-hosts", "ssh-jump"}
+
+// Edit a config file
+var envEditCmd = &cobra.Command{
+	Use:     "edit",
+	Example: "pgtools env edit FILE[.json]",
+	Short:   "Edits the env FILE with $EDITOR",
+	Long:    `The file is opened decrypted in $VISUAL or $EDITOR (vi by default), and saved back encrypted once it is valid.`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.EditConfig(args[0]); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// Set values in a config file
+var envSetCmd = &cobra.Command{
+	Use:     "set",
+	Example: "pgtools env set FILE[.json] key=value...\npgtools env set prod port=5433 sslmode=verify-full\necho $SECRET | pgtools env set prod password=-",
+	Short:   "Sets values in the env FILE",
+	Long: `The keys are those of the env file: host, port, user, password, sslmode, sslrootcert, sslclientcert (sslcert),
+sslclientkey (sslkey), sslpassword, comment (description) and defaultdb, and for the SSH tunnel ssh.host, ssh.port,
+ssh.user, ssh.keyfile, ssh.knownhosts and ssh.jump (comma-separated). An empty value clears the key (an empty ssh.host
+removes the tunnel); password=- and sslpassword=- read the value from stdin.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.SetValues(args[0], args[1:]); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// Copy a config file
+var envCopyCmd = &cobra.Command{
+	Use:     "copy",
+	Aliases: []string{"cp"},
+	Example: "pgtools env copy SRC[.json] DST[.json]",
+	Short:   "Copies the env SRC to DST",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.CopyConfig(args[0], args[1]); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// Rename a config file
+var envRenameCmd = &cobra.Command{
+	Use:     "rename",
+	Aliases: []string{"mv"},
+	Example: "pgtools env rename SRC[.json] DST[.json]",
+	Short:   "Renames the env SRC to DST",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.RenameConfig(args[0], args[1]); err != nil {
 			fmt.Printf("%s\n", err.Error())
 			os.Exit(err.Code)
 		}
@@ -77,6 +160,28 @@ var envInfoCmd = &cobra.Command{
 	},
 }
 
+// Test the connection to the servers
+var envTestCmd = &cobra.Command{
+	Use:     "test",
+	Aliases: []string{"check"},
+	Example: "pgtools env test [FILE1[.json] FILE2[.json]...] [-o json]",
+	Short:   "Diagnoses the connection to the env FILE[12n] servers",
+	Long: `Reports, for each env file, the DNS resolution, the TCP connect time, the TLS version and cipher, the server
+certificate chain with its expiry and the chain and hostname verification, the authentication method the server
+asks for, the server version and the round-trip latency. The current env file (-e) is tested without arguments.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		envfiles := []string{types.EnvConfigFile}
+		if len(args) != 0 {
+			envfiles = args
+		}
+		if err := diag.TestEnvironments(envfiles, types.EnvTestFormat); err != nil {
+			// keep the JSON on stdout parsable
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
 // Remove a config file
 var envRmCmd = &cobra.Command{
 	Use:     "rm",
@@ -97,6 +202,73 @@ var envRmCmd = &cobra.Command{
 	},
 }
 
+// Re-encrypt the passwords with a new key
+var envRekeyCmd = &cobra.Command{
+	Use:     "rekey",
+	Example: "pgtools env rekey",
+	Short:   "Re-encrypts all env passwords with a new key",
+	Long: `A new key file is generated, or a new master passphrase is asked for when the env files are locked.
+Every env file is then re-encrypted with it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.Rekey(); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// Protect the passwords with a master passphrase
+var envLockCmd = &cobra.Command{
+	Use:     "lock",
+	Example: "pgtools env lock",
+	Short:   "Protects the env passwords with a master passphrase",
+	Long: `The key is derived from the passphrase (argon2id) instead of being read from the key file, which is removed.
+The passphrase is then asked for whenever an env file is read, unless $PGTOOLS_PASSPHRASE is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.Lock(); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// Go back to the key file
+var envUnlockCmd = &cobra.Command{
+	Use:     "unlock",
+	Example: "pgtools env unlock",
+	Short:   "Protects the env passwords with a key file instead of a passphrase",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := environment.Unlock(); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
 func init() {
-	envCmd.AddCommand(envRmCmd, envInfoCmd, envAddCmd, envListCmd)
+	envCmd.AddCommand(envRmCmd, envInfoCmd, envAddCmd, envListCmd, envTestCmd, envEditCmd, envSetCmd, envCopyCmd, envRenameCmd,
+		envRekeyCmd, envLockCmd, envUnlockCmd)
+
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.Host, "host", "", "Server hostname")
+	envAddCmd.Flags().IntVar(&types.EnvAddConfig.Port, "port", 0, "Server port (default 5432)")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.User, "user", "", "User name")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.Password, "password", "", "Password (visible in the process list; prefer --password-stdin)")
+	envAddCmd.Flags().BoolVar(&types.EnvPasswordStdin, "password-stdin", false, "Read the password from stdin")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.SSLMode, "sslmode", "", "SSL mode: disable|allow|prefer|require|verify-ca|verify-full (default prefer)")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.SSLRootCert, "sslrootcert", "", "Path to the CA certificate")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.SSLclientCert, "sslcert", "", "Path to the client SSL certificate")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.SSLclientKey, "sslkey", "", "Path to the client SSL key")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.SSLPassword, "sslpassword", "", "Passphrase of an encrypted client SSL key")
+	envAddCmd.Flags().StringVar(&types.EnvAddSSH.Host, "ssh-host", "", "SSH host to tunnel through")
+	envAddCmd.Flags().IntVar(&types.EnvAddSSH.Port, "ssh-port", 0, "SSH port (default 22)")
+	envAddCmd.Flags().StringVar(&types.EnvAddSSH.User, "ssh-user", "", "SSH user (default $USER)")
+	envAddCmd.Flags().StringVar(&types.EnvAddSSH.KeyFile, "ssh-key", "", "SSH private key (default: the SSH agent and ~/.ssh keys)")
+	envAddCmd.Flags().StringVar(&types.EnvAddSSH.KnownHosts, "ssh-known-hosts", "", "known_hosts file (default ~/.ssh/known_hosts)")
+	envAddCmd.Flags().StringSliceVar(&types.EnvAddSSH.Jump, "ssh-jump", nil, "Jump hosts to cross first, as [user@]host[:port], comma-separated")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.DefaultDB, "defaultdb", "", "Default database to fall back on")
+	envAddCmd.Flags().StringVar(&types.EnvAddConfig.Description, "comment", "", "Brief description or comment")
+	envTestCmd.Flags().StringVarP(&types.EnvTestFormat, "format", "o", "table", "Output format: table|json")
+	for _, c := range []*cobra.Command{envAddCmd, envCopyCmd, envRenameCmd} {
+		c.Flags().BoolVarP(&types.EnvForce, "force", "f", false, "Replace an existing env file")
+	}
 }
@@ -0,0 +1,31 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/28 11:40
+// Original filename: src/cmd/fleet.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"pgtools/fleet"
+	"pgtools/shared"
+	"pgtools/types"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// runOnFleet runs the task on every environment named by -e, and prints their rows in one table
+func runOnFleet(header table.Row, task fleet.Task) {
+	ctx, cancel := shared.CancellableContext()
+	defer cancel()
+
+	results, err := fleet.Run(ctx, types.EnvConfigFile, task)
+	if err == nil {
+		err = fleet.Render(header, results)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(err.Code)
+	}
+}
@@ -0,0 +1,128 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 16:40
+// Original filename: src/cmd/migrateCommands.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"pgtools/environment"
+	"pgtools/migrate"
+	"pgtools/types"
+	"strconv"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Versioned schema migrations",
+	Long: `Apply the numbered .sql files of a directory (--dir, ./migrations by default) and record them, with their checksum,
+in the pgtools_schema_migrations table. Files are named 0001_create_users.sql (or .up.sql), with an optional
+0001_create_users.down.sql to revert them. Each file runs in its own transaction, unless it contains the line
+-- pgtools:no-transaction (for CREATE INDEX CONCURRENTLY and the like).
+An advisory lock keeps concurrent runs from colliding, and an applied file that was edited since stops the run.
+--safe-ddl runs the DDL of the files as pgtools sql --safe-ddl does.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Valid subcommands are: { up | down | status | goto | baseline }")
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up [N]",
+	Short: "Apply the pending migrations, or the next N",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n := migrateCount(args, 0)
+		runMigration(func(cfg *types.DBConfig, dbname string) *ce.CustomError { return migrate.Up(cfg, dbname, n) })
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [N]",
+	Short: "Revert the latest migration, or the latest N",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n := migrateCount(args, 1)
+		runMigration(func(cfg *types.DBConfig, dbname string) *ce.CustomError { return migrate.Down(cfg, dbname, n) })
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the migrations and whether they are applied, pending or modified",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMigration(migrate.Status)
+	},
+}
+
+var migrateGotoCmd = &cobra.Command{
+	Use:   "goto VERSION",
+	Short: "Migrate up or down to VERSION (0 reverts everything)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version := migrateVersion(args[0])
+		runMigration(func(cfg *types.DBConfig, dbname string) *ce.CustomError { return migrate.Goto(cfg, dbname, version) })
+	},
+}
+
+var migrateBaselineCmd = &cobra.Command{
+	Use:   "baseline VERSION",
+	Short: "Mark the migrations up to VERSION as applied without running them",
+	Long: `Mark the migrations up to VERSION as applied without running them, for a database whose schema was created before
+it was managed with migrations. Baselined migrations cannot be reverted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version := migrateVersion(args[0])
+		runMigration(func(cfg *types.DBConfig, dbname string) *ce.CustomError {
+			return migrate.Baseline(cfg, dbname, version)
+		})
+	},
+}
+
+func runMigration(run func(cfg *types.DBConfig, dbname string) *ce.CustomError) {
+	cfg, err := environment.LoadConfig()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(err.Code)
+	}
+	if err := run(cfg, defaultDatabase(types.MigrateDatabase, cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(err.Code)
+	}
+}
+
+func migrateCount(args []string, fallback int) int {
+	if len(args) == 0 {
+		return fallback
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		fmt.Fprintf(os.Stderr, "%q: expected a positive number of migrations\n", args[0])
+		os.Exit(1)
+	}
+	return n
+}
+
+func migrateVersion(arg string) int64 {
+	v, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || v < 0 {
+		fmt.Fprintf(os.Stderr, "%q: expected a migration version\n", arg)
+		os.Exit(1)
+	}
+	return v
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd, migrateGotoCmd, migrateBaselineCmd)
+
+	migrateCmd.PersistentFlags().StringVarP(&types.MigrateDatabase, "dbname", "D", "", "Database to migrate")
+	migrateCmd.PersistentFlags().StringVar(&types.MigrateDir, "dir", "migrations", "Directory of the migration files")
+	addSafeDDLFlags(migrateCmd.PersistentFlags())
+	migrateUpCmd.Flags().BoolVar(&types.MigrateOutOfOrder, "out-of-order", false, "Also apply pending migrations older than the latest applied one")
+	migrateGotoCmd.Flags().BoolVar(&types.MigrateOutOfOrder, "out-of-order", false, "Also apply pending migrations older than the latest applied one")
+}
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"pgtools/environment"
@@ -13,6 +14,8 @@ import (
 	"pgtools/roles"
 	"pgtools/types"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -28,6 +31,12 @@ var rolesListCmd = &cobra.Command{
 	Short: "List roles",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, _ []string) {
+		if environment.IsFleet(types.EnvConfigFile) {
+			runOnFleet(roles.RoleHeader(types.ListMembers, types.ListVerbose), func(_ context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError) {
+				return roles.RoleRows(cfg, types.ListMembers, types.ListVerbose)
+			})
+			return
+		}
 		cfg, err := environment.LoadConfig()
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
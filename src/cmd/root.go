@@ -38,12 +38,18 @@ func Execute() {
 func init() {
 	rootCmd.DisableAutoGenTag = true
 	rootCmd.CompletionOptions.DisableDefaultCmd = false
-	rootCmd.AddCommand(completionCmd, clCmd, envCmd, dbCmd, rolesCmd, srvCmd, showCmd, confCmd)
+	rootCmd.AddCommand(completionCmd, clCmd, envCmd, dbCmd, rolesCmd, srvCmd, showCmd, confCmd, sqlCmd, shellCmd, exportCmd, importCmd, migrateCmd)
 
 	rootCmd.PersistentFlags().StringVarP(&types.AppNameKV, "appname", "A", "pgtools", "Application name as the server should it")
 	rootCmd.PersistentFlags().StringVarP(&types.LogLevel, "loglevel", "l", "none", "Log level: none|debug|info|error")
 	rootCmd.PersistentFlags().BoolVarP(&types.DebugMode, "debug", "d", false, "Enable debug mode")
-	rootCmd.PersistentFlags().StringVarP(&types.EnvConfigFile, "env", "e", "defaultEnv.json", "Default environment configuration file; this is a per-user setting.")
+	rootCmd.PersistentFlags().StringVarP(&types.EnvConfigFile, "env", "e", "defaultEnv.json", "Environment configuration file; this is a per-user setting. show dbs, show sessions, conf get, roles show and srv version also take a list, glob or fleet group")
+	rootCmd.PersistentFlags().StringVar(&types.ConnFlags.Service, "service", "", "pg_service.conf entry to connect with (instead of the default env file)")
+	rootCmd.PersistentFlags().StringVar(&types.ConnFlags.Host, "host", "", "Server host, overriding the env file")
+	rootCmd.PersistentFlags().IntVar(&types.ConnFlags.Port, "port", 0, "Server port, overriding the env file")
+	rootCmd.PersistentFlags().StringVar(&types.ConnFlags.User, "user", "", "User name, overriding the env file")
+	rootCmd.PersistentFlags().StringVar(&types.ConnFlags.SSLMode, "sslmode", "", "SSL mode, overriding the env file")
+	rootCmd.PersistentFlags().IntVar(&types.FleetParallel, "parallel", 4, "How many environments a fleet command (-e a,b or -e 'prod*') talks to at once")
 }
 
 func changeLog() {
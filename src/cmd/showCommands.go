@@ -12,7 +12,10 @@ import (
 	"pgtools/environment"
 	"pgtools/shared"
 	"pgtools/show"
+	"pgtools/types"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -29,6 +32,17 @@ var showDBsCmd = &cobra.Command{
 	Aliases: []string{"databases", "db"},
 	Short:   "List all accessible databases",
 	Run: func(cmd *cobra.Command, args []string) {
+		if environment.IsFleet(types.EnvConfigFile) {
+			runOnFleet(table.Row{"Database", "Size"}, func(_ context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError) {
+				dbs, err := show.CollectDatabases(cfg, show.SortBySize)
+				var rows []table.Row
+				for _, r := range dbs {
+					rows = append(rows, table.Row{r.Name, shared.HumanizeBytes(r.SizeBytes)})
+				}
+				return rows, err
+			})
+			return
+		}
 		cfg, err := environment.LoadConfig()
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
@@ -115,6 +129,17 @@ var showSessionsCmd = &cobra.Command{
 	Aliases: []string{"activity"},
 	Short:   "Show active sessions (pg_stat_activity)",
 	Run: func(cmd *cobra.Command, args []string) {
+		if environment.IsFleet(types.EnvConfigFile) {
+			runOnFleet(show.SessionsHeader, func(ctx context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError) {
+				pool, err := shared.OpenPool(ctx, cfg, "postgres")
+				if err != nil {
+					return nil, err
+				}
+				defer pool.Close()
+				return show.CollectSessions(ctx, pool)
+			})
+			return
+		}
 		ctx, cancel := shared.CancellableContext()
 		defer cancel()
 
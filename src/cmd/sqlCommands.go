@@ -0,0 +1,106 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 16:20
+// Original filename: src/cmd/sqlCommands.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"pgtools/environment"
+	"pgtools/query"
+	"pgtools/shell"
+	"pgtools/types"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var sqlCmd = &cobra.Command{
+	Use:   "sql [-D DB] { \"STATEMENTS\" | -f FILE }",
+	Short: "Run SQL statements and print their results",
+	Long: `Run one or more SQL statements, given as an argument or read from a script (-f, "-" for stdin), and print their results
+as a table, CSV, TSV, JSON, JSON Lines or Markdown. Statements run one at a time and the first failure stops the script;
+with --tx the whole script runs in a single transaction that is rolled back on failure, and run again from the start
+(up to 5 times) when the server aborts it over a serialization failure or a deadlock.
+psql-style variables are set with -v name=value (or \set in the script) and referenced as :name, :'name' (a quoted
+literal) or :"name" (a quoted identifier).
+With --safe-ddl, DDL statements wait at most --lock-timeout for their locks instead of queueing behind long
+transactions (and blocking every query behind them); they are retried with a growing, jittered pause, and the sessions
+that held the locks are reported after each failed attempt.
+The database is given with -D since -d is the global --debug flag; it defaults to the environment's default database.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 0) == (types.SQLFile == "") {
+			return fmt.Errorf("give the statements as one argument or use -f FILE")
+		}
+		if len(args) > 1 && types.DebugMode {
+			// "sql -d shop 'SELECT ...'" turns on debugging and leaves the database name as an argument
+			return fmt.Errorf("the database is given with -D; -d is the global --debug flag")
+		}
+		return cobra.MaximumNArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := query.RunSQL(cfg, defaultDatabase(types.SQLDatabase, cfg), strings.Join(args, " ")); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell [-D DB]",
+	Short: "Interactive SQL shell",
+	Long: `Start an interactive SQL shell with line editing, a persistent history and Tab completion of schema, table and
+column names. Statements end with a semicolon and may span several lines; Ctrl-C cancels a running statement or drops
+the one being typed. Type \? for the meta-commands (\d, \dt, \dn, \du, \l, \c, \x, \timing...), \q or Ctrl-D to quit.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := environment.LoadConfig()
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+		if err := shell.Run(cfg, defaultDatabase(types.ShellDatabase, cfg)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(err.Code)
+		}
+	},
+}
+
+// defaultDatabase is the -D database, else the environment's default database, else postgres
+func defaultDatabase(dbname string, cfg *types.DBConfig) string {
+	if dbname == "" {
+		dbname = cfg.DefaultDB
+	}
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	return dbname
+}
+
+// addSafeDDLFlags adds the --safe-ddl flags, shared by sql and migrate
+func addSafeDDLFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&types.SafeDDL.Enabled, "safe-ddl", false, "Run DDL with a short lock_timeout, retrying when the lock is not obtained")
+	flags.DurationVar(&types.SafeDDL.LockTimeout, "lock-timeout", 2*time.Second, "How long DDL waits for its locks with --safe-ddl")
+	flags.IntVar(&types.SafeDDL.Retries, "ddl-retries", 5, "How many times DDL is retried with --safe-ddl")
+}
+
+func init() {
+	sqlCmd.Flags().StringVarP(&types.SQLDatabase, "dbname", "D", "", "Database to connect to (-d is --debug)")
+	sqlCmd.Flags().StringVarP(&types.SQLFile, "file", "f", "", "Read the statements from this script (- for stdin)")
+	sqlCmd.Flags().StringVarP(&types.SQLFormat, "format", "o", "table", "Output format: "+strings.Join(query.Formats, "|"))
+	sqlCmd.Flags().StringArrayVarP(&types.SQLVars, "var", "v", nil, "Set a variable, name=value (repeatable)")
+	sqlCmd.Flags().BoolVar(&types.SQLTransaction, "tx", false, "Run the whole script in a single transaction")
+	sqlCmd.Flags().StringVar(&types.SQLNull, "null", "", "How NULLs are shown in the text formats")
+	addSafeDDLFlags(sqlCmd.Flags())
+
+	shellCmd.Flags().StringVarP(&types.ShellDatabase, "dbname", "D", "", "Database to connect to")
+}
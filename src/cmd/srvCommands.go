@@ -6,13 +6,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"pgtools/environment"
 	"pgtools/srv"
 	"pgtools/types"
 
+	ce "github.com/jeanfrancoisgratton/customError/v2"
 	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -35,6 +38,16 @@ var srvVerCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Shows the database server version",
 	Run: func(cmd *cobra.Command, args []string) {
+		if environment.IsFleet(types.EnvConfigFile) {
+			runOnFleet(table.Row{"Server", "Port", "Version"}, func(_ context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError) {
+				srvinfo, err := srv.ShowDBServerVersion(cfg)
+				if err != nil {
+					return nil, err
+				}
+				return []table.Row{{srvinfo.ServerName, srvinfo.ServerPort, srvinfo.Version}}, nil
+			})
+			return
+		}
 		cfg, err := environment.LoadConfig()
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
@@ -12,23 +12,31 @@ import (
 	"github.com/jedib0t/go-pretty/v6/text"
 )
 
+// Header names the columns of the rows made by TableRow
+var Header = table.Row{"Name", "Setting", "Unit", "Source", "Category", "Description"}
+
 // Render prints a compact table, similar styling to ListEnvironments().
 // Also truncates the Description column to 40 characters with "...".
 func Render(rows []Row) {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Name", "Setting", "Unit", "Source", "Category", "Description"})
+	t.AppendHeader(Header)
 	for _, r := range rows {
-		cat := r.Category
-		desc := r.ShortDesc
-		if !FullOutput {
-			cat = ellipsize(cat, 30)
-			desc = ellipsize(desc, 40)
-		}
-		t.AppendRow(table.Row{r.Name, r.Setting, r.Unit, r.Source, cat, desc})
+		t.AppendRow(TableRow(r))
 	}
 	t.SortBy([]table.SortBy{{Name: "Name", Mode: table.Asc}})
 	t.SetStyle(table.StyleBold)
 	t.Style().Format.Header = text.FormatDefault
 	t.Render()
 }
+
+// TableRow formats a setting as Render shows it
+func TableRow(r Row) table.Row {
+	cat := r.Category
+	desc := r.ShortDesc
+	if !FullOutput {
+		cat = ellipsize(cat, 30)
+		desc = ellipsize(desc, 40)
+	}
+	return table.Row{r.Name, r.Setting, r.Unit, r.Source, cat, desc}
+}
@@ -0,0 +1,310 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 16:10
+// Original filename: src/dataio/export.go
+
+package dataio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pgtools/db"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+var sizeRx = regexp.MustCompile(`(?i)^\s*([0-9]+)\s*([KMGT]?)i?B?\s*$`)
+
+// exportFile is one written file
+type exportFile struct {
+	path  string
+	rows  int64
+	bytes int64
+}
+
+// Export writes a table, or the result of a query, to CSV, JSON Lines or Parquet; the output is split into
+// numbered files when a chunk size or row count is given.
+func Export(cfg *types.DBConfig, dbname string, opts *types.ExportOptions) *ce.CustomError {
+	logging.Debugf("Entering function: Export(%s)", dbname)
+	chunkSize, err := checkExportOptions(opts)
+	if err != nil {
+		return err
+	}
+	query, err := exportQuery(opts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Connect(cfg, dbname)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	defer conn.Close(ctx)
+	failed := func(e error) *ce.CustomError {
+		return &ce.CustomError{Code: 772, Title: "Export failed", Message: e.Error()}
+	}
+
+	for _, s := range shared.TextOutputSettings {
+		if _, e := conn.Exec(ctx, s); e != nil {
+			return failed(e)
+		}
+	}
+	// The simple protocol streams every value in its text form, which the writers convert as needed
+	rows, e := conn.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+	if e != nil {
+		return failed(e)
+	}
+	defer rows.Close()
+	var cols []column
+	for _, fd := range rows.FieldDescriptions() {
+		cols = append(cols, newColumn(fd))
+	}
+
+	x := &exporter{opts: opts, cols: cols, chunkSize: chunkSize}
+	defer x.abort()
+	for rows.Next() {
+		raw := rows.RawValues()
+		row := make([]*string, len(raw))
+		for i, v := range raw {
+			if v != nil {
+				s := string(v)
+				row[i] = &s
+			}
+		}
+		if e := x.write(row); e != nil {
+			return failed(e)
+		}
+	}
+	if e := rows.Err(); e != nil {
+		return failed(e)
+	}
+	if e := x.finish(); e != nil {
+		return failed(e)
+	}
+
+	// the report goes to stderr when the data goes to stdout
+	report := os.Stdout
+	if opts.Output == "-" {
+		report = os.Stderr
+	}
+	var total int64
+	for _, f := range x.files {
+		total += f.rows
+		if f.path != "-" {
+			fmt.Fprintf(report, "%s: %d rows, %s\n", f.path, f.rows, shared.HumanizeBytes(f.bytes))
+		}
+	}
+	logging.Infof("Exported %d rows to %d file(s)", total, len(x.files))
+	return nil
+}
+
+// checkExportOptions validates the options, filling in the format from the output extension, and returns the chunk
+// size in bytes
+func checkExportOptions(opts *types.ExportOptions) (int64, *ce.CustomError) {
+	invalid := func(format string, args ...any) (int64, *ce.CustomError) {
+		return 0, &ce.CustomError{Code: 770, Title: "Invalid export option", Message: fmt.Sprintf(format, args...)}
+	}
+
+	if (opts.Table == "") == (opts.Query == "") {
+		return invalid("give either --table or --query")
+	}
+	if opts.Where != "" && opts.Table == "" {
+		return invalid("--where only applies to --table; put the condition in the --query")
+	}
+	if opts.Format == "" {
+		switch strings.ToLower(filepath.Ext(opts.Output)) {
+		case ".jsonl", ".ndjson":
+			opts.Format = "jsonl"
+		case ".parquet":
+			opts.Format = "parquet"
+		default:
+			opts.Format = "csv"
+		}
+	}
+	switch opts.Format {
+	case "csv", "jsonl", "parquet":
+	default:
+		return invalid("--format %q: expected csv, jsonl or parquet", opts.Format)
+	}
+	if opts.Numeric != "string" && opts.Numeric != "decimal" {
+		return invalid("--numeric %q: expected string or decimal", opts.Numeric)
+	}
+
+	if opts.Delimiter == `\t` {
+		opts.Delimiter = "\t"
+	}
+	if len([]rune(opts.Delimiter)) != 1 || len([]rune(opts.Quote)) != 1 || opts.Delimiter == opts.Quote {
+		return invalid("--delimiter and --quote must be two different single characters")
+	}
+	if strings.ContainsAny(opts.Delimiter+opts.Quote, "\r\n") {
+		return invalid("--delimiter and --quote cannot be line breaks")
+	}
+
+	var chunkSize int64
+	if opts.ChunkSize != "" {
+		var ok bool
+		if chunkSize, ok = parseSize(opts.ChunkSize); !ok {
+			return invalid("--chunk-size %q: expected a size such as 500MB or 2GB", opts.ChunkSize)
+		}
+	}
+	if opts.ChunkRows < 0 || (opts.ChunkSize != "" && chunkSize <= 0) {
+		return invalid("the chunk size and row count must be positive")
+	}
+	if opts.Output == "-" && (chunkSize > 0 || opts.ChunkRows > 0) {
+		return invalid("chunked exports need an --output file name")
+	}
+	if opts.Format == "parquet" && !parquetSupported {
+		return invalid("this pgtools was built without Parquet support; rebuild it with go build -tags parquet")
+	}
+	if opts.Output == "-" && opts.Format == "parquet" {
+		return invalid("Parquet exports need an --output file name")
+	}
+	return chunkSize, nil
+}
+
+// parseSize reads 500MB, 2GB, 64k...; a size without a unit is in bytes
+func parseSize(size string) (int64, bool) {
+	m := sizeRx.FindStringSubmatch(size)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if m[2] != "" {
+		n <<= 10 * (strings.Index("KMGT", strings.ToUpper(m[2])) + 1)
+	}
+	return n, true
+}
+
+// exportQuery is the --query, or a SELECT of the --table filtered by --where
+func exportQuery(opts *types.ExportOptions) (string, *ce.CustomError) {
+	if opts.Query != "" {
+		return strings.TrimRight(strings.TrimSpace(opts.Query), ";"), nil
+	}
+	schema, table, err := splitTable(opts.Table)
+	if err != nil {
+		return "", err
+	}
+	q := "SELECT * FROM " + shared.QuoteQualifiedIdent(schema, table)
+	if opts.Where != "" {
+		q += " WHERE " + opts.Where
+	}
+	return q, nil
+}
+
+// splitTable reads schema.table, or table in the public schema
+func splitTable(name string) (string, string, *ce.CustomError) {
+	schema, table, found := strings.Cut(name, ".")
+	if !found {
+		schema, table = "public", name
+	}
+	if schema == "" || table == "" || strings.Contains(table, ".") {
+		return "", "", &ce.CustomError{Code: 771, Title: "Invalid table", Message: fmt.Sprintf("%q: expected schema.table or table", name)}
+	}
+	return schema, table, nil
+}
+
+// exporter sends rows to the current file, starting a new one when the chunk is full
+type exporter struct {
+	opts      *types.ExportOptions
+	cols      []column
+	chunkSize int64
+	files     []exportFile
+	file      *os.File
+	out       *countingWriter
+	w         rowWriter
+}
+
+func (x *exporter) chunked() bool {
+	return x.chunkSize > 0 || x.opts.ChunkRows > 0
+}
+
+// chunkPath numbers the output: orders.csv becomes orders-0001.csv, orders-0002.csv...
+func (x *exporter) chunkPath(n int) string {
+	if !x.chunked() {
+		return x.opts.Output
+	}
+	ext := filepath.Ext(x.opts.Output)
+	return fmt.Sprintf("%s-%04d%s", strings.TrimSuffix(x.opts.Output, ext), n, ext)
+}
+
+func (x *exporter) open() error {
+	path := x.chunkPath(len(x.files) + 1)
+	x.file = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		x.file = f
+	}
+	x.out = &countingWriter{w: x.file}
+	w, err := newRowWriter(x.out, x.cols, x.opts, x.chunkSize)
+	if err != nil {
+		return err
+	}
+	x.w = w
+	x.files = append(x.files, exportFile{path: path})
+	return nil
+}
+
+func (x *exporter) write(row []*string) error {
+	if x.w == nil {
+		if err := x.open(); err != nil {
+			return err
+		}
+	}
+	if err := x.w.write(row); err != nil {
+		return err
+	}
+	f := &x.files[len(x.files)-1]
+	f.rows++
+	if (x.opts.ChunkRows > 0 && f.rows >= x.opts.ChunkRows) || (x.chunkSize > 0 && x.out.n >= x.chunkSize) {
+		return x.closeFile()
+	}
+	return nil
+}
+
+func (x *exporter) closeFile() error {
+	err := x.w.close()
+	x.files[len(x.files)-1].bytes = x.out.n
+	if x.file != os.Stdout {
+		if cerr := x.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	x.w = nil
+	return err
+}
+
+// finish closes the last file; an empty result still produces one (header-only) file
+func (x *exporter) finish() error {
+	if x.w == nil && len(x.files) == 0 {
+		if err := x.open(); err != nil {
+			return err
+		}
+	}
+	if x.w != nil {
+		return x.closeFile()
+	}
+	return nil
+}
+
+// abort closes a file left open by a failure
+func (x *exporter) abort() {
+	if x.w != nil && x.file != os.Stdout {
+		x.file.Close()
+	}
+}
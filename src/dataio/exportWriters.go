@@ -0,0 +1,131 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 15:30
+// Original filename: src/dataio/exportWriters.go
+
+package dataio
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"pgtools/types"
+	"strings"
+)
+
+// rowWriter writes the rows of one output file
+type rowWriter interface {
+	write(row []*string) error
+	close() error
+}
+
+// countingWriter tracks the bytes written, for chunking by size
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newRowWriter(w *countingWriter, cols []column, opts *types.ExportOptions, chunkSize int64) (rowWriter, error) {
+	switch opts.Format {
+	case "jsonl":
+		return &jsonlWriter{buf: bufio.NewWriter(w.w), out: w, cols: cols, decimal: opts.Numeric == "decimal"}, nil
+	case "parquet":
+		return newParquetWriter(w, cols, opts.Numeric == "decimal", chunkSize)
+	}
+	cw := &csvWriter{buf: bufio.NewWriter(w.w), out: w, cols: cols, delim: opts.Delimiter, quote: opts.Quote, null: opts.Null}
+	if opts.Header {
+		header := make([]*string, len(cols))
+		for i := range cols {
+			header[i] = &cols[i].name
+		}
+		return cw, cw.writeFields(header, false)
+	}
+	return cw, nil
+}
+
+// csvWriter writes RFC 4180 CSV with a configurable delimiter and quote character. Fields are quoted when they
+// hold the delimiter, the quote, a line break or surrounding spaces, and when an empty string must be told apart
+// from a NULL written as an empty field.
+type csvWriter struct {
+	buf         *bufio.Writer
+	out         *countingWriter
+	cols        []column
+	delim       string
+	quote, null string
+}
+
+func (w *csvWriter) write(row []*string) error {
+	return w.writeFields(row, true)
+}
+
+func (w *csvWriter) writeFields(fields []*string, convert bool) error {
+	var sb strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			sb.WriteString(w.delim)
+		}
+		if f == nil {
+			sb.WriteString(w.null)
+			continue
+		}
+		v := *f
+		if convert {
+			v = w.cols[i].text(v)
+		}
+		if w.needsQuotes(v) {
+			v = w.quote + strings.ReplaceAll(v, w.quote, w.quote+w.quote) + w.quote
+		}
+		sb.WriteString(v)
+	}
+	sb.WriteString("\n")
+	return w.flushed(sb.String())
+}
+
+func (w *csvWriter) needsQuotes(v string) bool {
+	return strings.Contains(v, w.delim) || strings.Contains(v, w.quote) || strings.ContainsAny(v, "\r\n") ||
+		(v == "" && w.null == "") || v == w.null || strings.TrimSpace(v) != v
+}
+
+// flushed buffers s, counting it at once so that the chunk sizes are accurate
+func (w *csvWriter) flushed(s string) error {
+	_, err := w.buf.WriteString(s)
+	w.out.n += int64(len(s))
+	return err
+}
+
+func (w *csvWriter) close() error {
+	return w.buf.Flush()
+}
+
+type jsonlWriter struct {
+	buf     *bufio.Writer
+	out     *countingWriter
+	cols    []column
+	decimal bool
+}
+
+func (w *jsonlWriter) write(row []*string) error {
+	line := []byte{'{'}
+	for i, v := range row {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		name, _ := json.Marshal(w.cols[i].name)
+		line = append(append(line, name...), ':')
+		line = append(line, w.cols[i].json(v, w.decimal)...)
+	}
+	line = append(line, '}', '\n')
+	_, err := w.buf.Write(line)
+	w.out.n += int64(len(line))
+	return err
+}
+
+func (w *jsonlWriter) close() error {
+	return w.buf.Flush()
+}
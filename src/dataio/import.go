@@ -0,0 +1,523 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 11:00
+// Original filename: src/dataio/import.go
+
+package dataio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pgtools/db"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// shownRejects is how many rejected rows are explained on stderr
+const shownRejects = 10
+
+// target is a table column loaded from a source column
+type target struct {
+	source  int
+	name    string
+	oid     uint32
+	sqlType string // --create only
+	convert converter
+}
+
+// tableColumn is a column of the existing table
+type tableColumn struct {
+	name string
+	oid  uint32
+}
+
+type importer struct {
+	opts    *types.ImportOptions
+	reader  recordReader
+	header  *record
+	sample  []*record
+	names   []string // source columns: CSV header, positions, or JSON keys
+	targets []target
+
+	rejects  *bufio.Writer
+	rejected int
+}
+
+// Import streams a CSV or JSON Lines file into a table with COPY. Rows that cannot be read or converted to the
+// column types stop the import, or are written to the rejects file when there is one. With --create, the table is
+// created from the types inferred on the first rows, in the same transaction as the load.
+func Import(cfg *types.DBConfig, dbname, path string, opts *types.ImportOptions) *ce.CustomError {
+	logging.Debugf("Entering function: Import(%s, %s)", dbname, path)
+	if err := checkImportOptions(path, opts); err != nil {
+		return err
+	}
+	schema, table, err := splitTable(opts.Table)
+	if err != nil {
+		return err
+	}
+	readFailed := func(e error) *ce.CustomError {
+		return &ce.CustomError{Code: 781, Title: "Cannot read the input", Message: e.Error()}
+	}
+
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		f, e := os.Open(path)
+		if e != nil {
+			return readFailed(e)
+		}
+		defer f.Close()
+		in = f
+	}
+	x := &importer{opts: opts}
+	if e := x.open(in); e != nil {
+		return readFailed(e)
+	}
+	if e := x.readSample(); e != nil {
+		return readFailed(e)
+	}
+
+	conn, err := db.Connect(cfg, dbname)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	defer conn.Close(ctx)
+
+	var columns []tableColumn
+	if !opts.Create {
+		if columns, err = tableColumns(ctx, conn, schema, table); err != nil {
+			return err
+		}
+	}
+	if err := x.mapColumns(columns); err != nil {
+		return err
+	}
+	var names []string
+	for i := range x.targets {
+		t := &x.targets[i]
+		t.convert = newConverter(conn.TypeMap(), t.oid)
+		names = append(names, t.name)
+	}
+
+	if opts.Rejects != "" {
+		f, e := os.Create(opts.Rejects)
+		if e != nil {
+			return &ce.CustomError{Code: 780, Title: "Cannot create the rejects file", Message: e.Error()}
+		}
+		defer f.Close()
+		x.rejects = bufio.NewWriter(f)
+		defer x.rejects.Flush()
+		if x.header != nil {
+			x.rejects.WriteString(withNewline(x.header.raw))
+		}
+	}
+
+	failed := func(e error) *ce.CustomError {
+		return &ce.CustomError{Code: 784, Title: "Import failed", Message: e.Error()}
+	}
+	start := time.Now()
+	tx, e := conn.Begin(ctx)
+	if e != nil {
+		return failed(e)
+	}
+	defer tx.Rollback(ctx)
+	if opts.Create {
+		ddl := x.createTable(schema, table)
+		logging.Infof("%s", ddl)
+		if _, e := tx.Exec(ctx, ddl); e != nil {
+			return &ce.CustomError{Code: 783, Title: "Cannot create the table", Message: e.Error()}
+		}
+	}
+	n, e := tx.CopyFrom(ctx, pgx.Identifier{schema, table}, names, &copySource{x: x})
+	if e != nil {
+		return failed(e)
+	}
+	if e := tx.Commit(ctx); e != nil {
+		return failed(e)
+	}
+
+	fmt.Printf("Imported %d rows into %s in %s", n, shared.QuoteQualifiedIdent(schema, table), time.Since(start).Round(time.Millisecond))
+	if x.rejected > 0 {
+		fmt.Printf("; %d rejected rows written to %s", x.rejected, opts.Rejects)
+	}
+	fmt.Println()
+	return nil
+}
+
+// checkImportOptions validates the options, filling in the format from the file extension
+func checkImportOptions(path string, opts *types.ImportOptions) *ce.CustomError {
+	invalid := func(format string, args ...any) *ce.CustomError {
+		return &ce.CustomError{Code: 780, Title: "Invalid import option", Message: fmt.Sprintf(format, args...)}
+	}
+
+	if opts.Table == "" {
+		return invalid("--table is required")
+	}
+	if opts.Format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".ndjson":
+			opts.Format = "jsonl"
+		default:
+			opts.Format = "csv"
+		}
+	}
+	if opts.Format != "csv" && opts.Format != "jsonl" {
+		return invalid("--format %q: expected csv or jsonl", opts.Format)
+	}
+	if opts.Delimiter == `\t` {
+		opts.Delimiter = "\t"
+	}
+	if len([]rune(opts.Delimiter)) != 1 || len([]rune(opts.Quote)) != 1 || opts.Delimiter == opts.Quote {
+		return invalid("--delimiter and --quote must be two different single characters")
+	}
+	if strings.ContainsAny(opts.Delimiter+opts.Quote, "\r\n") {
+		return invalid("--delimiter and --quote cannot be line breaks")
+	}
+	for _, m := range opts.Map {
+		if src, _, found := strings.Cut(m, "="); !found || src == "" {
+			return invalid("--map %q: expected SOURCE=COLUMN, or SOURCE= to skip the source column", m)
+		}
+	}
+	if opts.Encoding != "" {
+		if _, err := htmlindex.Get(opts.Encoding); err != nil {
+			return invalid("--encoding %q: unknown encoding", opts.Encoding)
+		}
+	}
+	if opts.Sample < 1 {
+		return invalid("--sample must be positive")
+	}
+	if opts.Rejects != "" && opts.Rejects == path {
+		return invalid("the rejects file cannot be the input file")
+	}
+	return nil
+}
+
+// open sets up the reader, converting the input to UTF-8 and dropping a byte order mark
+func (x *importer) open(in io.Reader) error {
+	if x.opts.Encoding != "" {
+		enc, _ := htmlindex.Get(x.opts.Encoding)
+		in = enc.NewDecoder().Reader(in)
+	}
+	br := bufio.NewReaderSize(in, 1<<20)
+	if r, _, err := br.ReadRune(); err == nil && r != '\uFEFF' {
+		br.UnreadRune()
+	}
+
+	if x.opts.Format == "jsonl" {
+		x.reader = &jsonlReader{br: br}
+		return nil
+	}
+	delim, quote := []rune(x.opts.Delimiter)[0], []rune(x.opts.Quote)[0]
+	x.reader = &csvReader{br: br, delim: delim, quote: quote, null: x.opts.Null}
+	if x.opts.Header {
+		// the header's names are never NULL
+		hr := &csvReader{br: br, delim: delim, quote: quote, null: "\x00"}
+		h, err := hr.read()
+		if err == io.EOF {
+			return fmt.Errorf("the file is empty")
+		}
+		if err != nil {
+			return err
+		}
+		if h.err != nil {
+			return fmt.Errorf("header: %v", h.err)
+		}
+		x.header = h
+		x.reader.(*csvReader).line = hr.line
+	}
+	return nil
+}
+
+// readSample reads the first rows, which give the JSON keys and the --create column types
+func (x *importer) readSample() error {
+	for len(x.sample) < x.opts.Sample {
+		rec, err := x.reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		x.sample = append(x.sample, rec)
+	}
+
+	switch {
+	case x.header != nil:
+		for i, v := range x.header.values {
+			name := strings.TrimSpace(*v)
+			if name == "" {
+				name = "column" + strconv.Itoa(i+1)
+			}
+			x.names = append(x.names, name)
+		}
+	case x.opts.Format == "jsonl":
+		seen := map[string]bool{}
+		for _, rec := range x.sample {
+			for _, k := range rec.keys {
+				if !seen[k] {
+					seen[k] = true
+					x.names = append(x.names, k)
+				}
+			}
+		}
+	default:
+		// without a header, the source columns are the field positions of the first row
+		if len(x.sample) > 0 {
+			for i := range x.sample[0].values {
+				x.names = append(x.names, strconv.Itoa(i+1))
+			}
+		}
+	}
+	if len(x.names) == 0 {
+		return fmt.Errorf("no columns found in the input")
+	}
+	return nil
+}
+
+// tableColumns lists the insertable columns of the table; domains are loaded as their base type
+func tableColumns(ctx context.Context, conn *pgx.Conn, schema, table string) ([]tableColumn, *ce.CustomError) {
+	rows, err := conn.Query(ctx, `SELECT a.attname, CASE WHEN t.typtype = 'd' THEN t.typbasetype ELSE a.atttypid END
+		FROM pg_attribute a JOIN pg_type t ON t.oid = a.atttypid
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		ORDER BY a.attnum`, shared.QuoteQualifiedIdent(schema, table))
+	if err != nil {
+		return nil, &ce.CustomError{Code: 783, Title: "Cannot read the table", Message: err.Error()}
+	}
+	columns, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (tableColumn, error) {
+		var c tableColumn
+		return c, row.Scan(&c.name, &c.oid)
+	})
+	if err != nil {
+		return nil, &ce.CustomError{Code: 783, Title: "Cannot read the table", Message: err.Error()}
+	}
+	return columns, nil
+}
+
+// mapColumns pairs the source columns with table columns. --map SOURCE=COLUMN renames (SOURCE is a name or a
+// 1-based position), SOURCE= skips; other source columns go to the column of the same name, or of the same position
+// when the CSV has no header. With --create the targets are the new table's columns.
+func (x *importer) mapColumns(columns []tableColumn) *ce.CustomError {
+	mapErr := func(format string, args ...any) *ce.CustomError {
+		return &ce.CustomError{Code: 782, Title: "Column mapping error", Message: fmt.Sprintf(format, args...)}
+	}
+
+	dests := make([]*string, len(x.names))
+	for _, m := range x.opts.Map {
+		src, dest, _ := strings.Cut(m, "=")
+		i := x.sourceIndex(src)
+		if i < 0 {
+			return mapErr("--map %q: the input has no column %q (columns: %s)", m, src, strings.Join(x.names, ", "))
+		}
+		dests[i] = &dest
+	}
+
+	var infer []*inference
+	if x.opts.Create {
+		for range x.names {
+			infer = append(infer, newInference())
+		}
+		for _, rec := range x.sample {
+			if values, err := x.align(rec); err == nil {
+				for i, v := range values {
+					if v != nil {
+						infer[i].add(*v)
+					}
+				}
+			}
+		}
+	}
+
+	positional := x.header == nil && x.opts.Format == "csv"
+	used := map[string]int{}
+	for i, name := range x.names {
+		dest := name
+		switch {
+		case dests[i] != nil:
+			dest = *dests[i]
+		case positional && x.opts.Create:
+			dest = "column" + name
+		case positional:
+			if i >= len(columns) {
+				return mapErr("the input has %d fields but the table only %d columns; map or skip the extra fields with --map", len(x.names), len(columns))
+			}
+			dest = columns[i].name
+		}
+		if dest == "" {
+			continue
+		}
+
+		t := target{source: i, name: dest}
+		if x.opts.Create {
+			t.sqlType, t.oid = infer[i].sqlType()
+		} else {
+			c := findColumn(columns, dest)
+			if c == nil {
+				return mapErr("the table has no column %q for the input column %q; use --map %s=COLUMN, or --map %s= to skip it", dest, name, name, name)
+			}
+			t.name, t.oid = c.name, c.oid
+		}
+		if j, dup := used[t.name]; dup {
+			return mapErr("the input columns %q and %q both go to column %q", x.names[j], name, t.name)
+		}
+		used[t.name] = i
+		x.targets = append(x.targets, t)
+	}
+	if len(x.targets) == 0 {
+		return mapErr("no input column is loaded")
+	}
+	return nil
+}
+
+// sourceIndex finds a source column by name, else by position
+func (x *importer) sourceIndex(src string) int {
+	for i, name := range x.names {
+		if name == src {
+			return i
+		}
+	}
+	if n, err := strconv.Atoi(src); err == nil && n >= 1 && n <= len(x.names) {
+		return n - 1
+	}
+	return -1
+}
+
+// findColumn matches the exact name first, then a single case-insensitive match (Email for email)
+func findColumn(columns []tableColumn, name string) *tableColumn {
+	var folded *tableColumn
+	for i, c := range columns {
+		if c.name == name {
+			return &columns[i]
+		}
+		if strings.EqualFold(c.name, name) {
+			if folded != nil {
+				return nil
+			}
+			folded = &columns[i]
+		}
+	}
+	return folded
+}
+
+func (x *importer) createTable(schema, table string) string {
+	var cols []string
+	for _, t := range x.targets {
+		cols = append(cols, fmt.Sprintf("%s %s", shared.QuoteIdentIfNeeded(t.name), t.sqlType))
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", shared.QuoteQualifiedIdent(schema, table), strings.Join(cols, ", "))
+}
+
+// align puts the record's values in the source column order
+func (x *importer) align(rec *record) ([]*string, error) {
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	if rec.keys == nil {
+		if len(rec.values) != len(x.names) {
+			return nil, fmt.Errorf("expected %d fields, found %d", len(x.names), len(rec.values))
+		}
+		return rec.values, nil
+	}
+	values := make([]*string, len(x.names))
+	for k, key := range rec.keys {
+		i := x.sourceIndex(key)
+		if i < 0 || x.names[i] != key {
+			return nil, fmt.Errorf("key %q was not in the first %d rows", key, len(x.sample))
+		}
+		values[i] = rec.values[k]
+	}
+	return values, nil
+}
+
+// convert gives the COPY values of a record
+func (x *importer) convert(rec *record) ([]any, error) {
+	values, err := x.align(rec)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]any, len(x.targets))
+	for i, t := range x.targets {
+		if values[t.source] == nil {
+			continue
+		}
+		if row[i], err = t.convert(*values[t.source]); err != nil {
+			return nil, fmt.Errorf("column %s: %v", t.name, err)
+		}
+	}
+	return row, nil
+}
+
+// reject sets the record aside; without a rejects file, the import stops
+func (x *importer) reject(rec *record, reason error) error {
+	if x.rejects == nil {
+		return fmt.Errorf("line %d: %v (use --rejects FILE to set such rows aside)", rec.line, reason)
+	}
+	x.rejected++
+	if x.rejected <= shownRejects {
+		fmt.Fprintf(os.Stderr, "line %d rejected: %v\n", rec.line, reason)
+	} else if x.rejected == shownRejects+1 {
+		fmt.Fprintf(os.Stderr, "more rejected rows...\n")
+	}
+	_, err := x.rejects.WriteString(withNewline(rec.raw))
+	return err
+}
+
+func withNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
+
+// copySource feeds COPY with the sampled rows, then the rest of the input
+type copySource struct {
+	x      *importer
+	next   int
+	values []any
+	err    error
+}
+
+func (s *copySource) Next() bool {
+	for {
+		var rec *record
+		if s.next < len(s.x.sample) {
+			rec = s.x.sample[s.next]
+			s.next++
+		} else {
+			var err error
+			if rec, err = s.x.reader.read(); err != nil {
+				if err != io.EOF {
+					s.err = err
+				}
+				return false
+			}
+		}
+		values, err := s.x.convert(rec)
+		if err == nil {
+			s.values = values
+			return true
+		}
+		if s.err = s.x.reject(rec, err); s.err != nil {
+			return false
+		}
+	}
+}
+
+func (s *copySource) Values() ([]any, error) {
+	return s.values, nil
+}
+
+func (s *copySource) Err() error {
+	return s.err
+}
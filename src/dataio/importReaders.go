@@ -0,0 +1,179 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 09:15
+// Original filename: src/dataio/importReaders.go
+
+package dataio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// record is one input row, kept in its original form for the rejects file
+type record struct {
+	line   int
+	raw    string
+	keys   []string // JSON Lines only
+	values []*string
+	err    error // the row cannot be parsed
+}
+
+// recordReader reads the rows of the input file; io.EOF ends the input
+type recordReader interface {
+	read() (*record, error)
+}
+
+// csvReader reads CSV with a configurable delimiter and quote character, keeping track of quoted fields so that
+// an unquoted null marker is a NULL while the same text quoted is a string, as COPY does
+type csvReader struct {
+	br           *bufio.Reader
+	delim, quote rune
+	null         string
+	line         int
+}
+
+func (r *csvReader) read() (*record, error) {
+	for {
+		rec, err := r.readRecord()
+		if err != nil || strings.TrimSpace(rec.raw) != "" {
+			return rec, err
+		}
+		// blank lines are skipped
+	}
+}
+
+func (r *csvReader) readRecord() (*record, error) {
+	rec := &record{line: r.line + 1}
+	var raw, field strings.Builder
+	inQuotes, quoted, closed := false, false, false
+	endField := func() {
+		v := field.String()
+		if !quoted && v == r.null {
+			rec.values = append(rec.values, nil)
+		} else {
+			rec.values = append(rec.values, &v)
+		}
+		field.Reset()
+		quoted, closed = false, false
+	}
+
+	for {
+		s, err := r.br.ReadString('\n')
+		if s == "" && err != nil {
+			if err == io.EOF && raw.Len() > 0 {
+				// a quoted field runs to the end of the file
+				rec.raw = raw.String()
+				rec.err = fmt.Errorf("unterminated quoted field")
+				return rec, nil
+			}
+			return nil, err
+		}
+		r.line++
+		raw.WriteString(s)
+		for _, c := range s {
+			switch {
+			case inQuotes:
+				if c == r.quote {
+					inQuotes, closed = false, true
+				} else {
+					field.WriteRune(c)
+				}
+			case c == r.quote && closed:
+				// a doubled quote inside a quoted field
+				field.WriteRune(c)
+				inQuotes, closed = true, false
+			case c == r.quote && !quoted && field.Len() == 0:
+				inQuotes, quoted = true, true
+			case c == r.delim:
+				endField()
+			case c == '\n' || c == '\r':
+			default:
+				field.WriteRune(c)
+				closed = false
+			}
+		}
+		if !inQuotes {
+			endField()
+			rec.raw = raw.String()
+			return rec, nil
+		}
+		if err == io.EOF {
+			rec.raw = raw.String()
+			rec.err = fmt.Errorf("unterminated quoted field")
+			return rec, nil
+		}
+	}
+}
+
+// jsonlReader reads one JSON object per line. Strings are taken as their text, numbers and booleans as written,
+// nested objects and arrays as JSON text
+type jsonlReader struct {
+	br   *bufio.Reader
+	line int
+}
+
+func (r *jsonlReader) read() (*record, error) {
+	for {
+		s, err := r.br.ReadString('\n')
+		if s == "" && err != nil {
+			return nil, err
+		}
+		r.line++
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		rec := &record{line: r.line, raw: s}
+		rec.err = parseObject(rec, s)
+		return rec, nil
+	}
+}
+
+// parseObject reads the object's members in their order
+func parseObject(rec *record, s string) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		rec.keys = append(rec.keys, tok.(string))
+		rec.values = append(rec.values, jsonText(raw))
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON object")
+	}
+	return nil
+}
+
+func jsonText(raw json.RawMessage) *string {
+	var s string
+	switch raw[0] {
+	case 'n':
+		return nil
+	case '"':
+		json.Unmarshal(raw, &s)
+	default:
+		var buf bytes.Buffer
+		if json.Compact(&buf, raw) != nil {
+			buf.Reset()
+			buf.Write(raw)
+		}
+		s = buf.String()
+	}
+	return &s
+}
@@ -0,0 +1,172 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 10:05
+// Original filename: src/dataio/importValues.go
+
+package dataio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// leading zeros are kept as text: 00501 is a zip code, not a number
+	integerRx = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)$`)
+	numericRx = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)?(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+var (
+	offsetLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999Z07",
+		"2006-01-02T15:04:05.999999999Z07", "2006-01-02 15:04:05.999999999Z0700"}
+	localLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04",
+		"2006-01-02 15:04"}
+)
+
+// parseTimestamp reads ISO 8601 / RFC 3339 dates and timestamps, with or without an offset
+func parseTimestamp(v string) (t time.Time, offset, dateOnly, ok bool) {
+	for _, layout := range offsetLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true, false, true
+		}
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, false, false, true
+		}
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, false, true, true
+	}
+	return time.Time{}, false, false, false
+}
+
+func parseBool(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, true
+	case "f", "false", "n", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// inference narrows down a column's type as sample values are seen
+type inference struct {
+	seen                                  bool
+	boolean, int32, int64, numeric, jsonb bool
+	date, timestamp, timestamptz          bool
+}
+
+func newInference() *inference {
+	return &inference{boolean: true, int32: true, int64: true, numeric: true, jsonb: true, date: true, timestamp: true,
+		timestamptz: true}
+}
+
+func (in *inference) add(v string) {
+	in.seen = true
+	// 0 and 1 are integers; only words make booleans
+	_, isBool := parseBool(v)
+	in.boolean = in.boolean && isBool && !integerRx.MatchString(v)
+	isInt := integerRx.MatchString(v)
+	_, err32 := strconv.ParseInt(v, 10, 32)
+	_, err64 := strconv.ParseInt(v, 10, 64)
+	in.int32 = in.int32 && isInt && err32 == nil
+	in.int64 = in.int64 && isInt && err64 == nil
+	in.numeric = in.numeric && v != "" && v != "." && numericRx.MatchString(v)
+	_, offset, dateOnly, ok := parseTimestamp(v)
+	in.date = in.date && ok && dateOnly
+	in.timestamp = in.timestamp && ok && !offset
+	in.timestamptz = in.timestamptz && ok && offset
+	in.jsonb = in.jsonb && (strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[")) && json.Valid([]byte(v))
+}
+
+// sqlType is the inferred type; columns without any sample value are text
+func (in *inference) sqlType() (string, uint32) {
+	switch {
+	case !in.seen:
+	case in.boolean:
+		return "boolean", pgtype.BoolOID
+	case in.int32:
+		return "integer", pgtype.Int4OID
+	case in.int64:
+		return "bigint", pgtype.Int8OID
+	case in.numeric:
+		return "numeric", pgtype.NumericOID
+	case in.date:
+		return "date", pgtype.DateOID
+	case in.timestamp:
+		return "timestamp", pgtype.TimestampOID
+	case in.timestamptz:
+		return "timestamptz", pgtype.TimestamptzOID
+	case in.jsonb:
+		return "jsonb", pgtype.JSONBOID
+	}
+	return "text", pgtype.TextOID
+}
+
+// converter turns the input text into a value pgx encodes for the target column; a failure rejects the row
+type converter func(v string) (any, error)
+
+func newConverter(m *pgtype.Map, oid uint32) converter {
+	switch oid {
+	case pgtype.TextOID, pgtype.VarcharOID, pgtype.BPCharOID, pgtype.NameOID, pgtype.XMLOID:
+		return func(v string) (any, error) { return v, nil }
+	case pgtype.BoolOID:
+		return func(v string) (any, error) {
+			if b, ok := parseBool(v); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("%q is not a boolean", v)
+		}
+	case pgtype.TimestamptzOID, pgtype.TimestampOID:
+		// timestamps without an offset are taken as UTC; a timestamp without time zone gets the UTC time
+		return func(v string) (any, error) {
+			if t, _, _, ok := parseTimestamp(v); ok {
+				t = t.UTC()
+				return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC), nil
+			}
+			return scanText(m, oid, v)
+		}
+	case pgtype.ByteaOID:
+		// hex as PostgreSQL prints it, else base64 as pgtools export writes it
+		return func(v string) (any, error) {
+			if b, err := hexBytes(v); err == nil {
+				return b, nil
+			}
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is neither hex (\\x...) nor base64", v)
+			}
+			return b, nil
+		}
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		return func(v string) (any, error) {
+			if !json.Valid([]byte(v)) {
+				return nil, fmt.Errorf("invalid JSON: %.40q", v)
+			}
+			return v, nil
+		}
+	}
+	if _, known := m.TypeForOID(oid); !known {
+		// enums and extension types: their binary form is their text
+		return func(v string) (any, error) { return v, nil }
+	}
+	return func(v string) (any, error) { return scanText(m, oid, v) }
+}
+
+// scanText parses v as the server would, into the type's default Go value
+func scanText(m *pgtype.Map, oid uint32, v string) (any, error) {
+	var value any
+	if err := m.Scan(oid, pgtype.TextFormatCode, []byte(v), &value); err != nil {
+		return nil, fmt.Errorf("%.40q: %w", v, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,166 @@
+//go:build parquet
+
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 15:30
+// Original filename: src/dataio/parquet.go
+//
+// Parquet output, only built with -tags parquet: the parquet-go library it needs is no longer maintained, and pulls
+// in a large dependency tree that the other formats do not need.
+
+package dataio
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	pqtypes "github.com/xitongsys/parquet-go/types"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetSupported tells the export checks that Parquet output is built in
+const parquetSupported = true
+
+type parquetWriter struct {
+	pw      *writer.CSVWriter
+	cols    []column
+	decimal bool
+}
+
+// newParquetWriter writes Snappy-compressed Parquet; row groups are kept below the chunk size so that the bytes
+// reach the file, and the size check, before the chunk is too large
+func newParquetWriter(w *countingWriter, cols []column, decimal bool, chunkSize int64) (rowWriter, error) {
+	var md []string
+	for i, c := range cols {
+		md = append(md, c.parquetSchema(fmt.Sprintf("Col%d", i), decimal))
+	}
+	pw, err := writer.NewCSVWriter(md, writerfile.NewWriterFile(w), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	if chunkSize > 0 {
+		pw.RowGroupSize = min(pw.RowGroupSize, max(chunkSize/4, 1024*1024))
+	}
+	return &parquetWriter{pw: pw, cols: cols, decimal: decimal}, nil
+}
+
+func (w *parquetWriter) write(row []*string) error {
+	rec := make([]any, len(row))
+	for i, v := range row {
+		if v == nil {
+			continue
+		}
+		value, err := w.cols[i].parquet(*v, w.decimal)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", w.cols[i].name, err)
+		}
+		rec[i] = value
+	}
+	return w.pw.Write(rec)
+}
+
+func (w *parquetWriter) close() error {
+	return w.pw.WriteStop()
+}
+
+// isDecimal reports whether the column is written as a Parquet DECIMAL; unconstrained numerics have no fixed scale
+func (c column) isDecimal(numericDecimal bool) bool {
+	return numericDecimal && c.oid == pgtype.NumericOID && c.precision > 0
+}
+
+// parquetSchema is the column's parquet-go metadata tag. inName is the Go-side name the library requires.
+func (c column) parquetSchema(inName string, numericDecimal bool) string {
+	var kind string
+	switch c.oid {
+	case pgtype.BoolOID:
+		kind = "type=BOOLEAN"
+	case pgtype.Int2OID:
+		kind = "type=INT32, convertedtype=INT_16"
+	case pgtype.Int4OID:
+		kind = "type=INT32, convertedtype=INT_32"
+	case pgtype.Int8OID:
+		kind = "type=INT64, convertedtype=INT_64"
+	case pgtype.Float4OID:
+		kind = "type=FLOAT"
+	case pgtype.Float8OID:
+		kind = "type=DOUBLE"
+	case pgtype.DateOID:
+		kind = "type=INT32, convertedtype=DATE"
+	case pgtype.TimestamptzOID:
+		kind = "type=INT64, convertedtype=TIMESTAMP_MICROS, isadjustedtoutc=true"
+	case pgtype.TimestampOID:
+		kind = "type=INT64, convertedtype=TIMESTAMP_MICROS, isadjustedtoutc=false"
+	case pgtype.ByteaOID:
+		kind = "type=BYTE_ARRAY"
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		kind = "type=BYTE_ARRAY, convertedtype=JSON"
+	default:
+		kind = "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+	if c.isDecimal(numericDecimal) {
+		kind = fmt.Sprintf("type=BYTE_ARRAY, convertedtype=DECIMAL, precision=%d, scale=%d", c.precision, c.scale)
+	}
+	// the tag is comma-separated
+	name := strings.ReplaceAll(c.name, ",", "_")
+	return fmt.Sprintf("name=%s, inname=%s, %s, repetitiontype=OPTIONAL", name, inName, kind)
+}
+
+// parquet converts a value to what parquet-go expects for the column's physical type
+func (c column) parquet(v string, numericDecimal bool) (any, error) {
+	if c.isDecimal(numericDecimal) {
+		unscaled, err := unscaledDecimal(v, c.scale)
+		if err != nil {
+			return nil, err
+		}
+		return pqtypes.StrIntToBinary(unscaled, "BigEndian", 0, true), nil
+	}
+
+	switch c.oid {
+	case pgtype.BoolOID:
+		return v == "t", nil
+	case pgtype.Int2OID, pgtype.Int4OID:
+		n, err := strconv.ParseInt(v, 10, 32)
+		return int32(n), err
+	case pgtype.Int8OID:
+		return strconv.ParseInt(v, 10, 64)
+	case pgtype.Float4OID:
+		f, err := strconv.ParseFloat(v, 32)
+		return float32(f), err
+	case pgtype.Float8OID:
+		return strconv.ParseFloat(v, 64)
+	case pgtype.DateOID:
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, fmt.Errorf("date %q cannot be stored in Parquet", v)
+		}
+		return int32(t.Unix() / 86400), nil
+	case pgtype.TimestamptzOID, pgtype.TimestampOID:
+		t, ok := c.timestamp(v)
+		if !ok {
+			return nil, fmt.Errorf("timestamp %q cannot be stored in Parquet", v)
+		}
+		return t.UnixMicro(), nil
+	case pgtype.ByteaOID:
+		b, err := hexBytes(v)
+		return string(b), err
+	}
+	return v, nil
+}
+
+var decimalRx = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// unscaledDecimal turns 123.4 with scale 2 into 12340
+func unscaledDecimal(v string, scale int) (string, error) {
+	whole, frac, _ := strings.Cut(v, ".")
+	if !decimalRx.MatchString(v) || len(frac) > scale {
+		return "", fmt.Errorf("numeric %q cannot be stored as a decimal with scale %d", v, scale)
+	}
+	return whole + frac + strings.Repeat("0", scale-len(frac)), nil
+}
@@ -0,0 +1,17 @@
+//go:build !parquet
+
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 15:30
+// Original filename: src/dataio/parquet_disabled.go
+
+package dataio
+
+import "errors"
+
+// parquetSupported is false in the default build: Parquet output needs -tags parquet (see parquet.go)
+const parquetSupported = false
+
+func newParquetWriter(*countingWriter, []column, bool, int64) (rowWriter, error) {
+	return nil, errors.New("this pgtools was built without Parquet support")
+}
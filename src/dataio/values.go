@@ -0,0 +1,101 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 14:20
+// Original filename: src/dataio/values.go
+
+package dataio
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// column is one output column; values arrive in the server's text format
+type column struct {
+	name      string
+	oid       uint32
+	precision int // numeric(p, s); 0 when unconstrained
+	scale     int
+}
+
+func newColumn(fd pgconn.FieldDescription) column {
+	c := column{name: fd.Name, oid: fd.DataTypeOID}
+	if fd.DataTypeOID == pgtype.NumericOID && fd.TypeModifier >= 4 {
+		mod := fd.TypeModifier - 4
+		c.precision, c.scale = int(mod>>16), int(mod&0xffff)
+	}
+	return c
+}
+
+// text is the value as written to CSV: timestamps in RFC 3339, bytea in base64, anything else as the server prints it
+func (c column) text(v string) string {
+	switch c.oid {
+	case pgtype.TimestamptzOID, pgtype.TimestampOID:
+		if t, ok := c.timestamp(v); ok {
+			return c.rfc3339(t)
+		}
+	case pgtype.ByteaOID:
+		if b, err := hexBytes(v); err == nil {
+			return base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	return v
+}
+
+// json is the value as written to JSON Lines; numerics are strings unless decimals were asked for
+func (c column) json(v *string, numericDecimal bool) []byte {
+	if v == nil {
+		return []byte("null")
+	}
+	switch c.oid {
+	case pgtype.BoolOID:
+		return []byte(strconv.FormatBool(*v == "t"))
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID, pgtype.OIDOID, pgtype.Float4OID, pgtype.Float8OID:
+		if json.Valid([]byte(*v)) {
+			return []byte(*v)
+		}
+	case pgtype.NumericOID:
+		if numericDecimal && json.Valid([]byte(*v)) {
+			return []byte(*v)
+		}
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		if json.Valid([]byte(*v)) {
+			return []byte(*v)
+		}
+	}
+	s, _ := json.Marshal(c.text(*v))
+	return s
+}
+
+// timestamp parses timestamps as printed under the export settings; infinity and BC dates are not parsed
+func (c column) timestamp(v string) (time.Time, bool) {
+	layout := "2006-01-02 15:04:05.999999"
+	if c.oid == pgtype.TimestamptzOID {
+		layout += "Z07"
+	}
+	t, err := time.Parse(layout, v)
+	return t, err == nil
+}
+
+// rfc3339 formats a timestamp; as a timestamp without time zone has no offset, it gets none
+func (c column) rfc3339(t time.Time) string {
+	if c.oid == pgtype.TimestamptzOID {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return t.Format("2006-01-02T15:04:05.999999999")
+}
+
+func hexBytes(v string) ([]byte, error) {
+	if !strings.HasPrefix(v, `\x`) {
+		return nil, fmt.Errorf("not a hex bytea value")
+	}
+	return hex.DecodeString(v[2:])
+}
@@ -0,0 +1,217 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/20 17:20
+// Original filename: src/db/alterDB.go
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pgtools/shared"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"pgtools/logging"
+	"pgtools/types"
+)
+
+// settingName matches configuration parameter names, custom ones (module.name) included
+var settingName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// alterStatement is one ALTER DATABASE; exclusive statements need the database to have no other session
+type alterStatement struct {
+	sql       string
+	exclusive bool
+}
+
+// AlterDatabase applies the requested changes to dbname, one ALTER DATABASE at a time; the rename comes last.
+func AlterDatabase(cfg *types.DBConfig, dbname string, opts *types.AlterDBOptions) *ce.CustomError {
+	logging.Debugf("Entering function: AlterDatabase(%s)", dbname)
+	ctx := context.Background()
+
+	conn, err := Connect(cfg, "postgres")
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	if err := validateAlterOptions(ctx, conn, dbname, opts); err != nil {
+		return err
+	}
+	stmts, err := alterDatabaseSQL(dbname, opts)
+	if err != nil {
+		return err
+	}
+	if len(stmts) == 0 {
+		return &ce.CustomError{Code: 720, Title: "Nothing to alter", Message: "no change was requested"}
+	}
+
+	for _, st := range stmts {
+		if err := execAlter(ctx, conn, dbname, st, opts.Force); err != nil {
+			return err
+		}
+		fmt.Println(st.sql)
+	}
+	logging.Infof("Altered database %s", dbname)
+	return nil
+}
+
+// execAlter runs one statement. Exclusive statements disconnect the other sessions first when forced; as sessions
+// may reconnect in between, an "in use" failure is retried once.
+func execAlter(ctx context.Context, conn *pgx.Conn, dbname string, st alterStatement, force bool) *ce.CustomError {
+	attempts := 1
+	if st.exclusive && force {
+		attempts = 2
+	}
+	for i := 0; i < attempts; i++ {
+		if st.exclusive && force {
+			if err := terminateSessions(conn, dbname); err != nil {
+				return err
+			}
+		}
+		_, e := conn.Exec(ctx, st.sql)
+		if e == nil {
+			return nil
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(e, &pgErr) && pgErr.Code == "55006" {
+			if !force {
+				return &ce.CustomError{Code: 722, Title: "Database in use", Message: fmt.Sprintf("%s: %s; use --force to disconnect the sessions", st.sql, e.Error())}
+			}
+			if i+1 < attempts {
+				continue
+			}
+		}
+		return &ce.CustomError{Code: 723, Title: "ALTER DATABASE failed", Message: fmt.Sprintf("%s: %s", st.sql, e.Error())}
+	}
+	return nil
+}
+
+func alterDatabaseSQL(dbname string, opts *types.AlterDBOptions) ([]alterStatement, *ce.CustomError) {
+	prefix := "ALTER DATABASE " + shared.QuoteIdent(dbname)
+	var stmts []alterStatement
+	add := func(exclusive bool, format string, args ...any) {
+		stmts = append(stmts, alterStatement{sql: prefix + " " + fmt.Sprintf(format, args...), exclusive: exclusive})
+	}
+
+	if opts.Owner != "" {
+		add(false, "OWNER TO %s", shared.QuoteIdent(opts.Owner))
+	}
+	if opts.ConnectionLimit != nil {
+		add(false, "WITH CONNECTION LIMIT %d", *opts.ConnectionLimit)
+	}
+	if opts.AllowConnections != nil {
+		add(false, "WITH ALLOW_CONNECTIONS %t", *opts.AllowConnections)
+	}
+	if opts.IsTemplate != nil {
+		add(false, "WITH IS_TEMPLATE %t", *opts.IsTemplate)
+	}
+	for _, s := range opts.Set {
+		name, value, err := parseSetting(s)
+		if err != nil {
+			return nil, err
+		}
+		add(false, "SET %s = %s", name, settingValue(value))
+	}
+	for _, r := range opts.Reset {
+		if strings.EqualFold(r, "ALL") {
+			add(false, "RESET ALL")
+		} else {
+			add(false, "RESET %s", strings.TrimSpace(r))
+		}
+	}
+	if opts.Tablespace != "" {
+		add(true, "SET TABLESPACE %s", shared.QuoteIdent(opts.Tablespace))
+	}
+	if opts.RenameTo != "" {
+		add(true, "RENAME TO %s", shared.QuoteIdent(opts.RenameTo))
+	}
+	return stmts, nil
+}
+
+// parseSetting splits name=value
+func parseSetting(s string) (string, string, *ce.CustomError) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !settingName.MatchString(name) {
+		return "", "", &ce.CustomError{Code: 721, Title: "Invalid database option", Message: fmt.Sprintf("--set %q: expected name=value", s)}
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// settingValue quotes a parameter value. A comma-separated value is taken as a list (search_path, DateStyle...),
+// each element being quoted on its own.
+func settingValue(value string) string {
+	var parts []string
+	for _, p := range strings.Split(value, ",") {
+		parts = append(parts, shared.QuoteLiteral(strings.TrimSpace(p)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// catalogCheck is a SELECT EXISTS query that must return true, and the problem to report when it does not
+type catalogCheck struct {
+	query, arg, problem string
+}
+
+func validateAlterOptions(ctx context.Context, conn *pgx.Conn, dbname string, opts *types.AlterDBOptions) *ce.CustomError {
+	invalid := func(format string, args ...any) *ce.CustomError {
+		return &ce.CustomError{Code: 721, Title: "Invalid database option", Message: fmt.Sprintf(format, args...)}
+	}
+	failed := func(e error) *ce.CustomError {
+		return &ce.CustomError{Code: 702, Title: "Unable to check the database options", Message: e.Error()}
+	}
+
+	checks := []catalogCheck{
+		{"SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", dbname, "database %q does not exist"},
+	}
+	if opts.Owner != "" {
+		checks = append(checks, catalogCheck{
+			"SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = $1)", opts.Owner, "role %q does not exist"})
+	}
+	if opts.Tablespace != "" {
+		checks = append(checks, catalogCheck{
+			"SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_tablespace WHERE spcname = $1)", opts.Tablespace, "tablespace %q does not exist"})
+	}
+	if opts.RenameTo != "" {
+		checks = append(checks, catalogCheck{
+			"SELECT NOT EXISTS (SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", opts.RenameTo, "database %q already exists"})
+	}
+	// custom parameters (with a dot) are not in pg_settings until their module is loaded
+	for _, s := range opts.Set {
+		name, _, err := parseSetting(s)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(name, ".") {
+			checks = append(checks, catalogCheck{
+				"SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_settings WHERE name = lower($1))", name, "unknown configuration parameter %q"})
+		}
+	}
+
+	for _, r := range opts.Reset {
+		if !strings.EqualFold(r, "ALL") && !settingName.MatchString(strings.TrimSpace(r)) {
+			return invalid("--reset %q: not a configuration parameter name", r)
+		}
+	}
+
+	for _, c := range checks {
+		ok, e := catalogHas(ctx, conn, c.query, c.arg)
+		if e != nil {
+			return failed(e)
+		}
+		if !ok {
+			return invalid(c.problem, c.arg)
+		}
+	}
+
+	if opts.ConnectionLimit != nil && *opts.ConnectionLimit < -1 {
+		return invalid("--connection-limit must be -1 (no limit) or more")
+	}
+	return nil
+}
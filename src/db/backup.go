@@ -7,6 +7,8 @@
 //   - Remove dependency on pgtools/show to avoid package cycles.
 //   - Keep behavior: determine DB show (respecting -a), create archive (.sql[.gz]),
 //     and dump each database by calling writeDatabaseSQL().
+//   - Record a manifest next to the archive (see manifest.go), used by db validate; it is read in the
+//     same transaction as the dump, so that both see the same snapshot.
 //
 
 package db
@@ -39,21 +41,7 @@ func BackupDatabase(cfg *types.DBConfig, inOutArgs []string) *ce.CustomError {
 	}
 
 	// Archive filename is the last argument
-	archive := inOutArgs[len(inOutArgs)-1]
-
-	// Handle filename extensions: ensure .sql, optional .gz
-	gzExt := false
-	if strings.HasSuffix(archive, ".gz") {
-		gzExt = true
-		archive = strings.TrimSuffix(archive, ".gz")
-	}
-	if strings.HasSuffix(archive, ".sql") {
-		archive = strings.TrimSuffix(archive, ".sql")
-	}
-	archive += ".sql"
-	if gzExt {
-		archive += ".gz"
-	}
+	archive, gzExt := archiveFileName(inOutArgs[len(inOutArgs)-1])
 
 	// Build database show
 	var dbnames []string
@@ -85,15 +73,40 @@ func BackupDatabase(cfg *types.DBConfig, inOutArgs []string) *ce.CustomError {
 		defer func() { _ = gzWriter.Close() }()
 	}
 
-	// Dump each database
+	manifest, cerr := newBackupManifest(cfg)
+	if cerr != nil {
+		return cerr
+	}
+
+	// Dump each database, recording its manifest from the same snapshot
 	for _, dbname := range dbnames {
-		if err := writeDatabaseSQL(cfg, dbname, writer); err != nil {
+		dm, err := writeDatabaseSQL(cfg, dbname, writer)
+		if err != nil {
 			logging.Errorf("Error code %d -> %s : %s", err.Code, err.Title, err.Message)
 			return err
 		}
+		manifest.Databases = append(manifest.Databases, *dm)
 	}
 
-	return nil
+	return writeManifest(archive, manifest)
+}
+
+// archiveFileName normalizes an archive filename: it always carries a .sql extension,
+// followed by .gz when the output is to be gzip-compressed.
+func archiveFileName(archive string) (string, bool) {
+	gzExt := false
+	if strings.HasSuffix(archive, ".gz") {
+		gzExt = true
+		archive = strings.TrimSuffix(archive, ".gz")
+	}
+	if strings.HasSuffix(archive, ".sql") {
+		archive = strings.TrimSuffix(archive, ".sql")
+	}
+	archive += ".sql"
+	if gzExt {
+		archive += ".gz"
+	}
+	return archive, gzExt
 }
 
 // getDatabaseNames returns all non-template database names, ordered by name.
@@ -0,0 +1,297 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/19 13:05
+// Original filename: src/db/clone.go
+//
+// db clone: copy a database from one environment to another over two live connections.
+// The schema is read from the source catalogs, replayed on the target, and table data flows
+// from COPY TO STDOUT into COPY FROM STDIN; no dump file is ever written.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+)
+
+// currentFilter builds the object filter from the --schema/--exclude-schema/--table/--exclude-table flags
+func currentFilter() *objectFilter {
+	return &objectFilter{
+		Schemas: types.FilterSchemas, ExcludeSchemas: types.FilterExcludeSchemas,
+		Tables: types.FilterTables, ExcludeTables: types.FilterExcludeTables,
+	}
+}
+
+// CloneDatabase copies srcDB from srcCfg to dstCfg, as newName (srcDB when empty).
+func CloneDatabase(srcCfg, dstCfg *types.DBConfig, srcDB, newName string) *ce.CustomError {
+	if newName == "" {
+		newName = srcDB
+	}
+	if srcCfg.Host == dstCfg.Host && srcCfg.Port == dstCfg.Port && srcDB == newName {
+		return &ce.CustomError{Code: 260, Title: "Invalid clone target", Message: "source and target are the same database; use --as to pick another name"}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+
+	// The source is read inside one repeatable-read transaction; parallel workers share its snapshot
+	src, cerr := Connect(srcCfg, srcDB)
+	if cerr != nil {
+		return cerr
+	}
+	defer safeClose(src)
+	if _, err := src.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		return &ce.CustomError{Code: 261, Title: "Unable to open source transaction", Message: err.Error()}
+	}
+	var snapshotID string
+	if err := src.QueryRow(ctx, "SELECT pg_catalog.pg_export_snapshot()").Scan(&snapshotID); err != nil {
+		return &ce.CustomError{Code: 261, Title: "Unable to export source snapshot", Message: err.Error()}
+	}
+
+	snap, cerr := loadSchemaSnapshot(ctx, src, currentFilter())
+	if cerr != nil {
+		return cerr
+	}
+	logging.Infof("Source %s: %d schema(s), %d table(s), %d view(s), %d function(s)",
+		srcDB, len(snap.Schemas), len(snap.Tables), len(snap.Views), len(snap.Functions))
+
+	if cerr := prepareCloneTarget(dstCfg, newName); cerr != nil {
+		return cerr
+	}
+
+	rows, cerr := cloneInto(ctx, src, snap, snapshotID, srcCfg, dstCfg, srcDB, newName)
+	if cerr != nil {
+		// Do not leave a half-built database behind
+		logging.Errorf("Clone failed, dropping %s on the target", newName)
+		if derr := DropDatabase(dstCfg, newName, true); derr != nil {
+			logging.Errorf("Unable to drop %s: %s", newName, derr.Error())
+		}
+		return cerr
+	}
+	_, _ = src.Exec(context.Background(), "COMMIT")
+
+	fmt.Printf("%s %s (%s) -> %s (%s): %d table(s), %d row(s) in %s\n", hf.Green("Cloned"), srcDB, srcCfg.Host,
+		newName, dstCfg.Host, len(snap.Tables), rows, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// prepareCloneTarget creates the target database, dropping an existing one only with --replace
+func prepareCloneTarget(dstCfg *types.DBConfig, newName string) *ce.CustomError {
+	conn, cerr := Connect(dstCfg, "postgres")
+	if cerr != nil {
+		return cerr
+	}
+	var exists bool
+	err := conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", newName).Scan(&exists)
+	conn.Close(context.Background())
+	if err != nil {
+		return &ce.CustomError{Code: 262, Title: "Unable to check the target database", Message: err.Error()}
+	}
+
+	if exists {
+		if !types.CloneReplace {
+			return &ce.CustomError{Code: 262, Title: "Target database exists", Message: fmt.Sprintf("%s already exists on %s; use --replace to overwrite it", newName, dstCfg.Host)}
+		}
+		if cerr := DropDatabase(dstCfg, newName, true); cerr != nil {
+			return cerr
+		}
+	}
+	return CreateDatabase(dstCfg, newName, "")
+}
+
+// cloneInto builds the schema on the target, copies the data, then finishes the schema
+func cloneInto(ctx context.Context, src *pgx.Conn, snap *schemaSnapshot, snapshotID string,
+	srcCfg, dstCfg *types.DBConfig, srcDB, newName string) (int64, *ce.CustomError) {
+
+	dst, cerr := Connect(dstCfg, newName)
+	if cerr != nil {
+		return 0, cerr
+	}
+	defer safeClose(dst)
+	for _, s := range []string{"SET check_function_bodies = false", "SET client_min_messages = warning"} {
+		if _, err := dst.Exec(ctx, s); err != nil {
+			return 0, &ce.CustomError{Code: 263, Title: "Unable to prepare the target session", Message: err.Error()}
+		}
+	}
+
+	if cerr := applyDDL(ctx, dst, snap.preDataDDL()); cerr != nil {
+		return 0, cerr
+	}
+
+	var rows int64
+	if !types.CloneSchemaOnly {
+		var tables []*tableDef
+		for i := range snap.Tables {
+			// partitioned tables hold no rows of their own; their partitions are copied instead
+			if !snap.Tables[i].Partitioned {
+				tables = append(tables, &snap.Tables[i])
+			}
+		}
+		var cerr *ce.CustomError
+		if rows, cerr = copyTables(ctx, tables, src, dst, snapshotID, srcCfg, dstCfg, srcDB, newName); cerr != nil {
+			return 0, cerr
+		}
+	}
+
+	if cerr := applyDDL(ctx, dst, snap.postDataDDL()); cerr != nil {
+		return 0, cerr
+	}
+	return rows, nil
+}
+
+// applyDDL executes statements in order. Statements marked Retry that fail are tried again once
+// everything else went through, until no more progress is made.
+func applyDDL(ctx context.Context, conn *pgx.Conn, stmts []ddlStatement) *ce.CustomError {
+	var pending []ddlStatement
+	for _, st := range stmts {
+		logging.Debugf("DDL: %s", st.SQL)
+		if _, err := conn.Exec(ctx, st.SQL); err != nil {
+			if st.Retry && ctx.Err() == nil {
+				pending = append(pending, st)
+				continue
+			}
+			return &ce.CustomError{Code: 264, Title: fmt.Sprintf("DDL failed\n%s", st.SQL), Message: err.Error()}
+		}
+	}
+
+	for len(pending) > 0 {
+		var still []ddlStatement
+		var lastErr error
+		for _, st := range pending {
+			if _, err := conn.Exec(ctx, st.SQL); err != nil {
+				still = append(still, st)
+				lastErr = err
+			}
+		}
+		if len(still) == len(pending) {
+			return &ce.CustomError{Code: 264, Title: fmt.Sprintf("DDL failed\n%s", still[0].SQL), Message: lastErr.Error()}
+		}
+		pending = still
+	}
+	return nil
+}
+
+// copyTables streams every table from source to target, with up to types.Jobs tables in flight.
+// Extra workers open their own connections and attach to the exported source snapshot.
+func copyTables(parent context.Context, tables []*tableDef, src, dst *pgx.Conn, snapshotID string,
+	srcCfg, dstCfg *types.DBConfig, srcDB, newName string) (int64, *ce.CustomError) {
+
+	jobs := types.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(tables) {
+		jobs = len(tables)
+	}
+
+	var total atomic.Int64
+	work := make(chan *tableDef)
+	errs := make(chan *ce.CustomError, jobs)
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			wsrc, wdst := src.PgConn(), dst.PgConn()
+			if w > 0 {
+				ws, wd, cerr := openCopyWorker(ctx, srcCfg, dstCfg, srcDB, newName, snapshotID)
+				if cerr != nil {
+					errs <- cerr
+					cancel()
+					return
+				}
+				defer safeClose(ws)
+				defer safeClose(wd)
+				wsrc, wdst = ws.PgConn(), wd.PgConn()
+			}
+			for t := range work {
+				n, cerr := copyTable(ctx, wsrc, wdst, t)
+				if cerr != nil {
+					errs <- cerr
+					cancel()
+					return
+				}
+				total.Add(n)
+			}
+		}(w)
+	}
+
+feed:
+	for _, t := range tables {
+		select {
+		case work <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	if cerr := <-errs; cerr != nil {
+		return 0, cerr
+	}
+	if err := parent.Err(); err != nil {
+		return 0, &ce.CustomError{Code: 265, Title: "Clone interrupted", Message: err.Error()}
+	}
+	return total.Load(), nil
+}
+
+// openCopyWorker opens a source connection attached to snapshotID and a target connection
+func openCopyWorker(ctx context.Context, srcCfg, dstCfg *types.DBConfig, srcDB, newName, snapshotID string) (*pgx.Conn, *pgx.Conn, *ce.CustomError) {
+	ws, cerr := Connect(srcCfg, srcDB)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	if _, err := ws.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		safeClose(ws)
+		return nil, nil, &ce.CustomError{Code: 261, Title: "Unable to open source transaction", Message: err.Error()}
+	}
+	if _, err := ws.Exec(ctx, "SET TRANSACTION SNAPSHOT "+shared.QuoteLiteral(snapshotID)); err != nil {
+		safeClose(ws)
+		return nil, nil, &ce.CustomError{Code: 261, Title: "Unable to attach to the source snapshot", Message: err.Error()}
+	}
+	wd, cerr := Connect(dstCfg, newName)
+	if cerr != nil {
+		safeClose(ws)
+		return nil, nil, cerr
+	}
+	return ws, wd, nil
+}
+
+func copyTable(ctx context.Context, src, dst *pgconn.PgConn, t *tableDef) (int64, *ce.CustomError) {
+	if len(t.copyColumns()) == 0 {
+		return 0, nil
+	}
+	cols := strings.Join(shared.QuoteIdents(t.copyColumns()), ", ")
+	name := qualified(t.Schema, t.Name)
+	start := time.Now()
+
+	n, err := copyStream(ctx, src, dst,
+		fmt.Sprintf("COPY (SELECT %s FROM ONLY %s) TO STDOUT", cols, name),
+		fmt.Sprintf("COPY %s (%s) FROM STDIN", name, cols))
+	if err != nil {
+		return 0, &ce.CustomError{Code: 266, Title: "Table copy failed", Message: fmt.Sprintf("%s: %s", name, err.Error())}
+	}
+	logging.Infof("Copied %s: %d row(s) in %s", name, n, time.Since(start).Round(time.Millisecond))
+	return n, nil
+}
@@ -0,0 +1,56 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/17 18:02
+// Original filename: src/db/convert.go
+
+package db
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+
+	"pgtools/logging"
+	"pgtools/types"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// ConvertArchive rewrites a restore source (typically a pg_dump custom-format archive) as a pgtools
+// archive: a plain .sql script, gzip-compressed when the output name ends with .gz.
+// The resulting file can be replayed with `pgtools db restore`.
+func ConvertArchive(input, output string) *ce.CustomError {
+	logging.Debugf("Entering function: db.ConvertArchive(%s, %s)", input, output)
+
+	script, cerr := openArchive(input, types.RestoreTarget)
+	if cerr != nil {
+		return cerr
+	}
+	defer script.Close()
+
+	archive, gzExt := archiveFileName(output)
+	file, err := os.Create(archive)
+	if err != nil {
+		return &ce.CustomError{Code: 92, Title: "Cannot create archive", Message: err.Error()}
+	}
+	defer func() { _ = file.Close() }()
+
+	var writer io.Writer = file
+	var gzWriter *gzip.Writer
+	if gzExt {
+		gzWriter = gzip.NewWriter(file)
+		writer = gzWriter
+	}
+
+	if _, err := io.Copy(writer, script); err != nil {
+		return &ce.CustomError{Code: 222, Title: "Archive conversion failed", Message: err.Error()}
+	}
+	if gzWriter != nil {
+		if err := gzWriter.Close(); err != nil {
+			return &ce.CustomError{Code: 222, Title: "Archive conversion failed", Message: err.Error()}
+		}
+	}
+
+	logging.Infof("Converted %s to %s", input, archive)
+	return nil
+}
@@ -7,33 +7,314 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"pgtools/shared"
+	"slices"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
 	ce "github.com/jeanfrancoisgratton/customError/v2"
 	"pgtools/logging"
 	"pgtools/types"
 )
 
+var databasePrivileges = []string{"ALL", "CONNECT", "CREATE", "TEMPORARY", "TEMP"}
+
 // CreateDatabase creates a new empty database. If owner is non-empty, sets OWNER.
 func CreateDatabase(cfg *types.DBConfig, dbname string, owner string) *ce.CustomError {
-	logging.Debugf("Entering function: CreateDatabase(%s, owner=%q)", dbname, owner)
+	_, err := CreateDatabaseWithOptions(cfg, dbname, &types.CreateDBOptions{Owner: owner})
+	return err
+}
+
+// CreateDatabaseWithOptions creates a database with the given CREATE DATABASE options, checked beforehand against
+// what the server supports, then applies the requested grants. It returns false when IfNotExists is set and the
+// database is already there.
+func CreateDatabaseWithOptions(cfg *types.DBConfig, dbname string, opts *types.CreateDBOptions) (bool, *ce.CustomError) {
+	logging.Debugf("Entering function: CreateDatabaseWithOptions(%s, owner=%q)", dbname, opts.Owner)
+	ctx := context.Background()
 
 	conn, err := Connect(cfg, "postgres")
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer conn.Close(ctx)
+
+	if opts.IfNotExists {
+		exists, e := catalogHas(ctx, conn, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", dbname)
+		if e != nil {
+			return false, &ce.CustomError{Code: 702, Title: "Unable to check the database", Message: e.Error()}
+		}
+		if exists {
+			logging.Infof("Database %s already exists, not created", dbname)
+			return false, nil
+		}
+	}
+
+	version, err := validateCreateOptions(ctx, conn, opts)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := createDatabaseSQL(dbname, opts, version)
+	logging.Debugf("%s", stmt)
+	if _, e := conn.Exec(ctx, stmt); e != nil {
+		return false, &ce.CustomError{Code: 700, Title: "CREATE DATABASE failed", Message: e.Error()}
+	}
+	logging.Infof("Created database %s (owner=%q)", dbname, opts.Owner)
+
+	if err := applyDatabaseGrants(ctx, conn, dbname, opts); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// createDatabaseSQL renders CREATE DATABASE; before PostgreSQL 13, LOCALE is spelled out as LC_COLLATE and LC_CTYPE
+func createDatabaseSQL(dbname string, opts *types.CreateDBOptions, version int) string {
+	var with []string
+	add := func(format string, args ...any) {
+		with = append(with, fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(opts.Owner) != "" {
+		add("OWNER = %s", shared.QuoteIdent(opts.Owner))
+	}
+	if opts.Template != "" {
+		add("TEMPLATE = %s", shared.QuoteIdent(opts.Template))
+	}
+	if opts.Encoding != "" {
+		add("ENCODING = %s", shared.QuoteLiteral(opts.Encoding))
+	}
+	lcCollate, lcCtype := opts.LcCollate, opts.LcCtype
+	if opts.Locale != "" {
+		if version >= 130000 {
+			add("LOCALE = %s", shared.QuoteLiteral(opts.Locale))
+		} else {
+			if lcCollate == "" {
+				lcCollate = opts.Locale
+			}
+			if lcCtype == "" {
+				lcCtype = opts.Locale
+			}
+		}
+	}
+	if lcCollate != "" {
+		add("LC_COLLATE = %s", shared.QuoteLiteral(lcCollate))
+	}
+	if lcCtype != "" {
+		add("LC_CTYPE = %s", shared.QuoteLiteral(lcCtype))
+	}
+	if opts.IcuLocale != "" {
+		add("LOCALE_PROVIDER = icu")
+		add("ICU_LOCALE = %s", shared.QuoteLiteral(opts.IcuLocale))
+	}
+	if opts.Tablespace != "" {
+		add("TABLESPACE = %s", shared.QuoteIdent(opts.Tablespace))
+	}
+	if opts.ConnectionLimit != nil {
+		add("CONNECTION LIMIT = %d", *opts.ConnectionLimit)
+	}
+	if opts.IsTemplate {
+		add("IS_TEMPLATE = true")
+	}
+	if opts.Strategy != "" {
+		add("STRATEGY = %s", strings.ToUpper(opts.Strategy))
 	}
-	defer conn.Close(context.Background())
 
 	stmt := "CREATE DATABASE " + shared.QuoteIdent(dbname)
-	if strings.TrimSpace(owner) != "" {
-		stmt += " OWNER " + shared.QuoteIdent(owner)
+	if len(with) > 0 {
+		stmt += " WITH " + strings.Join(with, " ")
+	}
+	return stmt
+}
+
+// validateCreateOptions checks every option against the server before anything is created, so that a typo
+// gets a clear message instead of a half-applied command. It returns the server version.
+func validateCreateOptions(ctx context.Context, conn *pgx.Conn, opts *types.CreateDBOptions) (int, *ce.CustomError) {
+	invalid := func(format string, args ...any) (int, *ce.CustomError) {
+		return 0, &ce.CustomError{Code: 701, Title: "Invalid database option", Message: fmt.Sprintf(format, args...)}
+	}
+	failed := func(e error) (int, *ce.CustomError) {
+		return 0, &ce.CustomError{Code: 702, Title: "Unable to check the database options", Message: e.Error()}
+	}
+
+	var version int
+	if e := conn.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); e != nil {
+		return failed(e)
 	}
 
-	if _, e := conn.Exec(context.Background(), stmt); e != nil {
-		return &ce.CustomError{Code: 700, Title: "CREATE DATABASE failed", Message: e.Error()}
+	if opts.Owner != "" {
+		if ok, e := catalogHas(ctx, conn, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = $1)", opts.Owner); e != nil {
+			return failed(e)
+		} else if !ok {
+			return invalid("role %q does not exist", opts.Owner)
+		}
 	}
 
-	logging.Infof("Created database %s (owner=%q)", dbname, owner)
+	if opts.Template != "" {
+		var usable bool
+		e := conn.QueryRow(ctx, `
+			SELECT d.datistemplate OR pg_catalog.pg_has_role(d.datdba, 'MEMBER') OR r.rolsuper
+			FROM pg_catalog.pg_database d, pg_catalog.pg_roles r
+			WHERE d.datname = $1 AND r.rolname = current_user`, opts.Template).Scan(&usable)
+		switch {
+		case errors.Is(e, pgx.ErrNoRows):
+			return invalid("template database %q does not exist", opts.Template)
+		case e != nil:
+			return failed(e)
+		case !usable:
+			return invalid("%q is not a template and you do not own it; only its owner or a superuser can copy it", opts.Template)
+		}
+	}
+
+	if opts.Encoding != "" {
+		var enc int
+		if e := conn.QueryRow(ctx, "SELECT pg_catalog.pg_char_to_encoding($1)", opts.Encoding).Scan(&enc); e != nil {
+			return failed(e)
+		}
+		if enc < 0 {
+			return invalid("encoding %q is not supported by the server", opts.Encoding)
+		}
+	}
+
+	for _, l := range []struct{ flag, value string }{
+		{"--locale", opts.Locale}, {"--lc-collate", opts.LcCollate}, {"--lc-ctype", opts.LcCtype},
+	} {
+		if l.value == "" {
+			continue
+		}
+		if ok, e := serverHasLocale(ctx, conn, l.value); e != nil {
+			return failed(e)
+		} else if !ok {
+			return invalid("%s %q: the server does not know this locale", l.flag, l.value)
+		}
+	}
+
+	if opts.IcuLocale != "" {
+		if version < 150000 {
+			return invalid("--icu-locale needs PostgreSQL 15 or later")
+		}
+		if ok, e := catalogHas(ctx, conn, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_collation WHERE collprovider = $1)", "i"); e != nil {
+			return failed(e)
+		} else if !ok {
+			return invalid("--icu-locale: the server was built without ICU support")
+		}
+	}
+
+	if opts.Tablespace != "" {
+		if ok, e := catalogHas(ctx, conn, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_tablespace WHERE spcname = $1)", opts.Tablespace); e != nil {
+			return failed(e)
+		} else if !ok {
+			return invalid("tablespace %q does not exist", opts.Tablespace)
+		}
+	}
+
+	if opts.ConnectionLimit != nil && *opts.ConnectionLimit < -1 {
+		return invalid("--connection-limit must be -1 (no limit) or more")
+	}
+
+	if opts.Strategy != "" {
+		if version < 150000 {
+			return invalid("--strategy needs PostgreSQL 15 or later")
+		}
+		if s := strings.ToLower(opts.Strategy); s != "wal_log" && s != "file_copy" {
+			return invalid("--strategy must be wal_log or file_copy")
+		}
+	}
+
+	for _, g := range opts.Grants {
+		role, _, err := parseDatabaseGrant(g)
+		if err != nil {
+			return 0, err
+		}
+		if strings.EqualFold(role, "PUBLIC") {
+			continue
+		}
+		if ok, e := catalogHas(ctx, conn, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = $1)", role); e != nil {
+			return failed(e)
+		} else if !ok {
+			return invalid("--grant %s: role %q does not exist", g, role)
+		}
+	}
+	return version, nil
+}
+
+// catalogHas runs a SELECT EXISTS query with a single argument
+func catalogHas(ctx context.Context, conn *pgx.Conn, query string, arg string) (bool, error) {
+	var ok bool
+	err := conn.QueryRow(ctx, query, arg).Scan(&ok)
+	return ok, err
+}
+
+// serverHasLocale looks the locale up among the libc collations imported at initdb time. When none were imported
+// (initdb --no-import-collations) there is nothing to check against, and the server gets the final word.
+func serverHasLocale(ctx context.Context, conn *pgx.Conn, locale string) (bool, error) {
+	if locale == "C" || locale == "POSIX" || strings.HasPrefix(locale, "C.") {
+		return true, nil
+	}
+	var imported int
+	var found bool
+	// en_US.UTF-8 and en_US.utf8 name the same locale
+	err := conn.QueryRow(ctx, `
+		SELECT count(*) FILTER (WHERE collname NOT IN ('C', 'POSIX', 'ucs_basic')),
+			coalesce(bool_or(lower(replace(collcollate, '-', '')) = lower(replace($1, '-', ''))
+				OR lower(replace(collname, '-', '')) = lower(replace($1, '-', ''))), false)
+		FROM pg_catalog.pg_collation WHERE collprovider = 'c'`, locale).Scan(&imported, &found)
+	if err != nil {
+		return false, err
+	}
+	if imported == 0 {
+		logging.Debugf("No libc collations imported on the server, not checking locale %s", locale)
+		return true, nil
+	}
+	return found, nil
+}
+
+// parseDatabaseGrant parses ROLE[:PRIV,PRIV...]; the privileges default to CONNECT and TEMPORARY
+func parseDatabaseGrant(g string) (string, []string, *ce.CustomError) {
+	role, list, hasPrivs := strings.Cut(g, ":")
+	role = strings.TrimSpace(role)
+	if role == "" {
+		return "", nil, &ce.CustomError{Code: 701, Title: "Invalid database option", Message: fmt.Sprintf("--grant %q: expected ROLE[:PRIV,...]", g)}
+	}
+	if !hasPrivs {
+		return role, []string{"CONNECT", "TEMPORARY"}, nil
+	}
+	var privs []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if !slices.Contains(databasePrivileges, p) {
+			return "", nil, &ce.CustomError{Code: 701, Title: "Invalid database option",
+				Message: fmt.Sprintf("--grant %q: %q is not a database privilege (%s)", g, p, strings.Join(databasePrivileges, ", "))}
+		}
+		privs = append(privs, p)
+	}
+	return role, privs, nil
+}
+
+// applyDatabaseGrants sets the access privileges of a freshly created database
+func applyDatabaseGrants(ctx context.Context, conn *pgx.Conn, dbname string, opts *types.CreateDBOptions) *ce.CustomError {
+	var stmts []string
+	if opts.RevokePublic {
+		stmts = append(stmts, "REVOKE ALL ON DATABASE "+shared.QuoteIdent(dbname)+" FROM PUBLIC")
+	}
+	for _, g := range opts.Grants {
+		role, privs, err := parseDatabaseGrant(g)
+		if err != nil {
+			return err
+		}
+		grantee := shared.QuoteIdent(role)
+		if strings.EqualFold(role, "PUBLIC") {
+			grantee = "PUBLIC"
+		}
+		stmts = append(stmts, fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", strings.Join(privs, ", "), shared.QuoteIdent(dbname), grantee))
+	}
+
+	for _, stmt := range stmts {
+		logging.Debugf("%s", stmt)
+		if _, e := conn.Exec(ctx, stmt); e != nil {
+			return &ce.CustomError{Code: 703, Title: "Database created, but the grants failed", Message: fmt.Sprintf("%s: %s", stmt, e.Error())}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,568 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/17 14:40
+// Original filename: src/db/customArchive.go
+//
+// Pure-Go reader for pg_dump's custom archive format (pg_dump -Fc).
+// The layout mirrors pg_backup_archiver.c / pg_backup_custom.c :
+//   header ("PGDMP", version, int/offset sizes, compression, creation date, db name, versions)
+//   TOC    (one entry per dumped object, with its DDL and the offset of its data block)
+//   data   (blocks of length-prefixed chunks, zlib-compressed when the archive is compressed)
+
+package db
+
+import (
+	"bufio"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+const pgDumpMagic = "PGDMP"
+
+// archive versions, as in pg_backup_archiver.h
+const (
+	archVers1_7  = 1<<16 | 7<<8
+	archVers1_8  = 1<<16 | 8<<8
+	archVers1_9  = 1<<16 | 9<<8
+	archVers1_10 = 1<<16 | 10<<8
+	archVers1_11 = 1<<16 | 11<<8
+	archVers1_14 = 1<<16 | 14<<8
+	archVers1_15 = 1<<16 | 15<<8
+	archVers1_16 = 1<<16 | 16<<8
+	archVersMax  = archVers1_16 | 0xff
+)
+
+const (
+	archFormatCustom = 1
+
+	compressionNone = 0
+	compressionGzip = 1
+
+	offsetPosNotSet = 1
+	offsetPosSet    = 2
+	offsetNoData    = 3
+
+	blockData  = 1
+	blockBlobs = 3
+)
+
+var archiveSections = map[int]string{1: "NONE", 2: "PRE-DATA", 3: "DATA", 4: "POST-DATA"}
+
+// TocEntry is one table-of-contents entry of a custom-format archive.
+type TocEntry struct {
+	DumpID     int
+	HadDumper  bool
+	TableOID   string
+	OID        string
+	Tag        string
+	Desc       string
+	Section    int
+	Defn       string
+	DropStmt   string
+	CopyStmt   string
+	Namespace  string
+	Tablespace string
+	TableAM    string
+	Owner      string
+	Deps       []int
+
+	dataState byte
+	dataPos   int64
+}
+
+// HasData reports whether the entry owns a data block in the archive.
+func (te *TocEntry) HasData() bool {
+	return te.HadDumper && te.dataState != offsetNoData
+}
+
+// SectionName returns the pg_dump section name of the entry.
+func (te *TocEntry) SectionName() string {
+	if s, ok := archiveSections[te.Section]; ok {
+		return s
+	}
+	return strconv.Itoa(te.Section)
+}
+
+// customArchive is a pg_dump custom-format archive opened for reading.
+type customArchive struct {
+	r      *bufio.Reader
+	seeker io.ReadSeeker
+
+	version     int
+	intSize     int
+	offSize     int
+	compression int
+
+	Created       time.Time
+	DBName        string
+	ServerVersion string
+	DumpVersion   string
+	Entries       []*TocEntry
+}
+
+// openCustomArchive reads the header and TOC of a custom-format archive.
+// When rs is non-nil, data blocks are located by seeking; otherwise they are read sequentially from r,
+// which only works when entries are requested in archive order (same limitation as pg_restore on a pipe).
+func openCustomArchive(r io.Reader, rs io.ReadSeeker) (*customArchive, *ce.CustomError) {
+	a := &customArchive{seeker: rs}
+	if rs != nil {
+		a.r = bufio.NewReaderSize(rs, 256*1024)
+	} else {
+		a.r = bufio.NewReaderSize(r, 256*1024)
+	}
+
+	if err := a.readHeader(); err != nil {
+		return nil, &ce.CustomError{Code: 220, Title: "Invalid custom-format archive header", Message: err.Error()}
+	}
+	if err := a.readToc(); err != nil {
+		return nil, &ce.CustomError{Code: 221, Title: "Invalid custom-format archive TOC", Message: err.Error()}
+	}
+	return a, nil
+}
+
+// VersionString returns the archive format version as "major.minor-rev".
+func (a *customArchive) VersionString() string {
+	return fmt.Sprintf("%d.%d-%d", a.version>>16, (a.version>>8)&0xff, a.version&0xff)
+}
+
+func (a *customArchive) readByte() (byte, error) {
+	b, err := a.r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// readInt reads pg_dump's portable integer: one sign byte followed by intSize little-endian bytes.
+func (a *customArchive) readInt() (int, error) {
+	sign, err := a.readByte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := 0; i < a.intSize; i++ {
+		b, err := a.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if i < 8 {
+			v |= uint64(b) << (8 * i)
+		}
+	}
+	if sign != 0 {
+		return -int(v), nil
+	}
+	return int(v), nil
+}
+
+// readStr reads a length-prefixed string; a negative length is a NULL string (ok == false).
+func (a *customArchive) readStr() (string, bool, error) {
+	l, err := a.readInt()
+	if err != nil {
+		return "", false, err
+	}
+	if l < 0 {
+		return "", false, nil
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(a.r, buf); err != nil {
+		return "", false, err
+	}
+	return string(buf), true, nil
+}
+
+func (a *customArchive) readString() (string, error) {
+	s, _, err := a.readStr()
+	return s, err
+}
+
+func (a *customArchive) readOffset() (byte, int64, error) {
+	flag, err := a.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	if flag != offsetPosNotSet && flag != offsetPosSet && flag != offsetNoData {
+		return 0, 0, fmt.Errorf("unexpected data offset flag %d", flag)
+	}
+	var off int64
+	for i := 0; i < a.offSize; i++ {
+		b, err := a.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		if i < 8 {
+			off |= int64(b) << (8 * i)
+		}
+	}
+	return flag, off, nil
+}
+
+func (a *customArchive) readHeader() error {
+	magic := make([]byte, len(pgDumpMagic))
+	if _, err := io.ReadFull(a.r, magic); err != nil {
+		return err
+	}
+	if string(magic) != pgDumpMagic {
+		return fmt.Errorf("did not find magic string %q in file header", pgDumpMagic)
+	}
+
+	vmaj, err := a.readByte()
+	if err != nil {
+		return err
+	}
+	vmin, err := a.readByte()
+	if err != nil {
+		return err
+	}
+	var vrev byte
+	if vmaj > 1 || (vmaj == 1 && vmin > 0) {
+		if vrev, err = a.readByte(); err != nil {
+			return err
+		}
+	}
+	a.version = int(vmaj)<<16 | int(vmin)<<8 | int(vrev)
+	if a.version < archVers1_7 || a.version > archVersMax {
+		return fmt.Errorf("unsupported archive version %d.%d", vmaj, vmin)
+	}
+
+	isz, err := a.readByte()
+	if err != nil {
+		return err
+	}
+	osz, err := a.readByte()
+	if err != nil {
+		return err
+	}
+	a.intSize, a.offSize = int(isz), int(osz)
+	if a.intSize == 0 || a.intSize > 32 || a.offSize == 0 || a.offSize > 32 {
+		return fmt.Errorf("unsupported integer size %d / offset size %d", a.intSize, a.offSize)
+	}
+
+	format, err := a.readByte()
+	if err != nil {
+		return err
+	}
+	if format != archFormatCustom {
+		return fmt.Errorf("archive format %d is not the custom format; only pg_dump -Fc archives are supported", format)
+	}
+
+	if a.version >= archVers1_15 {
+		algo, err := a.readByte()
+		if err != nil {
+			return err
+		}
+		a.compression = int(algo)
+	} else {
+		level, err := a.readInt()
+		if err != nil {
+			return err
+		}
+		if level != 0 {
+			a.compression = compressionGzip
+		}
+	}
+	if a.compression != compressionNone && a.compression != compressionGzip {
+		return fmt.Errorf("unsupported compression method %d (only none and gzip are supported)", a.compression)
+	}
+
+	var tm [7]int
+	for i := range tm {
+		if tm[i], err = a.readInt(); err != nil {
+			return err
+		}
+	}
+	// struct tm: sec, min, hour, mday, mon (0-based), year (since 1900), isdst
+	a.Created = time.Date(tm[5]+1900, time.Month(tm[4]+1), tm[3], tm[2], tm[1], tm[0], 0, time.Local)
+
+	if a.DBName, err = a.readString(); err != nil {
+		return err
+	}
+	if a.version >= archVers1_10 {
+		if a.ServerVersion, err = a.readString(); err != nil {
+			return err
+		}
+		if a.DumpVersion, err = a.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *customArchive) readToc() error {
+	n, err := a.readInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("invalid TOC entry count %d", n)
+	}
+
+	for i := 0; i < n; i++ {
+		te := &TocEntry{}
+		if te.DumpID, err = a.readInt(); err != nil {
+			return err
+		}
+		hadDumper, err := a.readInt()
+		if err != nil {
+			return err
+		}
+		te.HadDumper = hadDumper != 0
+
+		if a.version >= archVers1_8 {
+			if te.TableOID, err = a.readString(); err != nil {
+				return err
+			}
+			if te.OID, err = a.readString(); err != nil {
+				return err
+			}
+		} else {
+			oid, err := a.readInt()
+			if err != nil {
+				return err
+			}
+			te.OID = strconv.Itoa(oid)
+		}
+
+		if te.Tag, err = a.readString(); err != nil {
+			return err
+		}
+		if te.Desc, err = a.readString(); err != nil {
+			return err
+		}
+		if a.version >= archVers1_11 {
+			if te.Section, err = a.readInt(); err != nil {
+				return err
+			}
+		}
+		if te.Defn, err = a.readString(); err != nil {
+			return err
+		}
+		if te.DropStmt, err = a.readString(); err != nil {
+			return err
+		}
+		if te.CopyStmt, err = a.readString(); err != nil {
+			return err
+		}
+		if te.Namespace, err = a.readString(); err != nil {
+			return err
+		}
+		if a.version >= archVers1_10 {
+			if te.Tablespace, err = a.readString(); err != nil {
+				return err
+			}
+		}
+		if a.version >= archVers1_14 {
+			if te.TableAM, err = a.readString(); err != nil {
+				return err
+			}
+		}
+		if a.version >= archVers1_16 {
+			// relkind; not needed to replay the archive
+			if _, err = a.readInt(); err != nil {
+				return err
+			}
+		}
+		if te.Owner, err = a.readString(); err != nil {
+			return err
+		}
+		if a.version >= archVers1_9 {
+			// "withOids", meaningless since PostgreSQL 12
+			if _, err = a.readString(); err != nil {
+				return err
+			}
+		}
+
+		for {
+			dep, ok, err := a.readStr()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if id, cerr := strconv.Atoi(dep); cerr == nil {
+				te.Deps = append(te.Deps, id)
+			}
+		}
+
+		if te.dataState, te.dataPos, err = a.readOffset(); err != nil {
+			return err
+		}
+		a.Entries = append(a.Entries, te)
+	}
+	return nil
+}
+
+// readBlockHeader returns the type and dump ID of the next data block; io.EOF at the end of the archive.
+func (a *customArchive) readBlockHeader() (byte, int, error) {
+	t, err := a.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := a.readInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	return t, id, nil
+}
+
+// seekData positions the archive on the data block of te and returns the block type.
+func (a *customArchive) seekData(te *TocEntry) (byte, error) {
+	if a.seeker != nil && te.dataState == offsetPosSet {
+		if _, err := a.seeker.Seek(te.dataPos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		a.r.Reset(a.seeker)
+		t, id, err := a.readBlockHeader()
+		if err != nil {
+			return 0, err
+		}
+		if id != te.DumpID {
+			return 0, fmt.Errorf("found data block %d instead of %d at offset %d", id, te.DumpID, te.dataPos)
+		}
+		return t, nil
+	}
+
+	// Sequential scan: skip whatever blocks precede the one we want
+	for {
+		t, id, err := a.readBlockHeader()
+		if err == io.EOF {
+			return 0, fmt.Errorf("data block for entry %d (%s %s) not found; the archive may need to be read from a seekable file",
+				te.DumpID, te.Desc, te.Tag)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if id == te.DumpID {
+			return t, nil
+		}
+		switch t {
+		case blockData:
+			err = a.skipChunks()
+		case blockBlobs:
+			err = a.skipBlobs()
+		default:
+			err = fmt.Errorf("unrecognized data block type %d", t)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (a *customArchive) skipChunks() error {
+	_, err := io.Copy(io.Discard, &chunkReader{a: a})
+	return err
+}
+
+func (a *customArchive) skipBlobs() error {
+	for {
+		oid, err := a.readInt()
+		if err != nil {
+			return err
+		}
+		if oid == 0 {
+			return nil
+		}
+		if err := a.skipChunks(); err != nil {
+			return err
+		}
+	}
+}
+
+// dataStream returns a reader over the (decompressed) contents of the chunk sequence at the current position.
+// The caller must read it to EOF.
+func (a *customArchive) dataStream() (io.Reader, error) {
+	cr := &chunkReader{a: a}
+	if a.compression == compressionNone {
+		return cr, nil
+	}
+	zr, err := zlib.NewReader(cr)
+	if err == io.EOF {
+		// no chunks at all: empty data block
+		return cr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &zlibDrain{zr: zr, cr: cr}, nil
+}
+
+// chunkReader concatenates the length-prefixed chunks of a data block, stopping at the zero-length terminator.
+type chunkReader struct {
+	a      *customArchive
+	remain int
+	done   bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		l, err := c.a.readInt()
+		if err != nil {
+			return 0, err
+		}
+		if l <= 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remain = l
+	}
+	if len(p) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.a.r.Read(p)
+	c.remain -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// zlibDrain makes sure the chunk terminator is consumed once the zlib stream ends.
+type zlibDrain struct {
+	zr io.ReadCloser
+	cr *chunkReader
+}
+
+func (z *zlibDrain) Read(p []byte) (int, error) {
+	n, err := z.zr.Read(p)
+	if err == io.EOF {
+		_ = z.zr.Close()
+		if _, derr := io.Copy(io.Discard, z.cr); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+// forEachBlob walks a BLOBS data block, handing each large object's OID and contents to fn.
+func (a *customArchive) forEachBlob(fn func(oid int, data io.Reader) error) error {
+	for {
+		oid, err := a.readInt()
+		if err != nil {
+			return err
+		}
+		if oid == 0 {
+			return nil
+		}
+		data, err := a.dataStream()
+		if err != nil {
+			return err
+		}
+		if err := fn(oid, data); err != nil {
+			return err
+		}
+		// make sure the whole object was consumed even if fn stopped early
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+	}
+}
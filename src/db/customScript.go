@@ -0,0 +1,311 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/17 16:05
+// Original filename: src/db/customScript.go
+//
+// Turns a custom-format archive into the SQL script pg_restore -f would produce,
+// so that it can be replayed by scriptLoader or saved as a pgtools archive.
+
+package db
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"pgtools/shared"
+	"strings"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const invWrite = 0x20000 // INV_WRITE, for lo_open()
+
+// sessionPrologue is what pg_restore emits at the top of a script, and after every \connect
+var sessionPrologue = []string{
+	"SET statement_timeout = 0;",
+	"SET lock_timeout = 0;",
+	"SET idle_in_transaction_session_timeout = 0;",
+	"SET check_function_bodies = false;",
+	"SET xmloption = content;",
+	"SET client_min_messages = warning;",
+	"SET row_security = off;",
+}
+
+// ownedObjectTypes are the TOC entry types pg_restore emits an ALTER ... OWNER TO for
+var ownedObjectTypes = map[string]bool{
+	"AGGREGATE": true, "BLOB": true, "COLLATION": true, "CONVERSION": true, "DATABASE": true, "DOMAIN": true,
+	"FUNCTION": true, "OPERATOR": true, "OPERATOR CLASS": true, "OPERATOR FAMILY": true, "PROCEDURE": true,
+	"PROCEDURAL LANGUAGE": true, "SCHEMA": true, "EVENT TRIGGER": true, "TABLE": true, "TYPE": true, "VIEW": true,
+	"MATERIALIZED VIEW": true, "SEQUENCE": true, "FOREIGN TABLE": true, "TEXT SEARCH DICTIONARY": true,
+	"TEXT SEARCH CONFIGURATION": true, "FOREIGN DATA WRAPPER": true, "SERVER": true, "STATISTICS": true,
+	"PUBLICATION": true, "SUBSCRIPTION": true,
+}
+
+// writeScript writes the whole archive as a SQL script.
+// With an empty target the archived database is created (pg_restore -C); otherwise the script
+// connects to the existing target database and skips the database-level entries.
+func (a *customArchive) writeScript(out io.Writer, target string) error {
+	w := &stickyWriter{w: out}
+
+	var settings []string
+	for _, te := range a.Entries {
+		switch te.Desc {
+		case "ENCODING", "STDSTRINGS", "SEARCHPATH":
+			settings = append(settings, strings.TrimSpace(te.Defn))
+		}
+	}
+	prologue := func() {
+		fmt.Fprintln(w)
+		for _, s := range append(append([]string{}, sessionPrologue...), settings...) {
+			fmt.Fprintln(w, s)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "--\n-- Converted from a pg_dump custom-format archive by pgtools\n")
+	fmt.Fprintf(w, "-- Database: %s\n-- Dumped from server version %s by pg_dump %s on %s\n--\n",
+		a.DBName, a.ServerVersion, a.DumpVersion, a.Created.Format(time.RFC3339))
+
+	if target != "" {
+		fmt.Fprintf(w, "\n\\connect %s\n", metaQuote(target))
+	}
+	prologue()
+
+	var tablespace, tableAM string
+	for _, te := range a.Entries {
+		if w.err != nil {
+			// the consumer went away (failed restore); no point in decoding the rest
+			return w.err
+		}
+		switch te.Desc {
+		case "ENCODING", "STDSTRINGS", "SEARCHPATH":
+			continue
+		case "DATABASE":
+			if target == "" {
+				writeEntryHeader(w, te)
+				fmt.Fprintln(w, strings.TrimSpace(te.Defn))
+				if stmt := ownerStatement(te); stmt != "" {
+					fmt.Fprintln(w, stmt)
+				}
+				fmt.Fprintf(w, "\n\\connect %s\n", metaQuote(te.Tag))
+				prologue()
+			}
+			continue
+		case "DATABASE PROPERTIES":
+			if target != "" {
+				continue
+			}
+		}
+		if target != "" && isDatabaseLevelEntry(te) {
+			continue
+		}
+
+		if te.Tablespace != tablespace && (te.Defn != "" || te.HasData()) {
+			tablespace = te.Tablespace
+			fmt.Fprintf(w, "SET default_tablespace = %s;\n", shared.QuoteLiteral(tablespace))
+		}
+		if te.TableAM != "" && te.TableAM != tableAM {
+			tableAM = te.TableAM
+			fmt.Fprintf(w, "SET default_table_access_method = %s;\n", shared.QuoteIdentIfNeeded(tableAM))
+		}
+
+		if te.Defn != "" || te.HasData() {
+			writeEntryHeader(w, te)
+		}
+		if defn := strings.TrimSpace(te.Defn); defn != "" {
+			fmt.Fprintln(w, defn)
+		}
+		if stmt := ownerStatement(te); stmt != "" {
+			fmt.Fprintln(w, stmt)
+		}
+		if te.HasData() {
+			if err := a.writeData(w, te); err != nil {
+				return fmt.Errorf("entry %d (%s %s): %w", te.DumpID, te.Desc, te.Tag, err)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "--\n-- End of archive\n--")
+	return w.err
+}
+
+// stickyWriter remembers the first write error so that long loops can bail out
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
+
+func writeEntryHeader(w io.Writer, te *TocEntry) {
+	ns := te.Namespace
+	if ns == "" {
+		ns = "-"
+	}
+	owner := te.Owner
+	if owner == "" {
+		owner = "-"
+	}
+	prefix := ""
+	if te.HasData() {
+		prefix = "Data for "
+	}
+	fmt.Fprintf(w, "--\n-- %sName: %s; Type: %s; Schema: %s; Owner: %s\n--\n\n", prefix, te.Tag, te.Desc, ns, owner)
+}
+
+// isDatabaseLevelEntry reports comments, ACLs and labels attached to the database itself.
+func isDatabaseLevelEntry(te *TocEntry) bool {
+	switch te.Desc {
+	case "COMMENT", "ACL", "SECURITY LABEL":
+		return strings.HasPrefix(te.Tag, "DATABASE ")
+	}
+	return false
+}
+
+// ownerStatement rebuilds the ALTER ... OWNER TO statement pg_restore derives from a TOC entry.
+func ownerStatement(te *TocEntry) string {
+	if te.Owner == "" || !ownedObjectTypes[te.Desc] {
+		return ""
+	}
+
+	var object string
+	switch te.Desc {
+	case "BLOB":
+		object = "LARGE OBJECT " + te.Tag
+	case "AGGREGATE", "FUNCTION", "OPERATOR", "OPERATOR CLASS", "OPERATOR FAMILY", "PROCEDURE":
+		// These need their full signature, which is exactly what the DROP statement carries
+		if te.DropStmt == "" {
+			return ""
+		}
+		object = strings.TrimRight(strings.TrimPrefix(te.DropStmt, "DROP "), ";\n")
+		// pg_dump --if-exists writes DROP FUNCTION IF EXISTS ...
+		object = strings.Replace(object, " IF EXISTS ", " ", 1)
+	default:
+		object = te.Desc + " "
+		if te.Namespace != "" {
+			object += shared.QuoteIdentIfNeeded(te.Namespace) + "."
+		}
+		object += shared.QuoteIdentIfNeeded(te.Tag)
+	}
+	return fmt.Sprintf("ALTER %s OWNER TO %s;", object, shared.QuoteIdentIfNeeded(te.Owner))
+}
+
+// writeData copies the data block of te into the script: COPY data between the entry's COPY statement
+// and the "\." terminator, INSERT statements as-is, and large objects as lo_open()/lowrite() calls.
+func (a *customArchive) writeData(w io.Writer, te *TocEntry) error {
+	blockType, err := a.seekData(te)
+	if err != nil {
+		return err
+	}
+
+	switch blockType {
+	case blockData:
+		data, err := a.dataStream()
+		if err != nil {
+			return err
+		}
+		if te.CopyStmt != "" {
+			fmt.Fprintln(w, strings.TrimSpace(te.CopyStmt))
+		}
+		if _, err := io.Copy(w, data); err != nil {
+			return err
+		}
+		if te.CopyStmt != "" {
+			fmt.Fprintln(w, `\.`)
+		}
+		return nil
+
+	case blockBlobs:
+		// Large-object descriptors only live inside a transaction
+		fmt.Fprintln(w, "BEGIN;")
+		err := a.forEachBlob(func(oid int, data io.Reader) error {
+			fmt.Fprintf(w, "SELECT pg_catalog.lo_open('%d', %d);\n", oid, invWrite)
+			buf := make([]byte, 16*1024)
+			for {
+				n, rerr := io.ReadFull(data, buf)
+				if n > 0 {
+					fmt.Fprintf(w, "SELECT pg_catalog.lowrite(0, '\\x%s');\n", hex.EncodeToString(buf[:n]))
+				}
+				if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+					break
+				}
+				if rerr != nil {
+					return rerr
+				}
+			}
+			fmt.Fprintln(w, "SELECT pg_catalog.lo_close(0);")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "COMMIT;")
+		return nil
+	}
+	return fmt.Errorf("unrecognized data block type %d", blockType)
+}
+
+// metaQuote quotes a database name for a \connect meta-command.
+func metaQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// ListArchive prints the header and table of contents of a pg_dump custom-format archive (pg_restore -l).
+func ListArchive(arcname string) *ce.CustomError {
+	file, err := os.Open(arcname)
+	if err != nil {
+		return &ce.CustomError{Title: "could not open file", Message: err.Error(), Code: 201}
+	}
+	defer file.Close()
+
+	// only the header and the table of contents are read, so a gzipped archive can be streamed
+	var archive *customArchive
+	var cerr *ce.CustomError
+	if strings.HasSuffix(arcname, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return &ce.CustomError{Title: "gzip decompression failed", Message: err.Error(), Code: 202}
+		}
+		defer gzReader.Close()
+		archive, cerr = openCustomArchive(gzReader, nil)
+	} else {
+		archive, cerr = openCustomArchive(nil, file)
+	}
+	if cerr != nil {
+		return cerr
+	}
+
+	fmt.Printf("Archive: %s\n", arcname)
+	fmt.Printf("Database: %s\nCreated: %s\nServer version: %s\npg_dump version: %s\nFormat version: %s\nCompression: %s\nTOC entries: %d\n\n",
+		archive.DBName, archive.Created.Format("2006/01/02 15:04:05"), archive.ServerVersion, archive.DumpVersion,
+		archive.VersionString(), map[int]string{compressionNone: "none", compressionGzip: "gzip"}[archive.compression],
+		len(archive.Entries))
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"ID", "Section", "Type", "Schema", "Name", "Owner", "Data"})
+	for _, te := range archive.Entries {
+		data := ""
+		if te.HasData() {
+			data = "yes"
+		}
+		tw.AppendRow(table.Row{te.DumpID, te.SectionName(), te.Desc, te.Namespace, te.Tag, te.Owner, data})
+	}
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Render()
+	return nil
+}
@@ -0,0 +1,599 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/20 14:10
+// Original filename: src/db/dataDiff.go
+//
+// db datadiff: check that two databases hold the same rows without shipping the rows around.
+// Each table is cut into primary-key ranges and both sides hash every range with md5(string_agg(row::text));
+// ranges that disagree are halved until they are small enough to compare row by row.
+// Tables without a primary key are compared with a single hash over the whole table.
+
+package db
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// leafRows is the size under which a mismatching range is compared row by row instead of being split again
+const leafRows = 1000
+
+// dataTable is a table as seen by datadiff
+type dataTable struct {
+	Schema, Name string
+	Columns      []string
+	Types        []string
+	Generated    []bool
+	PK           []string
+	PKTypes      []string
+	PKCollatable []bool // text-like key columns, ordered and compared with COLLATE "C"
+}
+
+// keyRange is a half-open range of primary-key values, [Lo, Hi); a nil bound is open
+type keyRange struct {
+	Lo, Hi []string
+}
+
+type rowDiff struct {
+	Key           []string
+	Status        string // missing, extra or changed, as in db diff
+	First, Second string // the rows as text
+	json          string // the first database's row, for the repair SQL
+}
+
+type tableDataDiff struct {
+	Table        string
+	Rows1, Rows2 int64
+	Chunks       int
+	Status       string // identical, different, missing, extra, skipped
+	Detail       string
+	Differing    int
+	Rows         []rowDiff
+}
+
+// DiffData compares the rows of every table of db1 on cfg1 with db2 on cfg2.
+func DiffData(cfg1, cfg2 *types.DBConfig, db1, db2 string) *ce.CustomError {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+
+	c1, cerr := openDataDiffSide(ctx, cfg1, db1)
+	if cerr != nil {
+		return cerr
+	}
+	defer safeClose(c1)
+	c2, cerr := openDataDiffSide(ctx, cfg2, db2)
+	if cerr != nil {
+		return cerr
+	}
+	defer safeClose(c2)
+
+	filter := currentFilter()
+	tables1, err := loadDataTables(ctx, c1, filter)
+	if err != nil {
+		return &ce.CustomError{Code: 291, Title: "Unable to list tables", Message: fmt.Sprintf("%s: %s", db1, err.Error())}
+	}
+	tables2, err := loadDataTables(ctx, c2, filter)
+	if err != nil {
+		return &ce.CustomError{Code: 291, Title: "Unable to list tables", Message: fmt.Sprintf("%s: %s", db2, err.Error())}
+	}
+
+	var repair *bufio.Writer
+	if types.DataDiffRepair != "" {
+		f, err := os.Create(types.DataDiffRepair)
+		if err != nil {
+			return &ce.CustomError{Code: 292, Title: "Unable to create the repair file", Message: err.Error()}
+		}
+		defer f.Close()
+		repair = bufio.NewWriter(f)
+		fmt.Fprintf(repair, "-- pgtools db datadiff, %s\n", time.Now().Format(time.RFC3339))
+		fmt.Fprintf(repair, "-- Makes the rows of %s (%s) match %s (%s). Review it before running it.\n\nBEGIN;\n",
+			db2, cfg2.Host, db1, cfg1.Host)
+	}
+
+	byName := byKey(tables2, func(t *dataTable) string { return tableKey(t.Schema, t.Name) })
+	var results []*tableDataDiff
+	for i := range tables1 {
+		t := &tables1[i]
+		res := &tableDataDiff{Table: qualified(t.Schema, t.Name)}
+		results = append(results, res)
+
+		other, ok := byName[tableKey(t.Schema, t.Name)]
+		delete(byName, tableKey(t.Schema, t.Name))
+		switch {
+		case !ok:
+			res.Status, res.Detail = diffMissing, "not in the second database"
+			continue
+		case !slices.Equal(t.Columns, other.Columns) || !slices.Equal(t.Types, other.Types):
+			res.Status, res.Detail = "skipped", "the columns differ; see db diff"
+			continue
+		case !slices.Equal(t.PK, other.PK):
+			res.Status, res.Detail = "skipped", "the primary keys differ; see db diff"
+			continue
+		}
+
+		logging.Infof("Comparing %s", res.Table)
+		cmp := &dataComparer{ctx: ctx, c1: c1, c2: c2, t: t, res: res, repair: repair}
+		var err error
+		if len(t.PK) == 0 {
+			err = cmp.compareWhole()
+		} else {
+			err = cmp.compareChunks()
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return &ce.CustomError{Code: 294, Title: "Data diff interrupted", Message: ctx.Err().Error()}
+			}
+			return &ce.CustomError{Code: 293, Title: "Data diff failed", Message: fmt.Sprintf("%s: %s", res.Table, err.Error())}
+		}
+	}
+	for _, t := range tables2 {
+		if _, ok := byName[tableKey(t.Schema, t.Name)]; ok {
+			results = append(results, &tableDataDiff{Table: qualified(t.Schema, t.Name), Status: diffExtra, Detail: "not in the first database"})
+		}
+	}
+
+	if repair != nil {
+		repair.WriteString("\nCOMMIT;\n")
+		if err := repair.Flush(); err != nil {
+			return &ce.CustomError{Code: 292, Title: "Unable to write the repair file", Message: err.Error()}
+		}
+	}
+
+	different := printDataDiff(fmt.Sprintf("%s (%s)", db1, cfg1.Host), fmt.Sprintf("%s (%s)", db2, cfg2.Host), results)
+	fmt.Printf("Compared %d table(s) in %s\n", len(results), time.Since(start).Round(time.Millisecond))
+	if repair != nil && different > 0 {
+		fmt.Printf("Repair SQL written to %s\n", types.DataDiffRepair)
+	}
+	if different > 0 {
+		return &ce.CustomError{Code: 295, Title: "Data differs", Message: fmt.Sprintf("%d table(s) differ", different)}
+	}
+	return nil
+}
+
+// openDataDiffSide connects to one side and pins a snapshot, so that concurrent writes do not show up as differences.
+// Both sides get the same output settings, so that the row texts of two servers configured differently still compare.
+func openDataDiffSide(ctx context.Context, cfg *types.DBConfig, dbname string) (*pgx.Conn, *ce.CustomError) {
+	conn, cerr := Connect(cfg, dbname)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, s := range shared.TextOutputSettings {
+		if _, err := conn.Exec(ctx, s); err != nil {
+			safeClose(conn)
+			return nil, &ce.CustomError{Code: 290, Title: "Unable to set up the session", Message: err.Error()}
+		}
+	}
+	if _, err := conn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		safeClose(conn)
+		return nil, &ce.CustomError{Code: 290, Title: "Unable to open transaction", Message: err.Error()}
+	}
+	return conn, nil
+}
+
+// loadDataTables lists the ordinary tables and partitions with their columns and primary key.
+// Partitioned tables are left out: their partitions hold the rows.
+func loadDataTables(ctx context.Context, conn *pgx.Conn, filter *objectFilter) ([]dataTable, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname,
+			array(SELECT a.attname::text FROM pg_catalog.pg_attribute a
+				WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum),
+			array(SELECT pg_catalog.format_type(a.atttypid, a.atttypmod) FROM pg_catalog.pg_attribute a
+				WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum),
+			array(SELECT a.attgenerated <> '' FROM pg_catalog.pg_attribute a
+				WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum),
+			array(SELECT a.attname::text FROM pg_catalog.pg_index i
+				CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+				WHERE i.indrelid = c.oid AND i.indisprimary ORDER BY k.ord),
+			array(SELECT pg_catalog.format_type(a.atttypid, a.atttypmod) FROM pg_catalog.pg_index i
+				CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+				WHERE i.indrelid = c.oid AND i.indisprimary ORDER BY k.ord),
+			array(SELECT a.attcollation <> 0 FROM pg_catalog.pg_index i
+				CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+				WHERE i.indrelid = c.oid AND i.indisprimary ORDER BY k.ord)
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r' AND `+userSchemaFilter+`
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []dataTable
+	for rows.Next() {
+		var t dataTable
+		if err := rows.Scan(&t.Schema, &t.Name, &t.Columns, &t.Types, &t.Generated, &t.PK, &t.PKTypes, &t.PKCollatable); err != nil {
+			return nil, err
+		}
+		if filter.tableIncluded(t.Schema, t.Name) {
+			tables = append(tables, t)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func (t *dataTable) name() string {
+	return qualified(t.Schema, t.Name)
+}
+
+// rowText is the text form of a whole row, the unit that is hashed and compared
+func (t *dataTable) rowText() string {
+	return "ROW(" + strings.Join(shared.QuoteIdents(t.Columns), ", ") + ")::text"
+}
+
+// keyList is the key in the order the ranges are cut in. Text keys sort byte-wise, with COLLATE "C": the two
+// databases may have different collations, and the ranges must hold the same keys on both sides.
+func (t *dataTable) keyList() string {
+	cols := shared.QuoteIdents(t.PK)
+	for i := range cols {
+		if t.PKCollatable[i] {
+			cols[i] += ` COLLATE "C"`
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+func (t *dataTable) keyText() string {
+	var cols []string
+	for _, k := range shared.QuoteIdents(t.PK) {
+		cols = append(cols, k+"::text")
+	}
+	return strings.Join(cols, ", ")
+}
+
+// keyTuple renders key values as a row of typed parameters, starting at $n
+func (t *dataTable) keyTuple(n int) string {
+	params := make([]string, len(t.PK))
+	for i, typ := range t.PKTypes {
+		params[i] = fmt.Sprintf("$%d::%s", n+i, typ)
+		if t.PKCollatable[i] {
+			params[i] += ` COLLATE "C"`
+		}
+	}
+	return "ROW(" + strings.Join(params, ", ") + ")"
+}
+
+// where renders the range condition and its arguments
+func (t *dataTable) where(r keyRange) (string, []any) {
+	var conds []string
+	var args []any
+	if r.Lo != nil {
+		conds = append(conds, fmt.Sprintf("ROW(%s) >= %s", t.keyList(), t.keyTuple(len(args)+1)))
+		for _, v := range r.Lo {
+			args = append(args, v)
+		}
+	}
+	if r.Hi != nil {
+		conds = append(conds, fmt.Sprintf("ROW(%s) < %s", t.keyList(), t.keyTuple(len(args)+1)))
+		for _, v := range r.Hi {
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "true", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// keyLiteral renders a key as a typed literal row, for the repair SQL
+func (t *dataTable) keyLiteral(key []string) string {
+	vals := make([]string, len(key))
+	for i, v := range key {
+		vals[i] = shared.QuoteLiteral(v) + "::" + t.PKTypes[i]
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
+
+type dataComparer struct {
+	ctx    context.Context
+	c1, c2 *pgx.Conn
+	t      *dataTable
+	res    *tableDataDiff
+	repair *bufio.Writer
+}
+
+// compareWhole hashes the whole table on both sides; without a key the rows cannot be matched up
+func (c *dataComparer) compareWhole() error {
+	query := fmt.Sprintf(`SELECT count(*), coalesce(md5(string_agg(r, E'\n' ORDER BY r)), '')
+		FROM (SELECT %s AS r FROM ONLY %s) s`, c.t.rowText(), c.t.name())
+	var h1, h2 string
+	if err := c.c1.QueryRow(c.ctx, query).Scan(&c.res.Rows1, &h1); err != nil {
+		return err
+	}
+	if err := c.c2.QueryRow(c.ctx, query).Scan(&c.res.Rows2, &h2); err != nil {
+		return err
+	}
+	c.res.Chunks = 1
+	c.res.Status = "identical"
+	if h1 != h2 {
+		c.res.Status = "different"
+		c.res.Detail = "no primary key: the table hashes differ, rows cannot be matched"
+	} else {
+		c.res.Detail = "no primary key: compared as a whole"
+	}
+	return nil
+}
+
+// compareChunks cuts the table into ranges of DataDiffChunkSize keys, taken from the first database, and compares them.
+// The first and last ranges are open-ended, so rows beyond the first database's keys are caught too.
+func (c *dataComparer) compareChunks() error {
+	size := types.DataDiffChunkSize
+	if size < 1 {
+		size = 10000
+	}
+	rows, err := c.c1.Query(c.ctx, fmt.Sprintf(`SELECT %s FROM (
+			SELECT %s, row_number() OVER (ORDER BY %s) AS pgtools_rn FROM ONLY %s) s
+		WHERE pgtools_rn %% %d = 1 AND pgtools_rn > 1
+		ORDER BY %s`, c.t.keyText(), strings.Join(shared.QuoteIdents(c.t.PK), ", "), c.t.keyList(), c.t.name(), size,
+		c.t.keyList()))
+	if err != nil {
+		return err
+	}
+	bounds, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) ([]string, error) {
+		key := make([]string, len(c.t.PK))
+		ptrs := make([]any, len(key))
+		for i := range key {
+			ptrs[i] = &key[i]
+		}
+		return key, row.Scan(ptrs...)
+	})
+	if err != nil {
+		return err
+	}
+
+	var lo []string
+	for i := 0; i <= len(bounds); i++ {
+		var hi []string
+		if i < len(bounds) {
+			hi = bounds[i]
+		}
+		n1, n2, err := c.compareRange(keyRange{Lo: lo, Hi: hi})
+		if err != nil {
+			return err
+		}
+		c.res.Rows1 += n1
+		c.res.Rows2 += n2
+		c.res.Chunks++
+		lo = hi
+	}
+
+	c.res.Status = "identical"
+	if c.res.Differing > 0 {
+		c.res.Status = "different"
+		c.res.Detail = fmt.Sprintf("%d row(s) differ", c.res.Differing)
+	}
+	return nil
+}
+
+// compareRange hashes a range on both sides; when they disagree it is split in two, or compared row by row
+// once small enough. It returns the row counts of the range.
+func (c *dataComparer) compareRange(r keyRange) (int64, int64, error) {
+	n1, h1, err := c.hashRange(c.c1, r)
+	if err != nil {
+		return 0, 0, err
+	}
+	n2, h2, err := c.hashRange(c.c2, r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n1 == n2 && h1 == h2 {
+		return n1, n2, nil
+	}
+	logging.Debugf("%s: range %v-%v differs (%d/%d rows)", c.res.Table, r.Lo, r.Hi, n1, n2)
+
+	if max(n1, n2) <= leafRows {
+		return n1, n2, c.compareRows(r)
+	}
+
+	// split on the median key of the larger side
+	conn, n := c.c1, n1
+	if n2 > n1 {
+		conn, n = c.c2, n2
+	}
+	mid, err := c.keyAt(conn, r, n/2)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, _, err := c.compareRange(keyRange{Lo: r.Lo, Hi: mid}); err != nil {
+		return 0, 0, err
+	}
+	if _, _, err := c.compareRange(keyRange{Lo: mid, Hi: r.Hi}); err != nil {
+		return 0, 0, err
+	}
+	return n1, n2, nil
+}
+
+func (c *dataComparer) hashRange(conn *pgx.Conn, r keyRange) (int64, string, error) {
+	where, args := c.t.where(r)
+	var n int64
+	var hash string
+	err := conn.QueryRow(c.ctx, fmt.Sprintf(`SELECT count(*), coalesce(md5(string_agg(%s, E'\n' ORDER BY %s)), '')
+		FROM ONLY %s WHERE %s`, c.t.rowText(), c.t.keyList(), c.t.name(), where), args...).Scan(&n, &hash)
+	return n, hash, err
+}
+
+func (c *dataComparer) keyAt(conn *pgx.Conn, r keyRange, offset int64) ([]string, error) {
+	where, args := c.t.where(r)
+	key := make([]string, len(c.t.PK))
+	ptrs := make([]any, len(key))
+	for i := range key {
+		ptrs[i] = &key[i]
+	}
+	err := conn.QueryRow(c.ctx, fmt.Sprintf("SELECT %s FROM ONLY %s WHERE %s ORDER BY %s OFFSET %d LIMIT 1",
+		c.t.keyText(), c.t.name(), where, c.t.keyList(), offset), args...).Scan(ptrs...)
+	return key, err
+}
+
+type keyedRow struct {
+	key        []string
+	text, json string
+}
+
+// fetchRows reads a range with its keys, the row text and, for the repair SQL, the row as JSON
+func (c *dataComparer) fetchRows(conn *pgx.Conn, r keyRange) ([]keyedRow, error) {
+	where, args := c.t.where(r)
+	rows, err := conn.Query(c.ctx, fmt.Sprintf(`SELECT %s, %s, to_jsonb(x)::text
+		FROM (SELECT %s FROM ONLY %s WHERE %s) x ORDER BY %s`,
+		c.t.keyText(), c.t.rowText(), strings.Join(shared.QuoteIdents(c.t.Columns), ", "), c.t.name(), where, c.t.keyList()), args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (keyedRow, error) {
+		kr := keyedRow{key: make([]string, len(c.t.PK))}
+		ptrs := make([]any, 0, len(kr.key)+2)
+		for i := range kr.key {
+			ptrs = append(ptrs, &kr.key[i])
+		}
+		ptrs = append(ptrs, &kr.text, &kr.json)
+		return kr, row.Scan(ptrs...)
+	})
+}
+
+func (c *dataComparer) compareRows(r keyRange) error {
+	rows1, err := c.fetchRows(c.c1, r)
+	if err != nil {
+		return err
+	}
+	rows2, err := c.fetchRows(c.c2, r)
+	if err != nil {
+		return err
+	}
+
+	second := map[string]*keyedRow{}
+	for i := range rows2 {
+		second[strings.Join(rows2[i].key, "\x00")] = &rows2[i]
+	}
+	for _, row := range rows1 {
+		k := strings.Join(row.key, "\x00")
+		other, ok := second[k]
+		delete(second, k)
+		switch {
+		case !ok:
+			c.record(rowDiff{Key: row.key, Status: diffMissing, First: row.text, json: row.json})
+		case other.text != row.text:
+			c.record(rowDiff{Key: row.key, Status: diffChanged, First: row.text, Second: other.text, json: row.json})
+		}
+	}
+	for _, row := range rows2 {
+		if _, ok := second[strings.Join(row.key, "\x00")]; ok {
+			c.record(rowDiff{Key: row.key, Status: diffExtra, Second: row.text})
+		}
+	}
+	return nil
+}
+
+// record keeps the first DataDiffMaxRows differences for display and writes every one of them to the repair file
+func (c *dataComparer) record(d rowDiff) {
+	c.res.Differing++
+	if len(c.res.Rows) < types.DataDiffMaxRows {
+		c.res.Rows = append(c.res.Rows, d)
+	}
+	if c.repair != nil {
+		if c.res.Differing == 1 {
+			fmt.Fprintf(c.repair, "\n-- %s\n", c.t.name())
+		}
+		if stmt := c.t.repairSQL(d); stmt != "" {
+			fmt.Fprintln(c.repair, stmt)
+		}
+	}
+}
+
+// repairSQL returns the statement that makes the second database's row match the first's.
+// Rows are rebuilt from their JSON form with jsonb_populate_record, which takes care of every data type.
+func (t *dataTable) repairSQL(d rowDiff) string {
+	var cols, sets []string
+	for i, col := range t.Columns {
+		if t.Generated[i] {
+			continue
+		}
+		cols = append(cols, shared.QuoteIdent(col))
+		if !slices.Contains(t.PK, col) {
+			sets = append(sets, shared.QuoteIdent(col))
+		}
+	}
+	where := fmt.Sprintf("(%s) = %s", strings.Join(shared.QuoteIdents(t.PK), ", "), t.keyLiteral(d.Key))
+
+	switch d.Status {
+	case diffMissing:
+		colList := strings.Join(cols, ", ")
+		return fmt.Sprintf("INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM jsonb_populate_record(NULL::%s, %s);",
+			t.name(), colList, colList, t.name(), shared.QuoteLiteral(d.json))
+	case diffExtra:
+		return fmt.Sprintf("DELETE FROM %s WHERE %s;", t.name(), where)
+	case diffChanged:
+		if len(sets) == 0 {
+			return ""
+		}
+		setList := strings.Join(sets, ", ")
+		return fmt.Sprintf("UPDATE %s SET (%s) = (SELECT %s FROM jsonb_populate_record(NULL::%s, %s)) WHERE %s;",
+			t.name(), setList, setList, t.name(), shared.QuoteLiteral(d.json), where)
+	}
+	return ""
+}
+
+// printDataDiff prints the per-table summary, then the differing rows; it returns the number of tables that differ
+func printDataDiff(from, to string, results []*tableDataDiff) int {
+	fmt.Printf("Data diff: %s → %s\n", from, to)
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"Table", "Rows (first)", "Rows (second)", "Chunks", "Status", "Detail"})
+	different := 0
+	for _, r := range results {
+		status := r.Status
+		switch status {
+		case "identical":
+			status = hf.Green(status)
+		case "skipped":
+			status = hf.Yellow(status)
+			different++
+		default:
+			status = hf.Red(status)
+			different++
+		}
+		tw.AppendRow(table.Row{r.Table, r.Rows1, r.Rows2, r.Chunks, status, r.Detail})
+	}
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Render()
+
+	for _, r := range results {
+		if len(r.Rows) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s: %d row(s) differ", r.Table, r.Differing)
+		if len(r.Rows) < r.Differing {
+			fmt.Printf(", first %d shown", len(r.Rows))
+		}
+		fmt.Println()
+		rw := table.NewWriter()
+		rw.SetOutputMirror(os.Stdout)
+		rw.AppendHeader(table.Row{"Key", "Status", "First", "Second"})
+		for _, d := range r.Rows {
+			rw.AppendRow(table.Row{strings.Join(d.Key, ", "), d.Status, text.Trim(d.First, 80), text.Trim(d.Second, 80)})
+		}
+		rw.SetStyle(table.StyleLight)
+		rw.Style().Format.Header = text.FormatDefault
+		rw.Render()
+	}
+	return different
+}
@@ -0,0 +1,244 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/18 17:45
+// Original filename: src/db/drill.go
+//
+// db drill: prove that an archive restores. The archive is restored into a throw-away database,
+// validated against its manifest, optionally smoke-tested, and the throw-away database is always dropped.
+
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"pgtools/logging"
+	"pgtools/types"
+	"strings"
+	"syscall"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+)
+
+// drillReport is the JSON report of a drill
+type drillReport struct {
+	Archive     string            `json:"archive"`
+	Environment string            `json:"environment"`
+	Host        string            `json:"host"`
+	Database    string            `json:"database"`
+	Started     time.Time         `json:"started"`
+	Finished    time.Time         `json:"finished"`
+	DurationMs  int64             `json:"durationMs"`
+	Success     bool              `json:"success"`
+	Error       string            `json:"error,omitempty"`
+	Steps       []drillStep       `json:"steps"`
+	Validation  *validationResult `json:"validation,omitempty"`
+}
+
+type drillStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // ok, failed, skipped
+	DurationMs int64  `json:"durationMs"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// DrillArchive restores arcname into a temporary database, checks it, and drops it.
+func DrillArchive(cfg *types.DBConfig, arcname string) *ce.CustomError {
+	rewriter, cerr := newStatementRewriter(types.RestoreNoOwner, types.RestoreNoPrivileges, types.RestoreOwnerMap, types.RestoreRoleMap)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := checkDrillable(arcname); cerr != nil {
+		return cerr
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return &ce.CustomError{Code: 240, Title: "Unable to generate a database name", Message: err.Error()}
+	}
+	report := &drillReport{
+		Archive:     arcname,
+		Environment: types.EnvConfigFile,
+		Host:        cfg.Host,
+		Database:    "pgtools_drill_" + hex.EncodeToString(suffix),
+		Started:     time.Now(),
+	}
+
+	// Ctrl-C cancels the running step; the temporary database is dropped all the same
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	drillErr := report.step("create", func() (string, *ce.CustomError) {
+		return report.Database, CreateDatabase(cfg, report.Database, "")
+	})
+	if drillErr == nil {
+		drillErr = runDrill(ctx, cfg, arcname, report, rewriter)
+
+		if err := report.step("drop", func() (string, *ce.CustomError) {
+			return "", DropDatabase(cfg, report.Database, true)
+		}); err != nil && drillErr == nil {
+			drillErr = err
+		}
+	}
+	if drillErr == nil && ctx.Err() != nil {
+		drillErr = &ce.CustomError{Code: 243, Title: "Drill interrupted", Message: ctx.Err().Error()}
+	}
+
+	report.Finished = time.Now()
+	report.DurationMs = report.Finished.Sub(report.Started).Milliseconds()
+	report.Success = drillErr == nil
+	if drillErr != nil {
+		report.Error = drillErr.Error()
+	}
+
+	if err := report.write(); err != nil {
+		return err
+	}
+	if drillErr != nil {
+		fmt.Println(hf.Red(fmt.Sprintf("Drill of %s FAILED after %s", arcname, report.Finished.Sub(report.Started).Round(time.Millisecond))))
+		return drillErr
+	}
+	fmt.Println(hf.Green(fmt.Sprintf("Drill of %s succeeded in %s", arcname, report.Finished.Sub(report.Started).Round(time.Millisecond))))
+	return nil
+}
+
+// checkDrillable refuses, before anything is created, the archives whose manifest shows they cannot restore
+// into one empty database: several databases (backup -a), or data without its schema (backup without --schema).
+// Archives without a manifest (pg_dump, pg_dumpall) are checked while restoring: a second \connect fails the drill.
+func checkDrillable(arcname string) *ce.CustomError {
+	manifest, cerr := readManifest(arcname)
+	if cerr != nil {
+		return nil
+	}
+	if len(manifest.Databases) > 1 {
+		return &ce.CustomError{Code: 245, Title: "Multi-database archive",
+			Message: fmt.Sprintf("%s holds %d databases; drill a backup of each database instead", arcname, len(manifest.Databases))}
+	}
+	if len(manifest.Databases) == 1 && !manifest.Databases[0].Schema {
+		return &ce.CustomError{Code: 246, Title: "Archive without schema",
+			Message: fmt.Sprintf("%s only holds rows, which cannot be loaded into an empty database; make the backup with db backup --schema", arcname)}
+	}
+	return nil
+}
+
+// runDrill runs the restore, validation and smoke-test steps; it stops at the first failure.
+func runDrill(ctx context.Context, cfg *types.DBConfig, arcname string, report *drillReport, rewriter *statementRewriter) *ce.CustomError {
+	err := report.step("restore", func() (string, *ce.CustomError) {
+		loader := &scriptLoader{cfg: cfg, rewriter: rewriter, pinned: true}
+		return "", restoreDB(ctx, loader, arcname, report.Database)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = report.step("validate", func() (string, *ce.CustomError) {
+		manifest, cerr := readManifest(arcname)
+		if cerr != nil {
+			return "skipped: " + cerr.Message, nil
+		}
+		result, cerr := validateManifest(cfg, manifest, report.Database)
+		if cerr != nil {
+			return "", cerr
+		}
+		report.Validation = result
+		if len(result.Diffs) > 0 {
+			printValidation(arcname, result)
+			return "", &ce.CustomError{Code: 234, Title: "Validation failed", Message: fmt.Sprintf("%d difference(s) found", len(result.Diffs))}
+		}
+		return fmt.Sprintf("%d table(s), %d sequence(s) match", result.Tables, result.Sequences), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, smoke := range types.DrillSmokeFiles {
+		if ctx.Err() != nil {
+			return &ce.CustomError{Code: 243, Title: "Drill interrupted", Message: ctx.Err().Error()}
+		}
+		err = report.step("smoke "+filepath.Base(smoke), func() (string, *ce.CustomError) {
+			return runSmokeTest(ctx, cfg, report.Database, smoke)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSmokeTest executes every statement of a SQL file in the drill database; any error fails the drill.
+func runSmokeTest(ctx context.Context, cfg *types.DBConfig, dbname, fname string) (string, *ce.CustomError) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return "", &ce.CustomError{Code: 241, Title: "Unable to open smoke test", Message: err.Error()}
+	}
+	defer file.Close()
+
+	conn, cerr := Connect(cfg, dbname)
+	if cerr != nil {
+		return "", cerr
+	}
+	defer safeClose(conn)
+
+	splitter := NewSQLSplitter(file)
+	count := 0
+	for {
+		item, err := splitter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", &ce.CustomError{Code: 241, Title: "Unable to read smoke test", Message: fmt.Sprintf("%s line %d: %s", fname, splitter.Line(), err.Error())}
+		}
+		if item.Meta {
+			logging.Infof("Ignoring meta-command in %s at line %d: %s", fname, item.Line, item.Text)
+			continue
+		}
+		if _, err := conn.Exec(ctx, item.Text); err != nil {
+			return "", &ce.CustomError{Code: 242, Title: "Smoke test failed",
+				Message: fmt.Sprintf("%s line %d: %s\n%s", fname, item.Line, err.Error(), item.Text)}
+		}
+		count++
+	}
+	return fmt.Sprintf("%d statement(s)", count), nil
+}
+
+// step times fn and records its outcome
+func (r *drillReport) step(name string, fn func() (string, *ce.CustomError)) *ce.CustomError {
+	logging.Infof("Drill step: %s", name)
+	start := time.Now()
+	detail, err := fn()
+	s := drillStep{Name: name, Status: "ok", DurationMs: time.Since(start).Milliseconds(), Detail: detail}
+	if err != nil {
+		s.Status = "failed"
+		s.Detail = err.Error()
+	} else if strings.HasPrefix(detail, "skipped:") {
+		s.Status = "skipped"
+	}
+	r.Steps = append(r.Steps, s)
+	fmt.Printf("%-24s %-8s %8dms  %s\n", name, s.Status, s.DurationMs, s.Detail)
+	return err
+}
+
+func (r *drillReport) write() *ce.CustomError {
+	fname := types.DrillReport
+	if fname == "" {
+		fname = fmt.Sprintf("drill-%s.json", r.Started.Format("20060102-150405"))
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return &ce.CustomError{Code: 244, Title: "Unable to encode drill report", Message: err.Error()}
+	}
+	if err := os.WriteFile(fname, data, 0644); err != nil {
+		return &ce.CustomError{Code: 244, Title: "Unable to write drill report", Message: err.Error()}
+	}
+	fmt.Printf("Drill report written to %s\n", fname)
+	return nil
+}
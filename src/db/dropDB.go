@@ -9,6 +9,7 @@ import (
 	"context"
 	"pgtools/shared"
 
+	"github.com/jackc/pgx/v5"
 	ce "github.com/jeanfrancoisgratton/customError/v2"
 	"pgtools/logging"
 	"pgtools/types"
@@ -39,13 +40,8 @@ func DropDatabase(cfg *types.DBConfig, dbname string, force bool) *ce.CustomErro
 
 	// If force requested (or previous attempt failed), terminate backends and drop.
 	if force {
-		kill := `
-SELECT pg_terminate_backend(pid)
-FROM pg_stat_activity
-WHERE datname = $1
-  AND pid <> pg_backend_pid();`
-		if _, e := conn.Exec(context.Background(), kill, dbname); e != nil {
-			return &ce.CustomError{Code: 711, Title: "Terminate backends failed", Message: e.Error()}
+		if err := terminateSessions(conn, dbname); err != nil {
+			return err
 		}
 	}
 
@@ -58,3 +54,16 @@ WHERE datname = $1
 	logging.Infof("Dropped database %s", dbname)
 	return nil
 }
+
+// terminateSessions disconnects every other session connected to dbname
+func terminateSessions(conn *pgx.Conn, dbname string) *ce.CustomError {
+	kill := `
+SELECT pg_terminate_backend(pid)
+FROM pg_stat_activity
+WHERE datname = $1
+  AND pid <> pg_backend_pid();`
+	if _, e := conn.Exec(context.Background(), kill, dbname); e != nil {
+		return &ce.CustomError{Code: 711, Title: "Terminate backends failed", Message: e.Error()}
+	}
+	return nil
+}
@@ -20,22 +20,63 @@ import (
 )
 
 // writeDatabaseSQL connects to dbName, enumerates user tables, and writes INSERT statements
-// for their contents to the provided writer.
+// for their contents to the provided writer, followed by the sequence positions.
+// Everything is read in one REPEATABLE READ transaction, which the manifest of the database is
+// also collected from: the row counts it records are those of the rows dumped.
 // NOTE: This version intentionally avoids importing pgtools/show to break the package cycle.
-func writeDatabaseSQL(cfg *types.DBConfig, dbName string, writer io.Writer) *ce.CustomError {
+func writeDatabaseSQL(cfg *types.DBConfig, dbName string, writer io.Writer) (*types.DatabaseManifest, *ce.CustomError) {
 	logging.Debugf("Entering writeDatabaseSQL for %s", dbName)
 	logging.Infof("Processing database: %s", dbName)
 
 	// Connect to target DB
 	conn, err := Connect(cfg, dbName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer conn.Close(context.Background())
 
-	// Optional header
+	tx, terr := conn.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if terr != nil {
+		return nil, &ce.CustomError{Code: 211, Title: "Unable to start the dump transaction", Message: terr.Error()}
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := dumpTables(conn, dbName, writer); err != nil {
+		return nil, err
+	}
+	dm, err := collectDatabaseManifest(conn, dbName, types.BackupHashes)
+	if err != nil {
+		return nil, err
+	}
+	dm.Schema = types.BackupSchema
+	return dm, nil
+}
+
+// dumpTables writes the rows of every user table, then the sequence positions, as one transaction.
+// With --schema, the DDL is written around them the way pg_dump does: pre-data before the rows,
+// post-data (constraints, indexes, triggers, sequence positions) after.
+func dumpTables(conn *pgx.Conn, dbName string, writer io.Writer) *ce.CustomError {
+	ctx := context.Background()
+	snap := &schemaSnapshot{}
+	if types.BackupSchema {
+		var cerr *ce.CustomError
+		if snap, cerr = loadSchemaSnapshot(ctx, conn, nil); cerr != nil {
+			return cerr
+		}
+	} else if err := snap.loadSequences(ctx, conn, nil); err != nil {
+		return &ce.CustomError{Code: 212, Title: "Unable to list sequences", Message: err.Error()}
+	}
+
+	// Optional header; \connect sends every database of a multi-database archive to its own database
 	fmt.Fprintf(writer, "--\n-- Database: %s\n-- Generated at: %s\n--\n\n", dbName, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(writer, "\\connect \"%s\"\n\n", strings.ReplaceAll(dbName, `"`, `""`))
 	fmt.Fprintln(writer, "BEGIN;")
+	if types.BackupSchema {
+		fmt.Fprintln(writer, "SET LOCAL check_function_bodies = false;")
+		for _, stmt := range snap.preDataDDL() {
+			fmt.Fprintln(writer, stmt.SQL)
+		}
+	}
 
 	// List user tables
 	tables, cerr := getTableNames(conn)
@@ -63,9 +104,8 @@ func writeDatabaseSQL(cfg *types.DBConfig, dbName string, writer io.Writer) *ce.
 			continue
 		}
 
-		// Construct SELECT
-		selectSQL := fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(shared.QuoteIdents(cols), ", "), full)
-		ctx := context.Background()
+		// Construct SELECT; ONLY, since the rows of inheritance children and partitions are dumped with them
+		selectSQL := fmt.Sprintf(`SELECT %s FROM ONLY %s`, strings.Join(shared.QuoteIdents(cols), ", "), full)
 		rows, qerr := conn.Query(ctx, selectSQL)
 		if qerr != nil {
 			return &ce.CustomError{Code: 205, Title: "Query failed", Message: qerr.Error()}
@@ -107,6 +147,19 @@ func writeDatabaseSQL(cfg *types.DBConfig, dbName string, writer io.Writer) *ce.
 		rows.Close()
 	}
 
+	// Sequence positions, so that the restored sequences carry on where the dumped ones were
+	if types.BackupSchema {
+		for _, stmt := range snap.postDataDDL() {
+			fmt.Fprintln(writer, stmt.SQL)
+		}
+	} else {
+		for _, q := range snap.Sequences {
+			if stmt := q.setvalSQL(); stmt != "" {
+				fmt.Fprintf(writer, "%s;\n", stmt)
+			}
+		}
+	}
+
 	fmt.Fprintln(writer, "COMMIT;")
 	return nil
 }
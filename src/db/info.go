@@ -0,0 +1,221 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 09:05
+// Original filename: src/db/info.go
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pgtools/logging"
+	"pgtools/types"
+	"strings"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// databaseInfo is the profile shown by db info
+type databaseInfo struct {
+	Name              string            `json:"name"`
+	Owner             string            `json:"owner"`
+	Encoding          string            `json:"encoding"`
+	Collate           string            `json:"collate"`
+	Ctype             string            `json:"ctype"`
+	LocaleProvider    string            `json:"localeProvider,omitempty"`
+	IsTemplate        bool              `json:"isTemplate"`
+	AllowConnections  bool              `json:"allowConnections"`
+	Tablespace        string            `json:"tablespace"`
+	SizeBytes         int64             `json:"sizeBytes"`
+	Size              string            `json:"size"`
+	ConnectionLimit   int               `json:"connectionLimit"`
+	Connections       int               `json:"connections"`
+	ActiveConnections int               `json:"activeConnections"`
+	Schemas           int               `json:"schemas"`
+	Tables            int               `json:"tables"`
+	Indexes           int               `json:"indexes"`
+	FrozenXIDAge      int64             `json:"frozenXidAge"`
+	StatsReset        *time.Time        `json:"statsReset,omitempty"`
+	Settings          []string          `json:"settings"`        // ALTER DATABASE / ALTER ROLE ... IN DATABASE settings
+	SessionDefaults   []string          `json:"sessionDefaults"` // as GetDBAttributes reports them
+	Extensions        map[string]string `json:"extensions"`
+	Definition        string            `json:"definition"`
+}
+
+// ShowDatabaseInfo prints the profile of a database, as a table or as JSON.
+func ShowDatabaseInfo(cfg *types.DBConfig, dbname string, asJSON bool) *ce.CustomError {
+	logging.Debugf("Entering function: ShowDatabaseInfo(%s)", dbname)
+	info, err := collectDatabaseInfo(cfg, dbname)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		data, e := json.MarshalIndent(info, "", "  ")
+		if e != nil {
+			return &ce.CustomError{Code: 731, Title: "Unable to encode database info", Message: e.Error()}
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printDatabaseInfo(info)
+	return nil
+}
+
+func collectDatabaseInfo(cfg *types.DBConfig, dbname string) (*databaseInfo, *ce.CustomError) {
+	info := &databaseInfo{Name: dbname, Settings: []string{}, Extensions: map[string]string{}}
+	var err *ce.CustomError
+
+	if info.Definition, err = GetDatabaseDefinition(cfg, dbname); err != nil {
+		return nil, err
+	}
+	if info.Owner, err = GetOwnership(dbname, cfg); err != nil {
+		return nil, err
+	}
+	if info.SessionDefaults, err = GetDBAttributes(dbname, cfg); err != nil {
+		return nil, err
+	}
+
+	conn, err := Connect(cfg, dbname)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose(conn)
+	ctx := context.Background()
+	failed := func(what string, e error) (*databaseInfo, *ce.CustomError) {
+		return nil, &ce.CustomError{Code: 730, Title: "Unable to read " + what, Message: e.Error()}
+	}
+
+	var version int
+	if e := conn.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); e != nil {
+		return failed("the server version", e)
+	}
+	provider := "''"
+	if version >= 150000 {
+		provider = "CASE d.datlocprovider WHEN 'i' THEN 'icu' WHEN 'b' THEN 'builtin' ELSE 'libc' END"
+	}
+	e := conn.QueryRow(ctx, `
+		SELECT pg_catalog.pg_encoding_to_char(d.encoding), coalesce(d.datcollate, ''), coalesce(d.datctype, ''), `+provider+`,
+			d.datistemplate, d.datallowconn, t.spcname, pg_catalog.pg_database_size(d.oid),
+			pg_catalog.pg_size_pretty(pg_catalog.pg_database_size(d.oid)), d.datconnlimit, pg_catalog.age(d.datfrozenxid),
+			(SELECT count(*) FROM pg_catalog.pg_stat_activity a WHERE a.datid = d.oid),
+			(SELECT count(*) FROM pg_catalog.pg_stat_activity a WHERE a.datid = d.oid AND a.state = 'active'),
+			(SELECT s.stats_reset FROM pg_catalog.pg_stat_database s WHERE s.datid = d.oid)
+		FROM pg_catalog.pg_database d JOIN pg_catalog.pg_tablespace t ON t.oid = d.dattablespace
+		WHERE d.datname = $1`, dbname).Scan(&info.Encoding, &info.Collate, &info.Ctype, &info.LocaleProvider,
+		&info.IsTemplate, &info.AllowConnections, &info.Tablespace, &info.SizeBytes, &info.Size, &info.ConnectionLimit,
+		&info.FrozenXIDAge, &info.Connections, &info.ActiveConnections, &info.StatsReset)
+	if e != nil {
+		return failed("the database attributes", e)
+	}
+
+	e = conn.QueryRow(ctx, `
+		SELECT (SELECT count(*) FROM pg_catalog.pg_namespace n WHERE `+userSchemaFilter+`),
+			count(*) FILTER (WHERE c.relkind IN ('r', 'p')),
+			count(*) FILTER (WHERE c.relkind IN ('i', 'I'))
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+userSchemaFilter).Scan(&info.Schemas, &info.Tables, &info.Indexes)
+	if e != nil {
+		return failed("the object counts", e)
+	}
+
+	rows, e := conn.Query(ctx, `
+		SELECT coalesce(r.rolname, ''), unnest(s.setconfig)
+		FROM pg_catalog.pg_db_role_setting s
+		JOIN pg_catalog.pg_database d ON d.oid = s.setdatabase
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = s.setrole
+		WHERE d.datname = $1
+		ORDER BY 1, 2`, dbname)
+	if e != nil {
+		return failed("the database settings", e)
+	}
+	for rows.Next() {
+		var role, setting string
+		if e := rows.Scan(&role, &setting); e != nil {
+			rows.Close()
+			return failed("the database settings", e)
+		}
+		if role != "" {
+			setting += " (role " + role + ")"
+		}
+		info.Settings = append(info.Settings, setting)
+	}
+	rows.Close()
+
+	rows, e = conn.Query(ctx, "SELECT extname, extversion FROM pg_catalog.pg_extension ORDER BY 1")
+	if e != nil {
+		return failed("the extensions", e)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, version string
+		if e := rows.Scan(&name, &version); e != nil {
+			return failed("the extensions", e)
+		}
+		info.Extensions[name] = version
+	}
+	if e := rows.Err(); e != nil {
+		return failed("the extensions", e)
+	}
+	return info, nil
+}
+
+func printDatabaseInfo(info *databaseInfo) {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	limit := "unlimited"
+	if info.ConnectionLimit >= 0 {
+		limit = fmt.Sprintf("%d", info.ConnectionLimit)
+	}
+	statsReset := "never"
+	if info.StatsReset != nil {
+		statsReset = info.StatsReset.Format(time.RFC3339)
+	}
+	locale := info.Collate
+	if info.Ctype != info.Collate {
+		locale = fmt.Sprintf("collate %s, ctype %s", info.Collate, info.Ctype)
+	}
+	if info.LocaleProvider != "" {
+		locale += " (" + info.LocaleProvider + ")"
+	}
+
+	var extensions []string
+	for _, name := range sortedKeys(info.Extensions) {
+		extensions = append(extensions, name+" "+info.Extensions[name])
+	}
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"Property", info.Name})
+	tw.AppendRows([]table.Row{
+		{"Owner", info.Owner},
+		{"Encoding", info.Encoding},
+		{"Locale", locale},
+		{"Is template", yesNo(info.IsTemplate)},
+		{"Allow connections", yesNo(info.AllowConnections)},
+		{"Tablespace", info.Tablespace},
+		{"Size", info.Size},
+		{"Connection limit", limit},
+		{"Connections", fmt.Sprintf("%d (%d active)", info.Connections, info.ActiveConnections)},
+		{"Schemas / tables / indexes", fmt.Sprintf("%d / %d / %d", info.Schemas, info.Tables, info.Indexes)},
+		{"Frozen XID age", info.FrozenXIDAge},
+		{"Last stats reset", statsReset},
+		{"Settings", orNone(strings.Join(info.Settings, "\n"))},
+		{"Extensions", orNone(strings.Join(extensions, "\n"))},
+		{"Session defaults", strings.Join(info.SessionDefaults, "\n")},
+		{"Definition", info.Definition},
+	})
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Style().Options.SeparateRows = true
+	tw.Render()
+}
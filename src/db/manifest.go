@@ -0,0 +1,180 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/18 14:02
+// Original filename: src/db/manifest.go
+//
+// Backup manifests: what a database looked like when it was backed up (row counts, sequence values,
+// object counts and optional content hashes), saved as NAME.manifest.json next to NAME.sql[.gz].
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+const manifestVersion = 1
+
+// userSchemaFilter excludes the system schemas; n is pg_namespace
+const userSchemaFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname !~ '^pg_(toast|temp_)'`
+
+// manifestFileName returns the manifest path of an archive: mydb.sql.gz -> mydb.manifest.json
+func manifestFileName(archive string) string {
+	if types.ManifestFile != "" {
+		return types.ManifestFile
+	}
+	base := strings.TrimSuffix(archive, ".gz")
+	for _, ext := range []string{".sql", ".dump"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base + ".manifest.json"
+}
+
+func writeManifest(archive string, manifest *types.BackupManifest) *ce.CustomError {
+	fname := manifestFileName(archive)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return &ce.CustomError{Code: 230, Title: "Unable to encode manifest", Message: err.Error()}
+	}
+	if err := os.WriteFile(fname, data, 0644); err != nil {
+		return &ce.CustomError{Code: 230, Title: "Unable to write manifest", Message: err.Error()}
+	}
+	logging.Infof("Manifest written to %s", fname)
+	return nil
+}
+
+func readManifest(archive string) (*types.BackupManifest, *ce.CustomError) {
+	fname := manifestFileName(archive)
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 231, Title: "Unable to read manifest",
+			Message: fmt.Sprintf("%s (archives made by pgtools db backup carry a manifest; use --manifest to point to another one)", err.Error())}
+	}
+	manifest := &types.BackupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, &ce.CustomError{Code: 231, Title: "Invalid manifest", Message: fmt.Sprintf("%s: %s", fname, err.Error())}
+	}
+	if manifest.Version > manifestVersion {
+		return nil, &ce.CustomError{Code: 231, Title: "Unsupported manifest version",
+			Message: fmt.Sprintf("%s: version %d, this pgtools knows up to %d", fname, manifest.Version, manifestVersion)}
+	}
+	return manifest, nil
+}
+
+// newBackupManifest starts a manifest for a backup made from cfg.
+func newBackupManifest(cfg *types.DBConfig) (*types.BackupManifest, *ce.CustomError) {
+	conn, cerr := Connect(cfg, "postgres")
+	if cerr != nil {
+		return nil, cerr
+	}
+	defer conn.Close(context.Background())
+
+	manifest := &types.BackupManifest{Version: manifestVersion, Created: time.Now().Format(time.RFC3339), Host: cfg.Host}
+	if err := conn.QueryRow(context.Background(), "SHOW server_version").Scan(&manifest.ServerVersion); err != nil {
+		return nil, &ce.CustomError{Code: 232, Title: "Unable to read server version", Message: err.Error()}
+	}
+	return manifest, nil
+}
+
+// collectDatabaseManifest records the current state of the connected database.
+// Per-table content hashes are computed only when hashes is true: they read every row.
+func collectDatabaseManifest(conn *pgx.Conn, dbName string, hashes bool) (*types.DatabaseManifest, *ce.CustomError) {
+	logging.Debugf("Entering function: collectDatabaseManifest for %s", dbName)
+	ctx := context.Background()
+	dm := &types.DatabaseManifest{Name: dbName, Objects: map[string]int64{}}
+
+	tables, cerr := getTableNames(conn)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, fq := range tables {
+		schema, table, _ := strings.Cut(fq, ".")
+		full := shared.QuoteQualifiedIdent(schema, table)
+		tm := types.TableManifest{Schema: schema, Name: table}
+
+		if err := conn.QueryRow(ctx, "SELECT count(*) FROM "+full).Scan(&tm.Rows); err != nil {
+			return nil, &ce.CustomError{Code: 232, Title: "Row count failed", Message: fmt.Sprintf("%s: %s", fq, err.Error())}
+		}
+		if hashes {
+			q := fmt.Sprintf(`SELECT coalesce(md5(string_agg(t::text, E'\n' ORDER BY t::text)), '') FROM %s t`, full)
+			if err := conn.QueryRow(ctx, q).Scan(&tm.Hash); err != nil {
+				return nil, &ce.CustomError{Code: 232, Title: "Table hash failed", Message: fmt.Sprintf("%s: %s", fq, err.Error())}
+			}
+		}
+		dm.Tables = append(dm.Tables, tm)
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT schemaname, sequencename, last_value
+		FROM pg_catalog.pg_sequences
+		ORDER BY schemaname, sequencename`)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 232, Title: "Unable to list sequences", Message: err.Error()}
+	}
+	for rows.Next() {
+		var sm types.SequenceManifest
+		if err := rows.Scan(&sm.Schema, &sm.Name, &sm.LastValue); err != nil {
+			rows.Close()
+			return nil, &ce.CustomError{Code: 232, Title: "Sequence scan failed", Message: err.Error()}
+		}
+		dm.Sequences = append(dm.Sequences, sm)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, &ce.CustomError{Code: 232, Title: "Sequence iteration failed", Message: rows.Err().Error()}
+	}
+
+	rows, err = conn.Query(ctx, `
+		SELECT kind, count(*) FROM (
+			SELECT CASE c.relkind
+				WHEN 'r' THEN 'table' WHEN 'p' THEN 'partitioned table' WHEN 'v' THEN 'view'
+				WHEN 'm' THEN 'materialized view' WHEN 'i' THEN 'index' WHEN 'I' THEN 'partitioned index'
+				WHEN 'S' THEN 'sequence' WHEN 'f' THEN 'foreign table' ELSE 'composite type' END
+			FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+			WHERE `+userSchemaFilter+`
+			UNION ALL
+			SELECT CASE p.prokind WHEN 'p' THEN 'procedure' WHEN 'a' THEN 'aggregate' WHEN 'w' THEN 'window function' ELSE 'function' END
+			FROM pg_catalog.pg_proc p JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+			WHERE `+userSchemaFilter+`
+			UNION ALL
+			SELECT 'trigger'
+			FROM pg_catalog.pg_trigger t JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+			JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+			WHERE NOT t.tgisinternal AND `+userSchemaFilter+`
+			UNION ALL
+			SELECT 'constraint'
+			FROM pg_catalog.pg_constraint co JOIN pg_catalog.pg_namespace n ON n.oid = co.connamespace
+			WHERE `+userSchemaFilter+`
+			UNION ALL
+			SELECT 'schema' FROM pg_catalog.pg_namespace n WHERE `+userSchemaFilter+`
+			UNION ALL
+			SELECT 'extension' FROM pg_catalog.pg_extension
+		) o(kind)
+		GROUP BY kind`)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 232, Title: "Unable to count objects", Message: err.Error()}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var n int64
+		if err := rows.Scan(&kind, &n); err != nil {
+			return nil, &ce.CustomError{Code: 232, Title: "Object count scan failed", Message: err.Error()}
+		}
+		dm.Objects[kind] = n
+	}
+	if rows.Err() != nil {
+		return nil, &ce.CustomError{Code: 232, Title: "Object count iteration failed", Message: rows.Err().Error()}
+	}
+	return dm, nil
+}
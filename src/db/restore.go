@@ -9,114 +9,458 @@ import (
 	"bufio"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"pgtools/logging"
+	"pgtools/shared"
 	"pgtools/types"
+	"regexp"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	ce "github.com/jeanfrancoisgratton/customError/v2"
 )
 
+var copyFromStdinRx = regexp.MustCompile(`(?is)^COPY\s.+\sFROM\s+stdin\b`)
+
 func RestoreDatabase(cfg *types.DBConfig, inOutArgs []string) *ce.CustomError {
+	rewriter, cerr := newStatementRewriter(types.RestoreNoOwner, types.RestoreNoPrivileges, types.RestoreOwnerMap, types.RestoreRoleMap)
+	if cerr != nil {
+		return cerr
+	}
 	for _, arg := range inOutArgs {
-		if err := restoreDB(cfg, arg); err != nil {
+		// --target keeps the whole archive in that database
+		loader := &scriptLoader{cfg: cfg, rewriter: rewriter, pinned: types.RestoreTarget != ""}
+		if err := restoreDB(context.Background(), loader, arg, types.RestoreTarget); err != nil {
 			return err
 		}
+		if types.RestoreValidate {
+			if err := ValidateArchive(cfg, arg); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func restoreDB(cfg *types.DBConfig, arcname string) *ce.CustomError {
+// restoreDB replays arcname through loader, starting in target (or in postgres when target is empty).
+func restoreDB(ctx context.Context, loader *scriptLoader, arcname, target string) *ce.CustomError {
+	script, cerr := openArchive(arcname, target)
+	if cerr != nil {
+		return cerr
+	}
+	defer script.Close()
+
+	initialDB := "postgres"
+	if target != "" {
+		initialDB = target
+	}
+
+	if cerr := loader.connect(initialDB); cerr != nil {
+		return cerr
+	}
+	defer func() {
+		safeClose(loader.conn)
+		loader.conn = nil
+	}()
+
+	return loader.run(ctx, script)
+}
+
+// openArchive opens a restore source and returns it as a SQL script stream, whatever its format:
+// pgtools or pg_dump plain scripts (optionally gzipped), or pg_dump custom-format archives, which
+// are translated on the fly. target is only used for custom-format archives (see writeScript).
+func openArchive(arcname, target string) (io.ReadCloser, *ce.CustomError) {
 	file, err := os.Open(arcname)
 	if err != nil {
-		return &ce.CustomError{Title: "could not open file", Message: err.Error(), Code: 201}
+		return nil, &ce.CustomError{Title: "could not open file", Message: err.Error(), Code: 201}
 	}
-	defer file.Close()
+	stream := &archiveStream{closers: []io.Closer{file}}
 
 	var reader io.Reader = file
 	if strings.HasSuffix(arcname, ".gz") {
 		gzReader, err := gzip.NewReader(file)
 		if err != nil {
-			return &ce.CustomError{Title: "gzip decompression failed", Message: err.Error(), Code: 202}
+			stream.Close()
+			return nil, &ce.CustomError{Title: "gzip decompression failed", Message: err.Error(), Code: 202}
 		}
-		defer gzReader.Close()
+		stream.closers = append(stream.closers, gzReader)
 		reader = gzReader
 	}
 
-	conn, cerr := Connect(cfg, "postgres")
+	br := bufio.NewReader(reader)
+	if magic, _ := br.Peek(len(pgDumpMagic)); string(magic) != pgDumpMagic {
+		stream.Reader = br
+		return stream, nil
+	}
+
+	// Custom-format archive: seek through the file when we can, stream it otherwise
+	var archive *customArchive
+	var cerr *ce.CustomError
+	if reader == io.Reader(file) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			stream.Close()
+			return nil, &ce.CustomError{Title: "could not rewind archive", Message: err.Error(), Code: 201}
+		}
+		archive, cerr = openCustomArchive(nil, file)
+	} else {
+		archive, cerr = openCustomArchive(br, nil)
+	}
 	if cerr != nil {
-		return cerr
+		stream.Close()
+		return nil, cerr
 	}
-	defer conn.Close(context.Background())
+	logging.Infof("%s is a pg_dump custom-format archive (format %s) of database %s",
+		arcname, archive.VersionString(), archive.DBName)
 
-	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, 0, 64*1024), 20*1024*1024) // allow up to 20MB lines
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.writeScript(pw, target))
+	}()
+	stream.Reader = pr
+	stream.closers = append([]io.Closer{pr}, stream.closers...)
+	return stream, nil
+}
 
-	var sb strings.Builder
-	var pendingDBName string
+// archiveStream is the script reader returned by openArchive; Close releases everything underneath.
+type archiveStream struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *archiveStream) Close() error {
+	for _, c := range s.closers {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// scriptLoader replays a plain SQL script (pgtools backups, pg_dump -Fp, pg_dumpall) the way psql would:
+// statements are executed one at a time, COPY ... FROM stdin blocks are streamed to the server,
+// and the meta-commands pg_dump emits (\connect, \restrict, \unrestrict, \encoding) are honoured.
+type scriptLoader struct {
+	cfg         *types.DBConfig
+	conn        *pgx.Conn
+	dbname      string
+	restrictKey string
+	rewriter    *statementRewriter // --no-owner, --no-privileges, --owner-map, --role-map; nil when unused
+	pinned      bool               // stay in the first database: \connect and database-level statements are skipped
+	archiveDB   string             // when pinned, the database the archive \connects to; a second one is refused
+	switched    bool               // a \connect was met; before it, only cluster-wide statements may run in postgres
+}
+
+func (l *scriptLoader) connect(dbname string) *ce.CustomError {
+	conn, err := Connect(l.cfg, dbname)
+	if err != nil {
+		return err
+	}
+	safeClose(l.conn)
+	l.conn = conn
+	l.dbname = dbname
+	logging.Infof("Restore connected to database %s", dbname)
+	return nil
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
+func (l *scriptLoader) run(ctx context.Context, reader io.Reader) *ce.CustomError {
+	splitter := NewSQLSplitter(reader)
+
+	for {
+		item, err := splitter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ce.CustomError{Title: "error reading archive", Message: fmt.Sprintf("line %d: %s", splitter.Line(), err.Error()), Code: 205}
+		}
 
-		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+		if item.Meta {
+			if cerr := l.meta(item); cerr != nil {
+				return cerr
+			}
 			continue
 		}
 
-		// Handle \c or \connect — store it, delay actual reconnection
-		if strings.HasPrefix(trimmed, `\c `) || strings.HasPrefix(trimmed, `\connect `) {
-			fields := strings.Fields(trimmed)
-			if len(fields) >= 2 {
-				pendingDBName = stripChars(fields[1], "\"")
+		if copyFromStdinRx.MatchString(item.Text) {
+			if cerr := l.copyFrom(ctx, item.Text, splitter.CopyData()); cerr != nil {
+				return cerr
 			}
 			continue
 		}
 
-		sb.WriteString(line)
-		sb.WriteString(" ")
+		if cerr := l.exec(ctx, item.Text); cerr != nil {
+			return cerr
+		}
+	}
 
-		if strings.HasSuffix(trimmed, ";") {
-			query := sb.String()
-			sb.Reset()
+	if l.restrictKey != "" {
+		logging.Errorf("Archive ended while still in \\restrict mode")
+	}
+	return nil
+}
 
-			if types.UserRoles {
-				if !strings.Contains(query, "pg_roles") &&
-					!strings.Contains(query, "pg_auth_members") &&
-					!strings.Contains(query, "pg_shadow") &&
-					!strings.Contains(query, "pg_user") {
-					continue
-				}
-			}
+// skipStatement applies the -u (roles only) filter
+func (l *scriptLoader) skipStatement(query string) bool {
+	if !types.UserRoles {
+		return false
+	}
+	return !strings.Contains(query, "pg_roles") &&
+		!strings.Contains(query, "pg_auth_members") &&
+		!strings.Contains(query, "pg_shadow") &&
+		!strings.Contains(query, "pg_user")
+}
 
-			_, err := conn.Exec(context.Background(), query)
-			if err != nil {
-				return &ce.CustomError{
-					Title:   fmt.Sprintf("query execution failed\n%s", query),
-					Message: err.Error(),
-					Code:    204,
-				}
-			}
+func (l *scriptLoader) exec(ctx context.Context, query string) *ce.CustomError {
+	if l.skipStatement(query) {
+		return nil
+	}
+	if l.pinned && isDatabaseStatement(query) {
+		logging.Infof("Staying in database %s, skipping: %s", l.dbname, query)
+		return nil
+	}
+	if cerr := l.checkDatabase(query); cerr != nil {
+		return cerr
+	}
+	query, keep := l.rewriter.rewrite(query)
+	if !keep {
+		return nil
+	}
 
-			// After successful execution of the current statement,
-			// apply any pending \connect line.
-			if pendingDBName != "" {
-				conn.Close(context.Background())
-				conn, cerr = Connect(cfg, pendingDBName)
-				if cerr != nil {
-					return cerr
-				}
-				pendingDBName = ""
-			}
+	if _, err := l.conn.Exec(ctx, query); err != nil {
+		// pg_dump prologues carry settings that older servers may not know about (transaction_timeout, ...).
+		// psql shrugs those off, so do we.
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "42704" && isSettingStatement(query) {
+			logging.Infof("Ignoring unsupported setting: %s (%s)", query, pgErr.Message)
+			return nil
+		}
+		return &ce.CustomError{
+			Title:   fmt.Sprintf("query execution failed\n%s", query),
+			Message: err.Error(),
+			Code:    204,
 		}
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
+// checkDatabase refuses the statements that would land in postgres: without --target, a script must create or
+// \connect to its database before it creates anything in one (pg_dump -C, pg_dumpall and pgtools archives all do).
+func (l *scriptLoader) checkDatabase(query string) *ce.CustomError {
+	if l.pinned || l.switched || isClusterStatement(query) {
+		return nil
+	}
+	return &ce.CustomError{Title: "No target database", Code: 247,
+		Message: fmt.Sprintf("the archive does not say which database it restores into, and would load into %s; give one with --target\n%s", l.dbname, query)}
+}
+
+// isClusterStatement reports whether query acts on the cluster rather than on the database it runs in:
+// settings, databases, roles, role memberships and tablespaces.
+func isClusterStatement(query string) bool {
+	if isSettingStatement(query) || isDatabaseStatement(query) {
+		return true
+	}
+	ed := &tokenEditor{toks: tokenizeSQL(query)}
+	switch ed.kw(0) {
+	case "CREATE", "ALTER", "DROP":
+		switch ed.kw(1) {
+		case "ROLE", "USER", "GROUP", "TABLESPACE":
+			return true
+		}
+	case "COMMENT":
+		return ed.kw(1) == "ON" && (ed.kw(2) == "ROLE" || ed.kw(2) == "TABLESPACE")
+	case "GRANT", "REVOKE":
+		// GRANT role TO role; object privileges have an ON clause
+		return ed.find(1, "ON") < 0
+	}
+	return false
+}
+
+// isDatabaseStatement reports whether query creates, drops, alters or comments on a database.
+func isDatabaseStatement(query string) bool {
+	ed := &tokenEditor{toks: tokenizeSQL(query)}
+	switch ed.kw(0) {
+	case "CREATE", "DROP", "ALTER":
+		return ed.kw(1) == "DATABASE"
+	case "COMMENT":
+		return ed.kw(1) == "ON" && ed.kw(2) == "DATABASE"
+	}
+	return false
+}
+
+// isSettingStatement reports whether query is a SET or a pg_catalog.set_config() call.
+func isSettingStatement(query string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(query))
+	return strings.HasPrefix(upper, "SET ") || strings.HasPrefix(upper, "SELECT PG_CATALOG.SET_CONFIG(")
+}
+
+// copyFrom streams a COPY ... FROM stdin data block to the server.
+func (l *scriptLoader) copyFrom(ctx context.Context, query string, data io.Reader) *ce.CustomError {
+	if l.skipStatement(query) {
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return &ce.CustomError{Title: "error reading COPY data", Message: err.Error(), Code: 206}
+		}
+		return nil
+	}
+	if cerr := l.checkDatabase(query); cerr != nil {
+		return cerr
+	}
+
+	tag, err := l.conn.PgConn().CopyFrom(ctx, data, query)
+	if err != nil {
 		return &ce.CustomError{
-			Title:   "error reading standard input",
+			Title:   fmt.Sprintf("COPY failed\n%s", query),
 			Message: err.Error(),
-			Code:    205,
+			Code:    207,
 		}
 	}
+	logging.Debugf("%s -> %d rows", query, tag.RowsAffected())
 	return nil
 }
+
+// meta handles the psql meta-commands found in pg_dump / pg_dumpall output.
+func (l *scriptLoader) meta(item *SQLItem) *ce.CustomError {
+	fields := strings.Fields(item.Text)
+	cmd := fields[0]
+	args := fields[1:]
+
+	// While restricted, psql refuses every meta-command except the matching \unrestrict
+	if l.restrictKey != "" && cmd != `\unrestrict` {
+		return &ce.CustomError{Title: "meta-command refused in restricted mode",
+			Message: fmt.Sprintf("line %d: %s", item.Line, item.Text), Code: 208}
+	}
+
+	switch cmd {
+	case `\restrict`:
+		if len(args) != 1 {
+			return &ce.CustomError{Title: "invalid \\restrict", Message: fmt.Sprintf("line %d: %s", item.Line, item.Text), Code: 208}
+		}
+		l.restrictKey = args[0]
+
+	case `\unrestrict`:
+		if len(args) != 1 || args[0] != l.restrictKey {
+			return &ce.CustomError{Title: "invalid \\unrestrict key", Message: fmt.Sprintf("line %d: %s", item.Line, item.Text), Code: 208}
+		}
+		l.restrictKey = ""
+
+	case `\c`, `\connect`:
+		dbname := connectTarget(strings.TrimSpace(strings.TrimPrefix(item.Text, cmd)))
+		if dbname == "" {
+			return &ce.CustomError{Title: "invalid \\connect", Message: fmt.Sprintf("line %d: %s", item.Line, item.Text), Code: 209}
+		}
+		l.switched = true
+		if l.pinned {
+			// pg_dumpall and pgtools backup -a archives hold several databases, which would collide in one
+			if l.archiveDB != "" && l.archiveDB != dbname {
+				return &ce.CustomError{Title: "Multi-database archive", Code: 245,
+					Message: fmt.Sprintf("line %d: the archive holds %s and %s; it cannot be restored into the single database %s", item.Line, l.archiveDB, dbname, l.dbname)}
+			}
+			l.archiveDB = dbname
+			logging.Infof("Staying in database %s, ignoring \\connect %s", l.dbname, dbname)
+			return nil
+		}
+		return l.connect(dbname)
+
+	case `\encoding`:
+		if len(args) == 1 {
+			if _, err := l.conn.Exec(context.Background(), "SET client_encoding TO "+shared.QuoteLiteral(args[0])); err != nil {
+				return &ce.CustomError{Title: "\\encoding failed", Message: err.Error(), Code: 204}
+			}
+		}
+
+	default:
+		// \set ON_ERROR_STOP and friends only matter to psql
+		logging.Infof("Ignoring meta-command at line %d: %s", item.Line, item.Text)
+	}
+	return nil
+}
+
+// connectTarget extracts the database name from the arguments of a \connect meta-command.
+// Supported forms:
+//
+//	\connect dbname
+//	\connect "Quoted Name"
+//	\connect -reuse-previous=on "dbname='name'"
+func connectTarget(argline string) string {
+	for _, arg := range splitMetaArgs(argline) {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if strings.Contains(arg, "=") {
+			return conninfoValue(arg, "dbname")
+		}
+		return arg
+	}
+	return ""
+}
+
+// splitMetaArgs splits psql meta-command arguments, honouring double quotes (doubled quotes escape).
+func splitMetaArgs(s string) []string {
+	var args []string
+	var sb strings.Builder
+	inQuote, have := false, false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' && inQuote && i+1 < len(s) && s[i+1] == '"':
+			sb.WriteByte('"')
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			have = true
+		case (c == ' ' || c == '\t') && !inQuote:
+			if have {
+				args = append(args, sb.String())
+				sb.Reset()
+				have = false
+			}
+		default:
+			sb.WriteByte(c)
+			have = true
+		}
+	}
+	if have {
+		args = append(args, sb.String())
+	}
+	return args
+}
+
+// conninfoValue returns the value of key in a libpq "key=value key='quoted value'" string.
+func conninfoValue(conninfo, key string) string {
+	i := 0
+	for i < len(conninfo) {
+		for i < len(conninfo) && conninfo[i] == ' ' {
+			i++
+		}
+		eq := strings.IndexByte(conninfo[i:], '=')
+		if eq < 0 {
+			return ""
+		}
+		k := strings.TrimSpace(conninfo[i : i+eq])
+		i += eq + 1
+		for i < len(conninfo) && conninfo[i] == ' ' {
+			i++
+		}
+
+		var sb strings.Builder
+		if i < len(conninfo) && conninfo[i] == '\'' {
+			for i++; i < len(conninfo) && conninfo[i] != '\''; i++ {
+				if conninfo[i] == '\\' && i+1 < len(conninfo) {
+					i++
+				}
+				sb.WriteByte(conninfo[i])
+			}
+			i++
+		} else {
+			for ; i < len(conninfo) && conninfo[i] != ' '; i++ {
+				sb.WriteByte(conninfo[i])
+			}
+		}
+		if k == key {
+			return sb.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,398 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/18 08:31
+// Original filename: src/db/restoreRewrite.go
+//
+// Ownership and privilege remapping applied to statements while an archive is being restored:
+//   --no-owner       drops ALTER ... OWNER TO and SET SESSION AUTHORIZATION
+//   --no-privileges  drops GRANT / REVOKE ... ON ... and ALTER DEFAULT PRIVILEGES
+//   --owner-map      renames the new owner in OWNER TO / OWNER = / AUTHORIZATION clauses
+//   --role-map       renames roles everywhere: owners, grantees, grantors, role memberships
+
+package db
+
+import (
+	"fmt"
+	"pgtools/logging"
+	"pgtools/shared"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// statementRewriter holds the restore-time ownership/privilege options.
+type statementRewriter struct {
+	noOwner      bool
+	noPrivileges bool
+	ownerMap     map[string]string
+	roleMap      map[string]string
+}
+
+// roleKeywords are role specifications that must never be remapped
+var roleKeywords = map[string]bool{"PUBLIC": true, "CURRENT_USER": true, "SESSION_USER": true, "CURRENT_ROLE": true}
+
+// newStatementRewriter builds a rewriter from the command-line options. It returns nil when no option is set.
+func newStatementRewriter(noOwner, noPrivileges bool, ownerMap, roleMap []string) (*statementRewriter, *ce.CustomError) {
+	if !noOwner && !noPrivileges && len(ownerMap) == 0 && len(roleMap) == 0 {
+		return nil, nil
+	}
+	rw := &statementRewriter{noOwner: noOwner, noPrivileges: noPrivileges}
+
+	var cerr *ce.CustomError
+	if rw.ownerMap, cerr = parseRoleMap("--owner-map", ownerMap); cerr != nil {
+		return nil, cerr
+	}
+	if rw.roleMap, cerr = parseRoleMap("--role-map", roleMap); cerr != nil {
+		return nil, cerr
+	}
+	return rw, nil
+}
+
+// parseRoleMap turns OLD:NEW pairs into a map.
+func parseRoleMap(flag string, pairs []string) (map[string]string, *ce.CustomError) {
+	m := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		oldName, newName, ok := strings.Cut(p, ":")
+		oldName, newName = strings.TrimSpace(oldName), strings.TrimSpace(newName)
+		if !ok || oldName == "" || newName == "" {
+			return nil, &ce.CustomError{Code: 210, Title: "Invalid " + flag + " value", Message: fmt.Sprintf("%q: expected OLD:NEW", p)}
+		}
+		m[oldName] = newName
+	}
+	return m, nil
+}
+
+// rewrite returns the statement to execute, or keep == false when the statement must be dropped.
+func (rw *statementRewriter) rewrite(stmt string) (string, bool) {
+	if rw == nil {
+		return stmt, true
+	}
+	toks := tokenizeSQL(stmt)
+	if len(toks) == 0 {
+		return stmt, true
+	}
+
+	ed := &tokenEditor{src: stmt, toks: toks}
+	switch ed.kw(0) {
+	case "ALTER":
+		if ed.kw(1) == "DEFAULT" && ed.kw(2) == "PRIVILEGES" {
+			if rw.noPrivileges {
+				return rw.dropped(stmt)
+			}
+			if i := ed.find(3, "FOR"); i > 0 && (ed.kw(i+1) == "ROLE" || ed.kw(i+1) == "USER") {
+				rw.mapRoleList(ed, i+2, false)
+			}
+			rw.mapGrantees(ed)
+			break
+		}
+		if i := ed.findSeq(1, "OWNER", "TO"); i > 0 {
+			if rw.noOwner {
+				return rw.dropped(stmt)
+			}
+			rw.mapRole(ed, i+2, true)
+		}
+
+	case "GRANT", "REVOKE":
+		if ed.find(1, "ON") > 0 {
+			if rw.noPrivileges {
+				return rw.dropped(stmt)
+			}
+		} else {
+			// role membership: GRANT role1, role2 TO member
+			start := 1
+			if ed.kw(0) == "REVOKE" && ed.kw(2) == "OPTION" && ed.kw(3) == "FOR" {
+				start = 4
+			}
+			rw.mapRoleList(ed, start, false)
+		}
+		rw.mapGrantees(ed)
+
+	case "SET":
+		if ed.kw(1) == "SESSION" && ed.kw(2) == "AUTHORIZATION" {
+			if rw.noOwner {
+				return rw.dropped(stmt)
+			}
+			rw.mapRole(ed, 3, true)
+		} else if ed.kw(1) == "ROLE" {
+			rw.mapRole(ed, 2, false)
+		}
+
+	case "CREATE":
+		if i := ed.find(1, "OWNER"); i > 0 && ed.kw(1) == "DATABASE" {
+			if ed.text(i+1) == "=" {
+				i++
+			}
+			rw.mapRole(ed, i+1, true)
+		}
+		if i := ed.find(1, "AUTHORIZATION"); i > 0 && ed.kw(1) == "SCHEMA" {
+			rw.mapRole(ed, i+1, true)
+		}
+	}
+
+	out := ed.apply()
+	if out != stmt {
+		logging.Debugf("Rewrote statement:\n%s\n-> %s", stmt, out)
+	}
+	return out, true
+}
+
+func (rw *statementRewriter) dropped(stmt string) (string, bool) {
+	logging.Debugf("Skipping statement: %s", stmt)
+	return "", false
+}
+
+// mapGrantees remaps the grantee list that follows the last top-level TO (GRANT) or FROM (REVOKE),
+// as well as a GRANTED BY clause.
+func (rw *statementRewriter) mapGrantees(ed *tokenEditor) {
+	for i := len(ed.toks) - 1; i > 0; i-- {
+		if ed.depth(i) == 0 && (ed.kw(i) == "TO" || ed.kw(i) == "FROM") {
+			rw.mapRoleList(ed, i+1, false)
+			break
+		}
+	}
+	if i := ed.findSeq(1, "GRANTED", "BY"); i > 0 {
+		rw.mapRole(ed, i+2, false)
+	}
+}
+
+// mapRoleList remaps a comma-separated list of role names starting at token i.
+func (rw *statementRewriter) mapRoleList(ed *tokenEditor, i int, owner bool) {
+	for i < len(ed.toks) {
+		if ed.kw(i) == "GROUP" {
+			i++
+		}
+		rw.mapRole(ed, i, owner)
+		if ed.text(i+1) != "," {
+			return
+		}
+		i += 2
+	}
+}
+
+// mapRole replaces the role name at token i. Owner positions use --owner-map first, then --role-map.
+func (rw *statementRewriter) mapRole(ed *tokenEditor, i int, owner bool) {
+	if i >= len(ed.toks) {
+		return
+	}
+	tok := ed.toks[i]
+	if tok.kind != tokWord && tok.kind != tokQuotedIdent {
+		return
+	}
+	if tok.kind == tokWord && roleKeywords[strings.ToUpper(tok.text)] {
+		return
+	}
+	name := identValue(tok)
+	if owner {
+		if n, ok := rw.ownerMap[name]; ok {
+			ed.replace(i, shared.QuoteIdentIfNeeded(n))
+			return
+		}
+	}
+	if n, ok := rw.roleMap[name]; ok {
+		ed.replace(i, shared.QuoteIdentIfNeeded(n))
+	}
+}
+
+// identValue returns the name an identifier token denotes: bare words fold to lower case,
+// quoted identifiers keep their case and lose their quotes.
+func identValue(tok sqlToken) string {
+	if tok.kind == tokQuotedIdent {
+		return strings.ReplaceAll(tok.text[1:len(tok.text)-1], `""`, `"`)
+	}
+	return strings.ToLower(tok.text)
+}
+
+const (
+	tokWord = iota
+	tokQuotedIdent
+	tokString
+	tokPunct
+)
+
+type sqlToken struct {
+	start, end int
+	kind       int
+	text       string
+}
+
+// tokenizeSQL splits one statement into words, quoted identifiers, literals and punctuation.
+// Comments and whitespace are dropped.
+func tokenizeSQL(s string) []sqlToken {
+	var toks []sqlToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(s)
+			}
+			continue
+		case c == '"' || c == '\'':
+			i++
+			for i < len(s) {
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						i += 2
+						continue
+					}
+					break
+				}
+				if c == '\'' && s[i] == '\\' && start > 0 && (s[start-1] == 'E' || s[start-1] == 'e') {
+					i++
+				}
+				i++
+			}
+			i++
+			if i > len(s) {
+				i = len(s)
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokQuotedIdent
+			}
+			toks = append(toks, sqlToken{start: start, end: i, kind: kind, text: s[start:i]})
+			continue
+		case c == '$' && (start == 0 || !shared.IsIdentByte(s[start-1])):
+			if end := strings.IndexByte(s[i+1:], '$'); end >= 0 {
+				tag := s[i : i+end+2]
+				if validDollarTag(tag) {
+					if close := strings.Index(s[i+len(tag):], tag); close >= 0 {
+						i += len(tag) + close + len(tag)
+						toks = append(toks, sqlToken{start: start, end: i, kind: tokString, text: s[start:i]})
+						continue
+					}
+				}
+			}
+		}
+
+		if shared.IsIdentByte(c) {
+			for i < len(s) && (shared.IsIdentByte(s[i]) || s[i] == '$') {
+				i++
+			}
+			toks = append(toks, sqlToken{start: start, end: i, kind: tokWord, text: s[start:i]})
+			continue
+		}
+		i++
+		toks = append(toks, sqlToken{start: start, end: i, kind: tokPunct, text: s[start:i]})
+	}
+	return toks
+}
+
+func validDollarTag(tag string) bool {
+	inner := tag[1 : len(tag)-1]
+	if inner == "" {
+		return true
+	}
+	if inner[0] >= '0' && inner[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(inner); i++ {
+		if !shared.IsIdentByte(inner[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenEditor collects token replacements and applies them to the source statement.
+type tokenEditor struct {
+	src          string
+	toks         []sqlToken
+	replacements map[int]string
+}
+
+func (e *tokenEditor) kw(i int) string {
+	if i < 0 || i >= len(e.toks) || e.toks[i].kind != tokWord {
+		return ""
+	}
+	return strings.ToUpper(e.toks[i].text)
+}
+
+func (e *tokenEditor) text(i int) string {
+	if i < 0 || i >= len(e.toks) {
+		return ""
+	}
+	return e.toks[i].text
+}
+
+// depth returns the parenthesis nesting level at token i.
+func (e *tokenEditor) depth(i int) int {
+	d := 0
+	for j := 0; j < i; j++ {
+		switch e.text(j) {
+		case "(":
+			d++
+		case ")":
+			d--
+		}
+	}
+	return d
+}
+
+// find returns the index of the first top-level keyword kw at or after from, or -1.
+func (e *tokenEditor) find(from int, kw string) int {
+	return e.findSeq(from, kw)
+}
+
+// findSeq returns the index of the first top-level occurrence of the keyword sequence, or -1.
+func (e *tokenEditor) findSeq(from int, kws ...string) int {
+	d := 0
+	for i := 0; i < len(e.toks); i++ {
+		switch e.text(i) {
+		case "(":
+			d++
+		case ")":
+			d--
+		}
+		if i < from || d != 0 {
+			continue
+		}
+		match := true
+		for k, kw := range kws {
+			if e.kw(i+k) != kw {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *tokenEditor) replace(i int, s string) {
+	if e.replacements == nil {
+		e.replacements = map[int]string{}
+	}
+	e.replacements[i] = s
+}
+
+func (e *tokenEditor) apply() string {
+	if len(e.replacements) == 0 {
+		return e.src
+	}
+	var sb strings.Builder
+	last := 0
+	for i, tok := range e.toks {
+		if r, ok := e.replacements[i]; ok {
+			sb.WriteString(e.src[last:tok.start])
+			sb.WriteString(r)
+			last = tok.end
+		}
+	}
+	sb.WriteString(e.src[last:])
+	return sb.String()
+}
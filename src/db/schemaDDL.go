@@ -0,0 +1,198 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/19 10:40
+// Original filename: src/db/schemaDDL.go
+//
+// Renders a schemaSnapshot as DDL, split the way pg_dump splits it: pre-data (everything needed to load rows)
+// and post-data (sequence values, constraints, indexes, triggers), so that data loads before indexing.
+
+package db
+
+import (
+	"fmt"
+	"pgtools/shared"
+	"strings"
+)
+
+// ddlStatement is one DDL statement. Retry marks statements that may depend on objects created
+// further down (functions returning table types, views on views); a failed one is tried again later.
+type ddlStatement struct {
+	SQL   string
+	Retry bool
+}
+
+func (s *schemaSnapshot) preDataDDL() []ddlStatement {
+	var out []ddlStatement
+	add := func(retry bool, format string, args ...any) {
+		out = append(out, ddlStatement{SQL: fmt.Sprintf(format, args...), Retry: retry})
+	}
+
+	for _, n := range s.Schemas {
+		add(false, "CREATE SCHEMA IF NOT EXISTS %s;", shared.QuoteIdentIfNeeded(n))
+	}
+	for _, e := range s.Extensions {
+		add(false, "CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s;", shared.QuoteIdentIfNeeded(e.Name), shared.QuoteIdentIfNeeded(e.Schema))
+	}
+	for _, t := range s.Types {
+		add(false, "%s;", t.createSQL())
+	}
+	for _, q := range s.Sequences {
+		if !q.Identity {
+			add(false, "%s;", q.createSQL())
+		}
+	}
+	for _, f := range s.Functions {
+		add(true, "%s;", strings.TrimSpace(f.Definition))
+	}
+	for _, t := range s.Tables {
+		add(false, "%s;", t.createSQL())
+	}
+	for _, v := range s.Views {
+		add(true, "%s;", v.createSQL())
+	}
+	return out
+}
+
+func (s *schemaSnapshot) postDataDDL() []ddlStatement {
+	var out []ddlStatement
+	add := func(format string, args ...any) {
+		out = append(out, ddlStatement{SQL: fmt.Sprintf(format, args...)})
+	}
+
+	for _, q := range s.Sequences {
+		if q.OwnedTable != "" && !q.Identity {
+			add("ALTER SEQUENCE %s OWNED BY %s.%s;", qualified(q.Schema, q.Name),
+				qualified(q.OwnedSchema, q.OwnedTable), shared.QuoteIdentIfNeeded(q.OwnedCol))
+		}
+		if stmt := q.setvalSQL(); stmt != "" {
+			add("%s;", stmt)
+		}
+	}
+	for _, c := range s.Constraints {
+		add("%s;", c.createSQL())
+	}
+	for _, x := range s.Indexes {
+		add("%s;", x.Definition)
+	}
+	for _, t := range s.Triggers {
+		add("%s;", t.Definition)
+	}
+	for _, v := range s.Views {
+		if v.Materialized {
+			add("REFRESH MATERIALIZED VIEW %s;", qualified(v.Schema, v.Name))
+		}
+	}
+	return out
+}
+
+func (t *typeDef) createSQL() string {
+	if t.Kind == "domain" {
+		return fmt.Sprintf("CREATE DOMAIN %s %s", qualified(t.Schema, t.Name), t.Definition)
+	}
+	return fmt.Sprintf("CREATE TYPE %s %s", qualified(t.Schema, t.Name), t.Definition)
+}
+
+func (q *sequenceDef) createSQL() string {
+	cycle := "NO CYCLE"
+	if q.Cycle {
+		cycle = "CYCLE"
+	}
+	return fmt.Sprintf("CREATE SEQUENCE %s AS %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d CACHE %d %s",
+		qualified(q.Schema, q.Name), q.DataType, q.Start, q.Increment, q.Min, q.Max, q.Cache, cycle)
+}
+
+// setvalSQL carries the sequence position over; identity sequences are found through their column
+func (q *sequenceDef) setvalSQL() string {
+	if q.LastValue == nil {
+		return ""
+	}
+	if q.Identity {
+		return fmt.Sprintf("SELECT pg_catalog.setval(pg_catalog.pg_get_serial_sequence(%s, %s), %d, true)",
+			shared.QuoteLiteral(qualified(q.OwnedSchema, q.OwnedTable)), shared.QuoteLiteral(q.OwnedCol), *q.LastValue)
+	}
+	return fmt.Sprintf("SELECT pg_catalog.setval(%s, %d, true)", shared.QuoteLiteral(qualified(q.Schema, q.Name)), *q.LastValue)
+}
+
+func (t *tableDef) createSQL() string {
+	var sb strings.Builder
+	sb.WriteString("CREATE ")
+	if t.Unlogged {
+		sb.WriteString("UNLOGGED ")
+	}
+	sb.WriteString("TABLE " + qualified(t.Schema, t.Name))
+
+	var elems []string
+	if t.PartitionOf == "" {
+		for _, c := range t.Columns {
+			// the columns of the parents come with INHERITS
+			if c.Local || len(t.Inherits) == 0 {
+				elems = append(elems, c.definition())
+			}
+		}
+	}
+	for _, c := range t.Checks {
+		elems = append(elems, fmt.Sprintf("CONSTRAINT %s %s", shared.QuoteIdentIfNeeded(c.Name), c.Definition))
+	}
+
+	if t.PartitionOf != "" {
+		sb.WriteString(" PARTITION OF " + t.PartitionOf)
+	}
+	if len(elems) > 0 {
+		sb.WriteString(" (\n    " + strings.Join(elems, ",\n    ") + "\n)")
+	} else if t.PartitionOf == "" {
+		sb.WriteString(" ()")
+	}
+	if t.PartitionOf != "" {
+		sb.WriteString(" " + t.PartitionSpec)
+	}
+	if len(t.Inherits) > 0 {
+		sb.WriteString(" INHERITS (" + strings.Join(t.Inherits, ", ") + ")")
+	}
+	if t.Partitioned {
+		sb.WriteString(" PARTITION BY " + t.PartitionKey)
+	}
+	return sb.String()
+}
+
+func (c *columnDef) definition() string {
+	def := shared.QuoteIdentIfNeeded(c.Name) + " " + c.Type
+	if c.Collation != "" {
+		def += " COLLATE " + c.Collation
+	}
+	switch {
+	case c.Generated == "s":
+		def += " GENERATED ALWAYS AS (" + c.Default + ") STORED"
+	case c.Identity == "a":
+		def += " GENERATED ALWAYS AS IDENTITY"
+	case c.Identity == "d":
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.Default != "":
+		def += " DEFAULT " + c.Default
+	}
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// copyColumns returns the columns COPY can write to: generated columns are computed by the server
+func (t *tableDef) copyColumns() []string {
+	var cols []string
+	for _, c := range t.Columns {
+		if c.Generated == "" {
+			cols = append(cols, c.Name)
+		}
+	}
+	return cols
+}
+
+func (c *constraintDef) createSQL() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", qualified(c.Schema, c.Table), shared.QuoteIdentIfNeeded(c.Name), c.Definition)
+}
+
+func (v *viewDef) createSQL() string {
+	if v.Materialized {
+		return fmt.Sprintf("CREATE MATERIALIZED VIEW %s AS\n%s\nWITH NO DATA", qualified(v.Schema, v.Name), v.Definition)
+	}
+	return fmt.Sprintf("CREATE VIEW %s AS\n%s", qualified(v.Schema, v.Name), v.Definition)
+}
@@ -0,0 +1,724 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/20 09:30
+// Original filename: src/db/schemaDiff.go
+//
+// db diff: compare the schemas of two databases and write the DDL that brings the second in line with the first.
+// Both sides are read with loadSchemaSnapshot; objects are matched by name, and definitions are compared
+// as the servers render them.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pgtools/shared"
+	"pgtools/types"
+	"slices"
+	"strings"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const (
+	diffMissing = "missing" // in the first database only: created by the migration
+	diffExtra   = "extra"   // in the second database only: dropped by the migration
+	diffChanged = "changed"
+)
+
+type schemaDiffItem struct {
+	Kind, Name, Status, Detail string
+}
+
+// schemaMigration holds the migration statements in the order they must run
+type schemaMigration struct {
+	drops    []string // views, triggers, constraints and indexes that are removed or rebuilt
+	creates  []string // schemas, extensions, types, sequences, functions and tables
+	alters   []string // changes to the columns and checks of existing tables
+	finish   []string // views, constraints, indexes and triggers, once the tables are in shape
+	refresh  []string // materialized views, filled once everything they read from exists
+	removals []string // tables, functions, sequences, types, extensions and schemas that go away
+	manual   []string // differences that cannot be migrated safely
+}
+
+type schemaDiff struct {
+	Items []schemaDiffItem
+	mig   schemaMigration
+}
+
+func (d *schemaDiff) add(kind, name, status, detail string) {
+	d.Items = append(d.Items, schemaDiffItem{Kind: kind, Name: name, Status: status, Detail: detail})
+}
+
+func (d *schemaDiff) manual(kind, name, detail string) {
+	d.add(kind, name, diffChanged, detail)
+	d.mig.manual = append(d.mig.manual, fmt.Sprintf("-- %s %s: %s", kind, name, detail))
+}
+
+// ParseDatabaseSpec parses [ENV:]db
+func ParseDatabaseSpec(spec string) (string, string, *ce.CustomError) {
+	env, dbname, ok := strings.Cut(spec, ":")
+	if !ok {
+		env, dbname = "", spec
+	}
+	if dbname == "" || strings.Contains(dbname, ".") {
+		return "", "", &ce.CustomError{Code: 280, Title: "Invalid database specification", Message: fmt.Sprintf("%q: expected [ENV:]db", spec)}
+	}
+	return env, dbname, nil
+}
+
+// DiffSchemas compares db1 on cfg1 (the reference) with db2 on cfg2, prints the differences and the migration
+// that turns db2 into db1.
+func DiffSchemas(cfg1, cfg2 *types.DBConfig, db1, db2 string) *ce.CustomError {
+	want, cerr := readSnapshot(cfg1, db1)
+	if cerr != nil {
+		return cerr
+	}
+	have, cerr := readSnapshot(cfg2, db2)
+	if cerr != nil {
+		return cerr
+	}
+
+	diff := diffSnapshots(want, have)
+	label1 := fmt.Sprintf("%s (%s)", db1, cfg1.Host)
+	label2 := fmt.Sprintf("%s (%s)", db2, cfg2.Host)
+	printSchemaDiff(label1, label2, diff)
+	if len(diff.Items) == 0 {
+		return nil
+	}
+
+	script := diff.mig.script(label1, label2)
+	if types.DiffOutput == "" {
+		fmt.Println()
+		fmt.Print(script)
+	} else {
+		if err := os.WriteFile(types.DiffOutput, []byte(script), 0644); err != nil {
+			return &ce.CustomError{Code: 282, Title: "Unable to write the migration", Message: err.Error()}
+		}
+		fmt.Printf("Migration written to %s\n", types.DiffOutput)
+	}
+	return &ce.CustomError{Code: 283, Title: "Schemas differ", Message: fmt.Sprintf("%d difference(s) found", len(diff.Items))}
+}
+
+func readSnapshot(cfg *types.DBConfig, dbname string) (*schemaSnapshot, *ce.CustomError) {
+	conn, cerr := Connect(cfg, dbname)
+	if cerr != nil {
+		return nil, cerr
+	}
+	defer safeClose(conn)
+	// one snapshot for all the catalog queries
+	if _, err := conn.Exec(context.Background(), "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		return nil, &ce.CustomError{Code: 281, Title: "Unable to open transaction", Message: err.Error()}
+	}
+	return loadSchemaSnapshot(context.Background(), conn, currentFilter())
+}
+
+// diffSnapshots lists what differs between want and have, and the DDL that makes have look like want
+func diffSnapshots(want, have *schemaSnapshot) *schemaDiff {
+	d := &schemaDiff{}
+	d.diffSchemas(want, have)
+	d.diffExtensions(want, have)
+	d.diffTypes(want, have)
+	d.diffSequences(want, have)
+	d.diffFunctions(want, have)
+	d.diffTables(want, have)
+	d.diffViews(want, have)
+	d.diffConstraints(want, have)
+	d.diffIndexes(want, have)
+	d.diffTriggers(want, have)
+	return d
+}
+
+// byKey indexes a slice of definitions
+func byKey[T any](items []T, key func(*T) string) map[string]*T {
+	m := make(map[string]*T, len(items))
+	for i := range items {
+		m[key(&items[i])] = &items[i]
+	}
+	return m
+}
+
+func tableKey(schema, table string) string {
+	return schema + "." + table
+}
+
+func (d *schemaDiff) diffSchemas(want, have *schemaSnapshot) {
+	for _, n := range want.Schemas {
+		if !slices.Contains(have.Schemas, n) {
+			d.add("schema", n, diffMissing, "")
+			d.mig.creates = append(d.mig.creates, fmt.Sprintf("CREATE SCHEMA %s;", shared.QuoteIdentIfNeeded(n)))
+		}
+	}
+	for _, n := range have.Schemas {
+		if !slices.Contains(want.Schemas, n) {
+			d.add("schema", n, diffExtra, "")
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP SCHEMA %s;", shared.QuoteIdentIfNeeded(n)))
+		}
+	}
+}
+
+func (d *schemaDiff) diffExtensions(want, have *schemaSnapshot) {
+	key := func(e *extensionDef) string { return e.Name }
+	haveMap, wantMap := byKey(have.Extensions, key), byKey(want.Extensions, key)
+	for _, e := range want.Extensions {
+		name := shared.QuoteIdentIfNeeded(e.Name)
+		h, ok := haveMap[e.Name]
+		switch {
+		case !ok:
+			d.add("extension", e.Name, diffMissing, "version "+e.Version)
+			d.mig.creates = append(d.mig.creates, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s VERSION %s;",
+				name, shared.QuoteIdentIfNeeded(e.Schema), shared.QuoteLiteral(e.Version)))
+		default:
+			if h.Schema != e.Schema {
+				d.add("extension", e.Name, diffChanged, fmt.Sprintf("schema %s → %s", h.Schema, e.Schema))
+				d.mig.creates = append(d.mig.creates, fmt.Sprintf("ALTER EXTENSION %s SET SCHEMA %s;", name, shared.QuoteIdentIfNeeded(e.Schema)))
+			}
+			if h.Version != e.Version {
+				d.add("extension", e.Name, diffChanged, fmt.Sprintf("version %s → %s", h.Version, e.Version))
+				d.mig.creates = append(d.mig.creates, fmt.Sprintf("ALTER EXTENSION %s UPDATE TO %s;", name, shared.QuoteLiteral(e.Version)))
+			}
+		}
+	}
+	for _, e := range have.Extensions {
+		if _, ok := wantMap[e.Name]; !ok {
+			d.add("extension", e.Name, diffExtra, "")
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP EXTENSION %s;", shared.QuoteIdentIfNeeded(e.Name)))
+		}
+	}
+}
+
+func (d *schemaDiff) diffTypes(want, have *schemaSnapshot) {
+	key := func(t *typeDef) string { return tableKey(t.Schema, t.Name) }
+	haveMap, wantMap := byKey(have.Types, key), byKey(want.Types, key)
+	for _, t := range want.Types {
+		name := qualified(t.Schema, t.Name)
+		h, ok := haveMap[key(&t)]
+		switch {
+		case !ok:
+			d.add(t.Kind, name, diffMissing, "")
+			d.mig.creates = append(d.mig.creates, t.createSQL()+";")
+		case h.Kind != t.Kind:
+			d.manual(t.Kind, name, fmt.Sprintf("is a %s in the second database; drop and recreate it by hand", h.Kind))
+		case h.Definition != t.Definition:
+			if t.Kind == "enum" {
+				d.diffEnum(&t, h)
+			} else {
+				d.manual(t.Kind, name, fmt.Sprintf("%s → %s", h.Definition, t.Definition))
+			}
+		}
+	}
+	for _, t := range have.Types {
+		if _, ok := wantMap[key(&t)]; !ok {
+			d.add(t.Kind, qualified(t.Schema, t.Name), diffExtra, "")
+			kw := "TYPE"
+			if t.Kind == "domain" {
+				kw = "DOMAIN"
+			}
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP %s %s;", kw, qualified(t.Schema, t.Name)))
+		}
+	}
+}
+
+// diffEnum adds the missing labels in place; labels cannot be removed or reordered without rebuilding the type
+func (d *schemaDiff) diffEnum(want, have *typeDef) {
+	name := qualified(want.Schema, want.Name)
+	for _, l := range have.Labels {
+		if !slices.Contains(want.Labels, l) {
+			d.manual("enum", name, fmt.Sprintf("label %s cannot be removed", shared.QuoteLiteral(l)))
+			return
+		}
+	}
+
+	var added []string
+	for i, l := range want.Labels {
+		if slices.Contains(have.Labels, l) {
+			continue
+		}
+		added = append(added, shared.QuoteLiteral(l))
+		position := ""
+		switch {
+		case i > 0:
+			position = " AFTER " + shared.QuoteLiteral(want.Labels[i-1])
+		case len(have.Labels) > 0:
+			position = " BEFORE " + shared.QuoteLiteral(have.Labels[0])
+		}
+		d.mig.creates = append(d.mig.creates, fmt.Sprintf("ALTER TYPE %s ADD VALUE %s%s;", name, shared.QuoteLiteral(l), position))
+	}
+
+	// with every label present, any remaining difference is their order
+	var kept []string
+	for _, l := range want.Labels {
+		if slices.Contains(have.Labels, l) {
+			kept = append(kept, l)
+		}
+	}
+	if !slices.Equal(kept, have.Labels) {
+		d.manual("enum", name, "labels are in a different order")
+		return
+	}
+	d.add("enum", name, diffChanged, "new label(s) "+strings.Join(added, ", "))
+}
+
+func (d *schemaDiff) diffSequences(want, have *schemaSnapshot) {
+	// identity sequences follow their column
+	key := func(q *sequenceDef) string { return tableKey(q.Schema, q.Name) }
+	haveMap, wantMap := byKey(have.Sequences, key), byKey(want.Sequences, key)
+	for _, q := range want.Sequences {
+		if q.Identity {
+			continue
+		}
+		name := qualified(q.Schema, q.Name)
+		h, ok := haveMap[key(&q)]
+		if !ok || h.Identity {
+			d.add("sequence", name, diffMissing, "")
+			d.mig.creates = append(d.mig.creates, q.createSQL()+";")
+			if q.OwnedTable != "" {
+				d.mig.finish = append(d.mig.finish, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s;", name,
+					qualified(q.OwnedSchema, q.OwnedTable), shared.QuoteIdentIfNeeded(q.OwnedCol)))
+			}
+			continue
+		}
+		var changes []string
+		if h.DataType != q.DataType {
+			changes = append(changes, fmt.Sprintf("type %s → %s", h.DataType, q.DataType))
+		}
+		for _, p := range []struct {
+			what       string
+			want, have int64
+		}{
+			{"increment", q.Increment, h.Increment}, {"minvalue", q.Min, h.Min},
+			{"maxvalue", q.Max, h.Max}, {"cache", q.Cache, h.Cache},
+		} {
+			if p.want != p.have {
+				changes = append(changes, fmt.Sprintf("%s %d → %d", p.what, p.have, p.want))
+			}
+		}
+		if h.Cycle != q.Cycle {
+			changes = append(changes, fmt.Sprintf("cycle %t → %t", h.Cycle, q.Cycle))
+		}
+		if len(changes) > 0 {
+			d.add("sequence", name, diffChanged, strings.Join(changes, ", "))
+			cycle := "NO CYCLE"
+			if q.Cycle {
+				cycle = "CYCLE"
+			}
+			d.mig.creates = append(d.mig.creates, fmt.Sprintf("ALTER SEQUENCE %s AS %s INCREMENT BY %d MINVALUE %d MAXVALUE %d CACHE %d %s;",
+				name, q.DataType, q.Increment, q.Min, q.Max, q.Cache, cycle))
+		}
+	}
+	for _, q := range have.Sequences {
+		if w, ok := wantMap[key(&q)]; !q.Identity && (!ok || w.Identity) {
+			d.add("sequence", qualified(q.Schema, q.Name), diffExtra, "")
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP SEQUENCE %s;", qualified(q.Schema, q.Name)))
+		}
+	}
+}
+
+func (d *schemaDiff) diffFunctions(want, have *schemaSnapshot) {
+	key := func(f *functionDef) string { return tableKey(f.Schema, f.Name) + "(" + f.Arguments + ")" }
+	haveMap, wantMap := byKey(have.Functions, key), byKey(want.Functions, key)
+	for _, f := range want.Functions {
+		name := qualified(f.Schema, f.Name) + "(" + f.Arguments + ")"
+		h, ok := haveMap[key(&f)]
+		switch {
+		case !ok:
+			d.add(f.Kind, name, diffMissing, "")
+		case strings.TrimSpace(h.Definition) != strings.TrimSpace(f.Definition):
+			d.add(f.Kind, name, diffChanged, "definition")
+		default:
+			continue
+		}
+		d.mig.creates = append(d.mig.creates, strings.TrimSpace(f.Definition)+";")
+	}
+	for _, f := range have.Functions {
+		if _, ok := wantMap[key(&f)]; !ok {
+			name := qualified(f.Schema, f.Name) + "(" + f.Arguments + ")"
+			d.add(f.Kind, name, diffExtra, "")
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP %s %s;", strings.ToUpper(f.Kind), name))
+		}
+	}
+}
+
+func (d *schemaDiff) diffTables(want, have *schemaSnapshot) {
+	key := func(t *tableDef) string { return tableKey(t.Schema, t.Name) }
+	haveMap, wantMap := byKey(have.Tables, key), byKey(want.Tables, key)
+	for _, t := range want.Tables {
+		name := qualified(t.Schema, t.Name)
+		h, ok := haveMap[key(&t)]
+		if !ok {
+			d.add("table", name, diffMissing, "")
+			d.mig.creates = append(d.mig.creates, t.createSQL()+";")
+			continue
+		}
+		if h.Partitioned != t.Partitioned || h.PartitionKey != t.PartitionKey ||
+			h.PartitionOf != t.PartitionOf || h.PartitionSpec != t.PartitionSpec {
+			d.manual("table", name, "partitioning differs; the table must be rebuilt")
+			continue
+		}
+		if h.Unlogged != t.Unlogged {
+			logged := "LOGGED"
+			if t.Unlogged {
+				logged = "UNLOGGED"
+			}
+			d.add("table", name, diffChanged, "persistence → "+strings.ToLower(logged))
+			d.mig.alters = append(d.mig.alters, fmt.Sprintf("ALTER TABLE %s SET %s;", name, logged))
+		}
+		// partitions take their columns from the parent
+		if t.PartitionOf == "" {
+			d.diffColumns(&t, h)
+		}
+		d.diffChecks(&t, h)
+	}
+	for _, t := range have.Tables {
+		if _, ok := wantMap[key(&t)]; !ok {
+			d.add("table", qualified(t.Schema, t.Name), diffExtra, "")
+			d.mig.removals = append(d.mig.removals, fmt.Sprintf("DROP TABLE %s;", qualified(t.Schema, t.Name)))
+		}
+	}
+}
+
+func (d *schemaDiff) diffColumns(want, have *tableDef) {
+	table := qualified(want.Schema, want.Name)
+	alter := func(format string, args ...any) {
+		d.mig.alters = append(d.mig.alters, fmt.Sprintf("ALTER TABLE %s ", table)+fmt.Sprintf(format, args...)+";")
+	}
+	key := func(c *columnDef) string { return c.Name }
+	haveMap, wantMap := byKey(have.Columns, key), byKey(want.Columns, key)
+
+	for _, c := range want.Columns {
+		name := table + "." + shared.QuoteIdentIfNeeded(c.Name)
+		col := shared.QuoteIdentIfNeeded(c.Name)
+		h, ok := haveMap[c.Name]
+		if !ok {
+			d.add("column", name, diffMissing, c.Type)
+			alter("ADD COLUMN %s", c.definition())
+			continue
+		}
+		if h.Generated != c.Generated || (c.Generated != "" && h.Default != c.Default) {
+			d.manual("column", name, "generation expression differs; drop and re-add the column")
+			continue
+		}
+
+		var changes []string
+		if h.Type != c.Type || h.Collation != c.Collation {
+			changes = append(changes, fmt.Sprintf("type %s → %s", columnType(h), columnType(&c)))
+			collate := ""
+			if c.Collation != "" {
+				collate = " COLLATE " + c.Collation
+			}
+			alter("ALTER COLUMN %s TYPE %s%s USING %s::%s", col, c.Type, collate, col, c.Type)
+		}
+		// an identity column is NOT NULL: set it before adding the identity, drop it after removing it
+		if c.NotNull && !h.NotNull {
+			changes = append(changes, "null → not null")
+			alter("ALTER COLUMN %s SET NOT NULL", col)
+		}
+		if h.Identity != c.Identity {
+			changes = append(changes, fmt.Sprintf("identity %s → %s", identityName(h.Identity), identityName(c.Identity)))
+			switch {
+			case c.Identity == "":
+				alter("ALTER COLUMN %s DROP IDENTITY", col)
+			case h.Identity != "":
+				alter("ALTER COLUMN %s SET GENERATED %s", col, identityName(c.Identity))
+			default:
+				if h.Default != "" {
+					alter("ALTER COLUMN %s DROP DEFAULT", col)
+				}
+				alter("ALTER COLUMN %s ADD GENERATED %s AS IDENTITY", col, identityName(c.Identity))
+			}
+		}
+		if c.Identity == "" && c.Generated == "" && (h.Default != c.Default || h.Identity != "") {
+			if h.Default != c.Default {
+				changes = append(changes, fmt.Sprintf("default %s → %s", orNone(h.Default), orNone(c.Default)))
+			}
+			if c.Default == "" {
+				if h.Default != "" && h.Identity == "" {
+					alter("ALTER COLUMN %s DROP DEFAULT", col)
+				}
+			} else {
+				alter("ALTER COLUMN %s SET DEFAULT %s", col, c.Default)
+			}
+		}
+		if h.NotNull && !c.NotNull {
+			changes = append(changes, "not null → null")
+			alter("ALTER COLUMN %s DROP NOT NULL", col)
+		}
+		if len(changes) > 0 {
+			d.add("column", name, diffChanged, strings.Join(changes, ", "))
+		}
+	}
+	for _, c := range have.Columns {
+		if _, ok := wantMap[c.Name]; !ok {
+			d.add("column", table+"."+shared.QuoteIdentIfNeeded(c.Name), diffExtra, c.Type)
+			alter("DROP COLUMN %s", shared.QuoteIdentIfNeeded(c.Name))
+		}
+	}
+}
+
+func columnType(c *columnDef) string {
+	if c.Collation != "" {
+		return c.Type + " COLLATE " + c.Collation
+	}
+	return c.Type
+}
+
+func identityName(identity string) string {
+	switch identity {
+	case "a":
+		return "ALWAYS"
+	case "d":
+		return "BY DEFAULT"
+	}
+	return "none"
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func (d *schemaDiff) diffChecks(want, have *tableDef) {
+	key := func(c *constraintDef) string { return c.Name }
+	haveMap, wantMap := byKey(have.Checks, key), byKey(want.Checks, key)
+	for _, c := range want.Checks {
+		h, ok := haveMap[c.Name]
+		if ok && h.Definition == c.Definition {
+			continue
+		}
+		if ok {
+			d.add("check", constraintName(&c), diffChanged, fmt.Sprintf("%s → %s", h.Definition, c.Definition))
+			d.mig.drops = append(d.mig.drops, h.dropSQL())
+		} else {
+			d.add("check", constraintName(&c), diffMissing, c.Definition)
+		}
+		d.mig.alters = append(d.mig.alters, c.createSQL()+";")
+	}
+	for _, c := range have.Checks {
+		if _, ok := wantMap[c.Name]; !ok {
+			d.add("check", constraintName(&c), diffExtra, c.Definition)
+			d.mig.drops = append(d.mig.drops, c.dropSQL())
+		}
+	}
+}
+
+func constraintName(c *constraintDef) string {
+	return qualified(c.Schema, c.Table) + "." + shared.QuoteIdentIfNeeded(c.Name)
+}
+
+func (c *constraintDef) dropSQL() string {
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", qualified(c.Schema, c.Table), shared.QuoteIdentIfNeeded(c.Name))
+}
+
+var constraintKinds = map[string]string{"p": "primary key", "u": "unique", "f": "foreign key", "x": "exclusion"}
+
+func (d *schemaDiff) diffConstraints(want, have *schemaSnapshot) {
+	key := func(c *constraintDef) string { return tableKey(c.Schema, c.Table) + "." + c.Name }
+	haveMap, wantMap := byKey(have.Constraints, key), byKey(want.Constraints, key)
+	wantTables := byKey(want.Tables, func(t *tableDef) string { return tableKey(t.Schema, t.Name) })
+
+	for _, c := range want.Constraints {
+		h, ok := haveMap[key(&c)]
+		switch {
+		case !ok:
+			d.add(constraintKinds[c.Type], constraintName(&c), diffMissing, c.Definition)
+		case h.Definition != c.Definition:
+			d.add(constraintKinds[c.Type], constraintName(&c), diffChanged, fmt.Sprintf("%s → %s", h.Definition, c.Definition))
+		default:
+			continue
+		}
+		d.mig.finish = append(d.mig.finish, c.createSQL()+";")
+	}
+
+	// foreign keys go first, before the keys they reference; constraints of dropped tables go with them
+	var fks, others []string
+	for _, c := range have.Constraints {
+		if _, ok := wantTables[tableKey(c.Schema, c.Table)]; !ok {
+			continue
+		}
+		w, ok := wantMap[key(&c)]
+		if ok && w.Definition == c.Definition {
+			continue
+		}
+		if !ok {
+			d.add(constraintKinds[c.Type], constraintName(&c), diffExtra, c.Definition)
+		}
+		if c.Type == "f" {
+			fks = append(fks, c.dropSQL())
+		} else {
+			others = append(others, c.dropSQL())
+		}
+	}
+	d.mig.drops = append(d.mig.drops, fks...)
+	d.mig.drops = append(d.mig.drops, others...)
+}
+
+func (d *schemaDiff) diffIndexes(want, have *schemaSnapshot) {
+	key := func(x *indexDef) string { return tableKey(x.Schema, x.Name) }
+	haveMap, wantMap := byKey(have.Indexes, key), byKey(want.Indexes, key)
+	wantTables := byKey(want.Tables, func(t *tableDef) string { return tableKey(t.Schema, t.Name) })
+	for _, x := range want.Indexes {
+		h, ok := haveMap[key(&x)]
+		switch {
+		case !ok:
+			d.add("index", qualified(x.Schema, x.Name), diffMissing, x.Definition)
+		case h.Definition != x.Definition:
+			d.add("index", qualified(x.Schema, x.Name), diffChanged, x.Definition)
+			d.mig.drops = append(d.mig.drops, fmt.Sprintf("DROP INDEX %s;", qualified(h.Schema, h.Name)))
+		default:
+			continue
+		}
+		d.mig.finish = append(d.mig.finish, x.Definition+";")
+	}
+	for _, x := range have.Indexes {
+		if _, ok := wantMap[key(&x)]; ok {
+			continue
+		}
+		if _, ok := wantTables[tableKey(x.Schema, x.Table)]; ok {
+			d.add("index", qualified(x.Schema, x.Name), diffExtra, x.Definition)
+			d.mig.drops = append(d.mig.drops, fmt.Sprintf("DROP INDEX %s;", qualified(x.Schema, x.Name)))
+		}
+	}
+}
+
+func (d *schemaDiff) diffViews(want, have *schemaSnapshot) {
+	key := func(v *viewDef) string { return tableKey(v.Schema, v.Name) }
+	haveMap, wantMap := byKey(have.Views, key), byKey(want.Views, key)
+	for _, v := range want.Views {
+		name := qualified(v.Schema, v.Name)
+		h, ok := haveMap[key(&v)]
+		switch {
+		case !ok:
+			d.add(v.kind(), name, diffMissing, "")
+		case h.Materialized != v.Materialized || h.Definition != v.Definition:
+			d.add(v.kind(), name, diffChanged, "definition")
+			d.mig.drops = append(d.mig.drops, h.dropSQL())
+		default:
+			continue
+		}
+		d.mig.finish = append(d.mig.finish, v.createSQL()+";")
+		if v.Materialized {
+			d.mig.refresh = append(d.mig.refresh, fmt.Sprintf("REFRESH MATERIALIZED VIEW %s;", name))
+		}
+	}
+	for _, v := range have.Views {
+		if _, ok := wantMap[key(&v)]; !ok {
+			d.add(v.kind(), qualified(v.Schema, v.Name), diffExtra, "")
+			d.mig.drops = append(d.mig.drops, v.dropSQL())
+		}
+	}
+}
+
+func (v *viewDef) kind() string {
+	if v.Materialized {
+		return "materialized view"
+	}
+	return "view"
+}
+
+func (v *viewDef) dropSQL() string {
+	return fmt.Sprintf("DROP %s %s;", strings.ToUpper(v.kind()), qualified(v.Schema, v.Name))
+}
+
+func (d *schemaDiff) diffTriggers(want, have *schemaSnapshot) {
+	key := func(t *triggerDef) string { return tableKey(t.Schema, t.Table) + "." + t.Name }
+	haveMap, wantMap := byKey(have.Triggers, key), byKey(want.Triggers, key)
+	wantTables := byKey(want.Tables, func(t *tableDef) string { return tableKey(t.Schema, t.Name) })
+	name := func(t *triggerDef) string {
+		return qualified(t.Schema, t.Table) + "." + shared.QuoteIdentIfNeeded(t.Name)
+	}
+	drop := func(t *triggerDef) string {
+		return fmt.Sprintf("DROP TRIGGER %s ON %s;", shared.QuoteIdentIfNeeded(t.Name), qualified(t.Schema, t.Table))
+	}
+	for _, t := range want.Triggers {
+		h, ok := haveMap[key(&t)]
+		switch {
+		case !ok:
+			d.add("trigger", name(&t), diffMissing, "")
+		case h.Definition != t.Definition:
+			d.add("trigger", name(&t), diffChanged, "definition")
+			d.mig.drops = append(d.mig.drops, drop(h))
+		default:
+			continue
+		}
+		d.mig.finish = append(d.mig.finish, t.Definition+";")
+	}
+	for _, t := range have.Triggers {
+		if _, ok := wantMap[key(&t)]; ok {
+			continue
+		}
+		if _, ok := wantTables[tableKey(t.Schema, t.Table)]; ok {
+			d.add("trigger", name(&t), diffExtra, "")
+			d.mig.drops = append(d.mig.drops, drop(&t))
+		}
+	}
+}
+
+// script renders the migration as a SQL file
+func (m *schemaMigration) script(from, to string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "-- pgtools db diff, %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "-- Brings %s in line with %s. Review it before running it.\n\n", to, from)
+	sb.WriteString("SET check_function_bodies = false;\n")
+
+	// removals were collected in creation order (schemas first); drop in the reverse order
+	removals := slices.Clone(m.removals)
+	slices.Reverse(removals)
+
+	for _, section := range []struct {
+		title string
+		stmts []string
+	}{
+		{"Drop dependent objects that change or go away", m.drops},
+		{"Create new objects", m.creates},
+		{"Alter existing tables", m.alters},
+		{"Views, constraints, indexes and triggers", m.finish},
+		{"Fill materialized views", m.refresh},
+		{"Drop objects that are not in the reference database", removals},
+		{"Differences that need a manual migration", m.manual},
+	} {
+		if len(section.stmts) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n-- %s\n", section.title)
+		for _, s := range section.stmts {
+			sb.WriteString(s + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func printSchemaDiff(from, to string, diff *schemaDiff) {
+	fmt.Printf("Schema diff: %s → %s\n", from, to)
+	if len(diff.Items) == 0 {
+		fmt.Println(hf.Green("The schemas are identical"))
+		return
+	}
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"Object", "Name", "Status", "Detail"})
+	for _, item := range diff.Items {
+		status := item.Status
+		switch status {
+		case diffMissing:
+			status = hf.Green(status)
+		case diffExtra:
+			status = hf.Red(status)
+		default:
+			status = hf.Yellow(status)
+		}
+		tw.AppendRow(table.Row{item.Kind, item.Name, status, item.Detail})
+	}
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Render()
+	fmt.Printf("%d difference(s): %s means only in the first database, %s only in the second\n",
+		len(diff.Items), diffMissing, diffExtra)
+}
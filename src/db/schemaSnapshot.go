@@ -0,0 +1,589 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/19 09:12
+// Original filename: src/db/schemaSnapshot.go
+//
+// A structured picture of a database schema, read from the catalogs with the server's own pg_get_*def()
+// functions. It is rendered to DDL by schemaDDL.go (db clone) and compared by db diff.
+// Objects belonging to extensions are left out: CREATE EXTENSION brings them back.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"pgtools/logging"
+	"pgtools/shared"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// notExtensionMember excludes extension members; %s is the object's oid column, %s its catalog
+const notExtensionMember = `NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend dx
+	WHERE dx.objid = %s AND dx.classid = '%s'::regclass AND dx.deptype = 'e')`
+
+type schemaSnapshot struct {
+	ServerVersion int
+	Schemas       []string
+	Extensions    []extensionDef
+	Types         []typeDef
+	Functions     []functionDef
+	Sequences     []sequenceDef
+	Tables        []tableDef
+	Constraints   []constraintDef
+	Indexes       []indexDef
+	Views         []viewDef
+	Triggers      []triggerDef
+}
+
+type extensionDef struct {
+	Name, Schema, Version string
+}
+
+type typeDef struct {
+	Schema, Name string
+	Kind         string   // enum, domain, composite
+	Definition   string   // the body after CREATE TYPE name / CREATE DOMAIN name
+	Labels       []string // enum values, in order
+}
+
+type functionDef struct {
+	Schema, Name string
+	Arguments    string // identity arguments, which tell overloads apart
+	Kind         string // function, procedure, aggregate
+	Definition   string // CREATE OR REPLACE ...
+}
+
+type sequenceDef struct {
+	Schema, Name                      string
+	DataType                          string
+	Start, Min, Max, Increment, Cache int64
+	Cycle                             bool
+	LastValue                         *int64
+	OwnedSchema, OwnedTable, OwnedCol string // OWNED BY, when the sequence belongs to a column
+	Identity                          bool   // identity sequences are created by their column
+}
+
+type tableDef struct {
+	Schema, Name  string
+	Partitioned   bool
+	Unlogged      bool
+	PartitionKey  string   // PARTITION BY ...
+	PartitionOf   string   // qualified parent, for partitions
+	PartitionSpec string   // FOR VALUES ...
+	Inherits      []string // qualified parents of a table using INHERITS, in order
+	Columns       []columnDef
+	Checks        []constraintDef
+}
+
+type columnDef struct {
+	Name      string
+	Type      string
+	NotNull   bool
+	Default   string
+	Identity  string // a (ALWAYS), d (BY DEFAULT) or empty
+	Generated string // s (STORED) or empty
+	Collation string
+	Local     bool // declared by the table itself, rather than only inherited from a parent
+}
+
+type constraintDef struct {
+	Schema, Table, Name string
+	Type                string // p, u, f, c, x
+	Definition          string
+}
+
+type indexDef struct {
+	Schema, Table, Name string
+	Definition          string
+}
+
+type viewDef struct {
+	Schema, Name string
+	Materialized bool
+	Definition   string
+}
+
+type triggerDef struct {
+	Schema, Table, Name string
+	Definition          string
+}
+
+// objectFilter selects schemas and tables; patterns are shell globs. Table patterns match
+// either "schema.table" or the bare table name.
+type objectFilter struct {
+	Schemas, ExcludeSchemas []string
+	Tables, ExcludeTables   []string
+}
+
+func globMatch(patterns []string, names ...string) bool {
+	for _, p := range patterns {
+		for _, n := range names {
+			if ok, _ := path.Match(p, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *objectFilter) schemaIncluded(schema string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Schemas) > 0 && !globMatch(f.Schemas, schema) {
+		return false
+	}
+	return !globMatch(f.ExcludeSchemas, schema)
+}
+
+func (f *objectFilter) tableIncluded(schema, table string) bool {
+	if !f.schemaIncluded(schema) {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+	if len(f.Tables) > 0 && !globMatch(f.Tables, schema+"."+table, table) {
+		return false
+	}
+	return !globMatch(f.ExcludeTables, schema+"."+table, table)
+}
+
+// qualified returns the quoted schema.name of an object
+func qualified(schema, name string) string {
+	return shared.QuoteIdentIfNeeded(schema) + "." + shared.QuoteIdentIfNeeded(name)
+}
+
+// loadSchemaSnapshot reads the schema of the connected database, keeping what filter selects.
+func loadSchemaSnapshot(ctx context.Context, conn *pgx.Conn, filter *objectFilter) (*schemaSnapshot, *ce.CustomError) {
+	logging.Debugf("Entering function: loadSchemaSnapshot")
+	snap := &schemaSnapshot{}
+
+	if err := conn.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&snap.ServerVersion); err != nil {
+		return nil, &ce.CustomError{Code: 250, Title: "Unable to read server version", Message: err.Error()}
+	}
+
+	loaders := []struct {
+		what string
+		fn   func(context.Context, *pgx.Conn, *objectFilter) error
+	}{
+		{"schemas", snap.loadSchemas},
+		{"extensions", snap.loadExtensions},
+		{"types", snap.loadTypes},
+		{"functions", snap.loadFunctions},
+		{"sequences", snap.loadSequences},
+		{"tables", snap.loadTables},
+		{"constraints", snap.loadConstraints},
+		{"indexes", snap.loadIndexes},
+		{"views", snap.loadViews},
+		{"triggers", snap.loadTriggers},
+	}
+	for _, l := range loaders {
+		if err := l.fn(ctx, conn, filter); err != nil {
+			return nil, &ce.CustomError{Code: 251, Title: "Unable to read " + l.what, Message: err.Error()}
+		}
+	}
+	return snap, nil
+}
+
+func (s *schemaSnapshot) loadSchemas(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname FROM pg_catalog.pg_namespace n
+		WHERE `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "n.oid", "pg_namespace")+`
+		ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if filter.schemaIncluded(n) {
+			s.Schemas = append(s.Schemas, n)
+		}
+	}
+	return nil
+}
+
+func (s *schemaSnapshot) loadExtensions(ctx context.Context, conn *pgx.Conn, _ *objectFilter) error {
+	rows, err := conn.Query(ctx, `
+		SELECT e.extname, n.nspname, e.extversion
+		FROM pg_catalog.pg_extension e JOIN pg_catalog.pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname <> 'plpgsql'
+		ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e extensionDef
+		if err := rows.Scan(&e.Name, &e.Schema, &e.Version); err != nil {
+			return err
+		}
+		s.Extensions = append(s.Extensions, e)
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadTypes(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// enums, then domains, then composite types: the later ones may use the earlier ones
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, t.typname, 'enum',
+			'AS ENUM (' || string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder) || ')',
+			array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+		FROM pg_catalog.pg_type t
+		JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+		JOIN pg_catalog.pg_enum e ON e.enumtypid = t.oid
+		WHERE `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "t.oid", "pg_type")+`
+		GROUP BY n.nspname, t.typname
+		UNION ALL
+		SELECT n.nspname, t.typname, 'domain',
+			'AS ' || pg_catalog.format_type(t.typbasetype, t.typtypmod)
+			|| CASE WHEN t.typcollation <> bt.typcollation
+				THEN ' COLLATE ' || quote_ident(cn.nspname) || '.' || quote_ident(co.collname) ELSE '' END
+			|| coalesce(' DEFAULT ' || t.typdefault, '')
+			|| CASE WHEN t.typnotnull THEN ' NOT NULL' ELSE '' END
+			|| coalesce((SELECT string_agg(' CONSTRAINT ' || quote_ident(c.conname) || ' ' || pg_catalog.pg_get_constraintdef(c.oid), '' ORDER BY c.conname)
+				FROM pg_catalog.pg_constraint c WHERE c.contypid = t.oid AND c.contype = 'c'), ''),
+			NULL::text[]
+		FROM pg_catalog.pg_type t
+		JOIN pg_catalog.pg_type bt ON bt.oid = t.typbasetype
+		JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_catalog.pg_collation co ON co.oid = t.typcollation
+		LEFT JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+		WHERE t.typtype = 'd' AND `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "t.oid", "pg_type")+`
+		UNION ALL
+		SELECT n.nspname, t.typname, 'composite',
+			'AS (' || string_agg(quote_ident(a.attname) || ' ' || pg_catalog.format_type(a.atttypid, a.atttypmod), ', ' ORDER BY a.attnum) || ')',
+			NULL::text[]
+		FROM pg_catalog.pg_type t
+		JOIN pg_catalog.pg_class c ON c.oid = t.typrelid AND c.relkind = 'c'
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+		WHERE `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "t.oid", "pg_type")+`
+		GROUP BY n.nspname, t.typname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t typeDef
+		if err := rows.Scan(&t.Schema, &t.Name, &t.Kind, &t.Definition, &t.Labels); err != nil {
+			return err
+		}
+		if filter.schemaIncluded(t.Schema) {
+			s.Types = append(s.Types, t)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadFunctions(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// pg_get_functiondef() does not support aggregates: their CREATE AGGREGATE is put together from pg_aggregate.
+	// Ordering by oid creates the support functions before their aggregates.
+	create := "CREATE AGGREGATE "
+	if s.ServerVersion >= 120000 {
+		create = "CREATE OR REPLACE AGGREGATE "
+	}
+	proc := func(col string) string {
+		return `(SELECT quote_ident(pn.nspname) || '.' || quote_ident(pp.proname) FROM pg_catalog.pg_proc pp
+			JOIN pg_catalog.pg_namespace pn ON pn.oid = pp.pronamespace WHERE pp.oid = a.` + col + `::oid)`
+	}
+	modify := func(col string) string {
+		return `CASE a.` + col + ` WHEN 'r' THEN 'READ_ONLY' WHEN 's' THEN 'SHAREABLE' ELSE 'READ_WRITE' END`
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT p.oid, n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid),
+			CASE p.prokind WHEN 'p' THEN 'procedure' ELSE 'function' END,
+			pg_catalog.pg_get_functiondef(p.oid)
+		FROM pg_catalog.pg_proc p JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p', 'w') AND `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "p.oid", "pg_proc")+`
+		UNION ALL
+		SELECT p.oid, n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid), 'aggregate',
+			'`+create+`' || quote_ident(n.nspname) || '.' || quote_ident(p.proname)
+			|| '(' || coalesce(nullif(pg_catalog.pg_get_function_identity_arguments(p.oid), ''), '*') || E') (\n    '
+			|| concat_ws(E',\n    ',
+				'SFUNC = ' || `+proc("aggtransfn")+`,
+				'STYPE = ' || pg_catalog.format_type(a.aggtranstype, NULL),
+				'SSPACE = ' || nullif(a.aggtransspace, 0),
+				'FINALFUNC = ' || `+proc("aggfinalfn")+`,
+				CASE WHEN a.aggfinalextra THEN 'FINALFUNC_EXTRA' END,
+				CASE WHEN a.aggfinalfn::oid <> 0 THEN 'FINALFUNC_MODIFY = ' || `+modify("aggfinalmodify")+` END,
+				'COMBINEFUNC = ' || `+proc("aggcombinefn")+`,
+				'SERIALFUNC = ' || `+proc("aggserialfn")+`,
+				'DESERIALFUNC = ' || `+proc("aggdeserialfn")+`,
+				'INITCOND = ' || quote_literal(a.agginitval),
+				'MSFUNC = ' || `+proc("aggmtransfn")+`,
+				'MINVFUNC = ' || `+proc("aggminvtransfn")+`,
+				CASE WHEN a.aggmtransfn::oid <> 0 THEN 'MSTYPE = ' || pg_catalog.format_type(a.aggmtranstype, NULL) END,
+				'MSSPACE = ' || nullif(a.aggmtransspace, 0),
+				'MFINALFUNC = ' || `+proc("aggmfinalfn")+`,
+				CASE WHEN a.aggmfinalextra THEN 'MFINALFUNC_EXTRA' END,
+				CASE WHEN a.aggmfinalfn::oid <> 0 THEN 'MFINALFUNC_MODIFY = ' || `+modify("aggmfinalmodify")+` END,
+				'MINITCOND = ' || quote_literal(a.aggminitval),
+				'SORTOP = ' || (SELECT 'OPERATOR(' || quote_ident(opn.nspname) || '.' || o.oprname || ')'
+					FROM pg_catalog.pg_operator o JOIN pg_catalog.pg_namespace opn ON opn.oid = o.oprnamespace
+					WHERE o.oid = a.aggsortop),
+				CASE WHEN a.aggkind = 'h' THEN 'HYPOTHETICAL' END,
+				CASE p.proparallel WHEN 's' THEN 'PARALLEL = SAFE' WHEN 'r' THEN 'PARALLEL = RESTRICTED' END)
+			|| E'\n)'
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_aggregate a ON a.aggfnoid = p.oid
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.prokind = 'a' AND `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "p.oid", "pg_proc")+`
+		ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var f functionDef
+		if err := rows.Scan(&oid, &f.Schema, &f.Name, &f.Arguments, &f.Kind, &f.Definition); err != nil {
+			return err
+		}
+		if filter.schemaIncluded(f.Schema) {
+			s.Functions = append(s.Functions, f)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadSequences(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	rows, err := conn.Query(ctx, `
+		SELECT s.schemaname, s.sequencename, s.data_type::text, s.start_value, s.min_value, s.max_value,
+			s.increment_by, s.cache_size, s.cycle, s.last_value,
+			coalesce(tn.nspname, ''), coalesce(tc.relname, ''), coalesce(a.attname, ''), coalesce(d.deptype = 'i', false)
+		FROM pg_catalog.pg_sequences s
+		JOIN pg_catalog.pg_namespace n ON n.nspname = s.schemaname
+		JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+		LEFT JOIN pg_catalog.pg_depend d ON d.objid = c.oid AND d.classid = 'pg_class'::regclass
+			AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+		LEFT JOIN pg_catalog.pg_class tc ON tc.oid = d.refobjid
+		LEFT JOIN pg_catalog.pg_namespace tn ON tn.oid = tc.relnamespace
+		LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+		WHERE `+fmt.Sprintf(notExtensionMember, "c.oid", "pg_class")+`
+		ORDER BY 1, 2`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q sequenceDef
+		if err := rows.Scan(&q.Schema, &q.Name, &q.DataType, &q.Start, &q.Min, &q.Max, &q.Increment, &q.Cache, &q.Cycle,
+			&q.LastValue, &q.OwnedSchema, &q.OwnedTable, &q.OwnedCol, &q.Identity); err != nil {
+			return err
+		}
+		if !filter.schemaIncluded(q.Schema) {
+			continue
+		}
+		if q.OwnedTable != "" && !filter.tableIncluded(q.OwnedSchema, q.OwnedTable) {
+			// the sequence goes with its table
+			continue
+		}
+		s.Sequences = append(s.Sequences, q)
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadTables(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// Parents come before their partitions and inheritance children, however deep
+	rows, err := conn.Query(ctx, `
+		SELECT c.oid, n.nspname, c.relname, c.relkind = 'p', c.relpersistence = 'u',
+			coalesce(CASE WHEN c.relkind = 'p' THEN pg_catalog.pg_get_partkeydef(c.oid) END, ''),
+			coalesce((SELECT quote_ident(pn.nspname) || '.' || quote_ident(pc.relname)
+				FROM pg_catalog.pg_inherits i
+				JOIN pg_catalog.pg_class pc ON pc.oid = i.inhparent
+				JOIN pg_catalog.pg_namespace pn ON pn.oid = pc.relnamespace
+				WHERE i.inhrelid = c.oid AND c.relispartition), ''),
+			coalesce(CASE WHEN c.relispartition THEN pg_catalog.pg_get_expr(c.relpartbound, c.oid) END, ''),
+			array(SELECT quote_ident(pn.nspname) || '.' || quote_ident(pc.relname)
+				FROM pg_catalog.pg_inherits i
+				JOIN pg_catalog.pg_class pc ON pc.oid = i.inhparent
+				JOIN pg_catalog.pg_namespace pn ON pn.oid = pc.relnamespace
+				WHERE i.inhrelid = c.oid AND NOT c.relispartition ORDER BY i.inhseqno)
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "c.oid", "pg_class")+`
+		ORDER BY c.relispartition,
+			(WITH RECURSIVE up(oid, depth) AS (
+				SELECT c.oid, 0
+				UNION ALL
+				SELECT i.inhparent, up.depth + 1 FROM pg_catalog.pg_inherits i JOIN up ON i.inhrelid = up.oid)
+			SELECT max(depth) FROM up),
+			c.relkind = 'r', n.nspname, c.relname`)
+	if err != nil {
+		return err
+	}
+	var oids []uint32
+	index := map[uint32]int{}
+	for rows.Next() {
+		var oid uint32
+		var t tableDef
+		if err := rows.Scan(&oid, &t.Schema, &t.Name, &t.Partitioned, &t.Unlogged, &t.PartitionKey, &t.PartitionOf, &t.PartitionSpec, &t.Inherits); err != nil {
+			rows.Close()
+			return err
+		}
+		if !filter.tableIncluded(t.Schema, t.Name) {
+			continue
+		}
+		index[oid] = len(s.Tables)
+		oids = append(oids, oid)
+		s.Tables = append(s.Tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(oids) == 0 {
+		return nil
+	}
+
+	rows, err = conn.Query(ctx, `
+		SELECT a.attrelid, a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			coalesce(pg_catalog.pg_get_expr(ad.adbin, ad.adrelid), ''), a.attidentity::text, a.attgenerated::text,
+			coalesce(CASE WHEN a.attcollation <> t.typcollation THEN quote_ident(cn.nspname) || '.' || quote_ident(co.collname) END, ''),
+			a.attislocal
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+		LEFT JOIN pg_catalog.pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		LEFT JOIN pg_catalog.pg_collation co ON co.oid = a.attcollation
+		LEFT JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+		WHERE a.attrelid = ANY($1) AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attrelid, a.attnum`, oids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var c columnDef
+		if err := rows.Scan(&oid, &c.Name, &c.Type, &c.NotNull, &c.Default, &c.Identity, &c.Generated, &c.Collation, &c.Local); err != nil {
+			return err
+		}
+		t := &s.Tables[index[oid]]
+		t.Columns = append(t.Columns, c)
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadConstraints(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// Constraints inherited by partitions are created through their parent.
+	// Primary keys, unique and exclusion constraints come before the foreign keys that need them.
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, co.conname, co.contype::text, pg_catalog.pg_get_constraintdef(co.oid)
+		FROM pg_catalog.pg_constraint co
+		JOIN pg_catalog.pg_class c ON c.oid = co.conrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE co.contype IN ('p', 'u', 'f', 'c', 'x') AND co.conislocal AND co.conparentid = 0
+			AND c.relkind IN ('r', 'p') AND `+userSchemaFilter+`
+		ORDER BY co.contype = 'f', n.nspname, c.relname, co.conname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tables := map[string]*tableDef{}
+	for i := range s.Tables {
+		tables[s.Tables[i].Schema+"."+s.Tables[i].Name] = &s.Tables[i]
+	}
+	for rows.Next() {
+		var c constraintDef
+		if err := rows.Scan(&c.Schema, &c.Table, &c.Name, &c.Type, &c.Definition); err != nil {
+			return err
+		}
+		t, ok := tables[c.Schema+"."+c.Table]
+		if !ok {
+			continue
+		}
+		if c.Type == "c" {
+			t.Checks = append(t.Checks, c)
+		} else {
+			s.Constraints = append(s.Constraints, c)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadIndexes(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// Indexes backing constraints come with the constraint; index partitions with their parent index
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, ci.relname, pg_catalog.pg_get_indexdef(i.indexrelid)
+		FROM pg_catalog.pg_index i
+		JOIN pg_catalog.pg_class c ON c.oid = i.indrelid
+		JOIN pg_catalog.pg_class ci ON ci.oid = i.indexrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'm') AND NOT ci.relispartition AND `+userSchemaFilter+`
+			AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_constraint co WHERE co.conindid = i.indexrelid AND co.contype IN ('p', 'u', 'x'))
+			AND `+fmt.Sprintf(notExtensionMember, "ci.oid", "pg_class")+`
+		ORDER BY 1, 2, 3`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var x indexDef
+		if err := rows.Scan(&x.Schema, &x.Table, &x.Name, &x.Definition); err != nil {
+			return err
+		}
+		if filter.tableIncluded(x.Schema, x.Table) {
+			s.Indexes = append(s.Indexes, x)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadViews(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, c.relkind = 'm', pg_catalog.pg_get_viewdef(c.oid)
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm') AND `+userSchemaFilter+` AND `+fmt.Sprintf(notExtensionMember, "c.oid", "pg_class")+`
+		ORDER BY c.oid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v viewDef
+		if err := rows.Scan(&v.Schema, &v.Name, &v.Materialized, &v.Definition); err != nil {
+			return err
+		}
+		if filter.tableIncluded(v.Schema, v.Name) {
+			v.Definition = strings.TrimSuffix(strings.TrimSpace(v.Definition), ";")
+			s.Views = append(s.Views, v)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schemaSnapshot) loadTriggers(ctx context.Context, conn *pgx.Conn, filter *objectFilter) error {
+	// Triggers cloned onto partitions (PG 13+) come with the parent's
+	q := `
+		SELECT n.nspname, c.relname, t.tgname, pg_catalog.pg_get_triggerdef(t.oid)
+		FROM pg_catalog.pg_trigger t
+		JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT t.tgisinternal AND ` + userSchemaFilter
+	if s.ServerVersion >= 130000 {
+		q += ` AND t.tgparentid = 0`
+	}
+	rows, err := conn.Query(ctx, q+` ORDER BY 1, 2, 3`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t triggerDef
+		if err := rows.Scan(&t.Schema, &t.Table, &t.Name, &t.Definition); err != nil {
+			return err
+		}
+		if filter.tableIncluded(t.Schema, t.Table) {
+			s.Triggers = append(s.Triggers, t)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,312 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/17 09:12
+// Original filename: src/db/sqlSplitter.go
+
+package db
+
+import (
+	"bufio"
+	"io"
+	"pgtools/shared"
+	"strings"
+)
+
+// SQLItem is one unit returned by SQLSplitter: either a complete SQL statement
+// (terminating semicolon included) or a psql backslash meta-command line.
+type SQLItem struct {
+	Meta bool
+	Text string
+	Line int
+}
+
+// SQLSplitter reads a SQL script and returns one statement at a time.
+// Semicolons inside quoted literals, quoted identifiers, dollar-quoted bodies
+// and comments do not end a statement.
+type SQLSplitter struct {
+	r           *bufio.Reader
+	line        int
+	atLineStart bool
+}
+
+// NewSQLSplitter wraps r in a statement splitter.
+func NewSQLSplitter(r io.Reader) *SQLSplitter {
+	return &SQLSplitter{r: bufio.NewReaderSize(r, 256*1024), line: 1, atLineStart: true}
+}
+
+// Line returns the current line number in the input.
+func (s *SQLSplitter) Line() int {
+	return s.line
+}
+
+func (s *SQLSplitter) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if c == '\n' {
+		s.line++
+	}
+	s.atLineStart = c == '\n'
+	return c, nil
+}
+
+func (s *SQLSplitter) peekByte() byte {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+// readRestOfLine returns everything up to (not including) the next newline, which is consumed.
+func (s *SQLSplitter) readRestOfLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if strings.HasSuffix(line, "\n") {
+		s.line++
+		s.atLineStart = true
+	}
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Next returns the next statement or meta-command; io.EOF is returned once the input is exhausted.
+// A trailing statement without a semicolon is returned as-is.
+func (s *SQLSplitter) Next() (*SQLItem, error) {
+	var sb strings.Builder
+	started := false
+	startLine := s.line
+
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			if text := strings.TrimSpace(sb.String()); text != "" {
+				return &SQLItem{Text: text, Line: startLine}, nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !started {
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				continue
+			}
+			startLine = s.line
+			// Meta-commands are only recognized at the start of a statement, which is how pg_dump emits them.
+			if c == '\\' {
+				rest, rerr := s.readRestOfLine()
+				if rerr != nil {
+					return nil, rerr
+				}
+				return &SQLItem{Meta: true, Text: strings.TrimSpace(`\` + rest), Line: startLine}, nil
+			}
+		}
+
+		switch {
+		case c == '-' && s.peekByte() == '-':
+			// Line comment: dropped entirely; it still separates tokens.
+			if _, rerr := s.readRestOfLine(); rerr != nil {
+				return nil, rerr
+			}
+			if started {
+				sb.WriteByte('\n')
+			}
+			continue
+
+		case c == '/' && s.peekByte() == '*':
+			if err := s.skipBlockComment(); err != nil {
+				return nil, err
+			}
+			if started {
+				sb.WriteByte(' ')
+			}
+			continue
+		}
+
+		started = true
+		sb.WriteByte(c)
+
+		switch c {
+		case ';':
+			return &SQLItem{Text: strings.TrimSpace(sb.String()), Line: startLine}, nil
+		case '\'':
+			if err := s.copyQuoted(&sb, '\'', isEscapeString(sb.String())); err != nil {
+				return nil, err
+			}
+		case '"':
+			if err := s.copyQuoted(&sb, '"', false); err != nil {
+				return nil, err
+			}
+		case '$':
+			if err := s.copyDollarQuoted(&sb); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// isEscapeString reports whether the quote just written to buf opens an E'...' literal.
+func isEscapeString(buf string) bool {
+	n := len(buf)
+	if n < 2 || (buf[n-2] != 'E' && buf[n-2] != 'e') {
+		return false
+	}
+	return n == 2 || !shared.IsIdentByte(buf[n-3])
+}
+
+// copyQuoted copies a quoted literal or identifier up to its closing quote; doubled quotes are escapes.
+func (s *SQLSplitter) copyQuoted(sb *strings.Builder, quote byte, backslashEscapes bool) error {
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		sb.WriteByte(c)
+		if backslashEscapes && c == '\\' {
+			n, err := s.readByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			sb.WriteByte(n)
+			continue
+		}
+		if c == quote {
+			if s.peekByte() == quote {
+				n, _ := s.readByte()
+				sb.WriteByte(n)
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+// copyDollarQuoted handles $tag$ ... $tag$ bodies. The opening '$' is already written.
+// A '$' that does not open a valid tag (e.g. positional parameters like $1) is left alone.
+func (s *SQLSplitter) copyDollarQuoted(sb *strings.Builder) error {
+	prev := sb.String()
+	if len(prev) >= 2 && shared.IsIdentByte(prev[len(prev)-2]) {
+		return nil
+	}
+	tag := ""
+	for i := 1; ; i++ {
+		b, err := s.r.Peek(i)
+		if err != nil || len(b) < i {
+			return nil
+		}
+		c := b[i-1]
+		if c == '$' {
+			tag = string(b[:i-1])
+			break
+		}
+		if !shared.IsIdentByte(c) || (i == 1 && c >= '0' && c <= '9') {
+			return nil
+		}
+	}
+	for i := 0; i <= len(tag); i++ {
+		c, _ := s.readByte()
+		sb.WriteByte(c)
+	}
+
+	closing := "$" + tag + "$"
+	var body strings.Builder
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		sb.WriteByte(c)
+		body.WriteByte(c)
+		if c == '$' && strings.HasSuffix(body.String(), closing) {
+			return nil
+		}
+	}
+}
+
+// skipBlockComment consumes a (possibly nested) /* ... */ comment. The leading '/' is already consumed.
+func (s *SQLSplitter) skipBlockComment() error {
+	depth := 0
+	var prev byte = '/'
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch {
+		case prev == '/' && c == '*':
+			depth++
+			c = 0
+		case prev == '*' && c == '/':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+			c = 0
+		}
+		prev = c
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// IsCopyFromStdin reports whether a statement is a COPY ... FROM stdin, whose data follows inline (see CopyData).
+func IsCopyFromStdin(text string) bool {
+	return copyFromStdinRx.MatchString(text)
+}
+
+// CopyData returns a reader over the inline data block that follows a COPY ... FROM stdin
+// statement. The block ends with a line holding only "\."; that line is consumed but not returned.
+func (s *SQLSplitter) CopyData() io.Reader {
+	if !s.atLineStart {
+		// Skip whatever trails the COPY statement on its line
+		_, _ = s.readRestOfLine()
+	}
+	return &copyDataReader{s: s}
+}
+
+type copyDataReader struct {
+	s    *SQLSplitter
+	buf  []byte
+	done bool
+}
+
+func (c *copyDataReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.s.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if len(line) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if line[len(line)-1] == '\n' {
+			c.s.line++
+		} else {
+			line = append(line, '\n')
+		}
+		if strings.TrimRight(string(line), "\r\n") == `\.` {
+			c.done = true
+			c.s.atLineStart = true
+			return 0, io.EOF
+		}
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c.buf = line
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,289 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/19 11:25
+// Original filename: src/db/tableCopy.go
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"pgtools/logging"
+	"pgtools/shared"
+	"pgtools/types"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+var errTargetAborted = errors.New("target COPY aborted")
+
+// copyStream pipes COPY ... TO STDOUT on src straight into COPY ... FROM STDIN on dst; nothing touches disk.
+// It returns the number of rows loaded.
+func copyStream(ctx context.Context, src, dst *pgconn.PgConn, copyOut, copyIn string) (int64, error) {
+	pr, pw := io.Pipe()
+	srcErr := make(chan error, 1)
+
+	go func() {
+		_, err := src.CopyTo(ctx, pw, copyOut)
+		pw.CloseWithError(err)
+		srcErr <- err
+	}()
+
+	tag, err := dst.CopyFrom(ctx, pr, copyIn)
+	// unblocks the writer if the target gave up first
+	pr.CloseWithError(errTargetAborted)
+	if serr := <-srcErr; serr != nil && !errors.Is(serr, errTargetAborted) {
+		return 0, fmt.Errorf("source: %w", serr)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("target: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// TableSpec is a table reference of the form [ENV:]db.schema.table, or [ENV:]db.table for the public schema.
+// With --query, the source may be just [ENV:]db.
+type TableSpec struct {
+	Env, DB, Schema, Table string
+}
+
+func (t TableSpec) String() string {
+	s := t.DB
+	if t.Table != "" {
+		s += "." + t.Schema + "." + t.Table
+	}
+	if t.Env != "" {
+		s = t.Env + ":" + s
+	}
+	return s
+}
+
+// ParseTableSpec parses [ENV:]db[.schema].table; the table part is optional only when tableOptional is set.
+func ParseTableSpec(spec string, tableOptional bool) (TableSpec, *ce.CustomError) {
+	var t TableSpec
+	rest := spec
+	if env, r, ok := strings.Cut(spec, ":"); ok {
+		t.Env, rest = env, r
+	}
+	parts := strings.Split(rest, ".")
+	switch {
+	case len(parts) == 3:
+		t.DB, t.Schema, t.Table = parts[0], parts[1], parts[2]
+	case len(parts) == 2:
+		t.DB, t.Schema, t.Table = parts[0], "public", parts[1]
+	case len(parts) == 1 && tableOptional:
+		t.DB = parts[0]
+	default:
+		return t, &ce.CustomError{Code: 270, Title: "Invalid table specification", Message: fmt.Sprintf("%q: expected [ENV:]db.schema.table", spec)}
+	}
+	for _, p := range parts {
+		if p == "" {
+			return t, &ce.CustomError{Code: 270, Title: "Invalid table specification", Message: fmt.Sprintf("%q: empty name", spec)}
+		}
+	}
+	return t, nil
+}
+
+// CopyTable streams rows from the src table (or the --query result) into the dst table.
+func CopyTable(srcCfg, dstCfg *types.DBConfig, src, dst TableSpec) *ce.CustomError {
+	if src.Table == "" && types.CopyQuery == "" {
+		return &ce.CustomError{Code: 270, Title: "Invalid table specification", Message: "the source needs a table, or --query"}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+
+	srcConn, cerr := Connect(srcCfg, src.DB)
+	if cerr != nil {
+		return cerr
+	}
+	defer safeClose(srcConn)
+	dstConn, cerr := Connect(dstCfg, dst.DB)
+	if cerr != nil {
+		return cerr
+	}
+	defer safeClose(dstConn)
+
+	// What to read
+	selectSQL := types.CopyQuery
+	if selectSQL == "" {
+		cols, err := copyableColumns(ctx, srcConn, src.Schema, src.Table)
+		if err != nil {
+			return &ce.CustomError{Code: 271, Title: "Unable to read source columns", Message: err.Error()}
+		}
+		if len(cols) == 0 {
+			return &ce.CustomError{Code: 271, Title: "Unable to read source columns", Message: fmt.Sprintf("%s not found or has no columns", src)}
+		}
+		selectSQL = fmt.Sprintf("SELECT %s FROM %s", strings.Join(shared.QuoteIdents(cols), ", "), qualified(src.Schema, src.Table))
+		if types.CopyWhere != "" {
+			selectSQL += " WHERE " + types.CopyWhere
+		}
+	}
+	selectSQL = strings.TrimSuffix(strings.TrimSpace(selectSQL), ";")
+	columns, err := resultColumns(ctx, srcConn, selectSQL)
+	if err != nil {
+		return &ce.CustomError{Code: 271, Title: "Invalid source query", Message: err.Error()}
+	}
+
+	var pk []string
+	if types.CopyUpsertOn != "" {
+		for _, c := range strings.Split(types.CopyUpsertOn, ",") {
+			pk = append(pk, strings.TrimSpace(c))
+		}
+	}
+
+	// Where to write: everything happens in one transaction on the target
+	target := qualified(dst.Schema, dst.Table)
+	tx, err := dstConn.Begin(ctx)
+	if err != nil {
+		return &ce.CustomError{Code: 272, Title: "Unable to open target transaction", Message: err.Error()}
+	}
+	// a no-op once committed
+	defer tx.Rollback(context.Background())
+
+	var exists bool
+	if err := dstConn.QueryRow(ctx, "SELECT pg_catalog.to_regclass($1) IS NOT NULL", target).Scan(&exists); err != nil {
+		return &ce.CustomError{Code: 272, Title: "Unable to look up the target table", Message: err.Error()}
+	}
+	if !exists {
+		if !types.CopyCreateTarget {
+			return &ce.CustomError{Code: 272, Title: "Target table not found", Message: fmt.Sprintf("%s does not exist; use --create-target", dst)}
+		}
+		if err := createTargetTable(ctx, dstConn, target, columns, pk); err != nil {
+			return &ce.CustomError{Code: 272, Title: "Unable to create the target table", Message: err.Error()}
+		}
+	}
+	if types.CopyTruncate {
+		if _, err := dstConn.Exec(ctx, "TRUNCATE TABLE "+target); err != nil {
+			return &ce.CustomError{Code: 272, Title: "Unable to truncate the target table", Message: err.Error()}
+		}
+	}
+
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	colList := strings.Join(shared.QuoteIdents(names), ", ")
+
+	// Upserts go through a staging table, then INSERT ... ON CONFLICT
+	loadInto := target
+	if len(pk) > 0 {
+		loadInto = "pgtools_copy_stage"
+		stage := fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", loadInto, colList, target)
+		if _, err := dstConn.Exec(ctx, stage); err != nil {
+			return &ce.CustomError{Code: 272, Title: "Unable to create the staging table", Message: err.Error()}
+		}
+	}
+
+	n, err := copyStream(ctx, srcConn.PgConn(), dstConn.PgConn(),
+		fmt.Sprintf("COPY (%s) TO STDOUT", selectSQL),
+		fmt.Sprintf("COPY %s (%s) FROM STDIN", loadInto, colList))
+	if err != nil {
+		return &ce.CustomError{Code: 273, Title: "Copy failed", Message: err.Error()}
+	}
+
+	if len(pk) > 0 {
+		if n, err = upsertFromStage(ctx, dstConn, target, loadInto, names, pk); err != nil {
+			return &ce.CustomError{Code: 274, Title: "Upsert failed", Message: err.Error()}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return &ce.CustomError{Code: 272, Title: "Unable to commit", Message: err.Error()}
+	}
+	fmt.Printf("Copied %d row(s) from %s to %s in %s\n", n, srcLabel(src), dst, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func srcLabel(src TableSpec) string {
+	if types.CopyQuery != "" {
+		return src.String() + " (query)"
+	}
+	return src.String()
+}
+
+// copyableColumns lists the columns of a table that hold stored values (generated columns are left out)
+func copyableColumns(ctx context.Context, conn *pgx.Conn, schema, table string) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT a.attname
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		ORDER BY a.attnum`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// resultColumns returns the names and SQL types of the columns a query returns, without running it
+func resultColumns(ctx context.Context, conn *pgx.Conn, selectSQL string) ([]columnDef, error) {
+	rows, err := conn.Query(ctx, "SELECT * FROM ("+selectSQL+") q LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	fields := rows.FieldDescriptions()
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	columns := make([]columnDef, len(fields))
+	for i, fd := range fields {
+		columns[i].Name = fd.Name
+		if err := conn.QueryRow(ctx, "SELECT pg_catalog.format_type($1, $2)", fd.DataTypeOID, fd.TypeModifier).Scan(&columns[i].Type); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+func createTargetTable(ctx context.Context, conn *pgx.Conn, target string, columns []columnDef, pk []string) error {
+	var elems []string
+	for _, c := range columns {
+		elems = append(elems, c.definition())
+	}
+	if len(pk) > 0 {
+		elems = append(elems, "PRIMARY KEY ("+strings.Join(shared.QuoteIdents(pk), ", ")+")")
+	}
+	stmt := fmt.Sprintf("CREATE TABLE %s (\n    %s\n)", target, strings.Join(elems, ",\n    "))
+	logging.Infof("Creating target table:\n%s", stmt)
+	_, err := conn.Exec(ctx, stmt)
+	return err
+}
+
+// upsertFromStage merges the staged rows into target, updating the non-key columns of existing rows
+func upsertFromStage(ctx context.Context, conn *pgx.Conn, target, stage string, columns, pk []string) (int64, error) {
+	isKey := map[string]bool{}
+	for _, k := range pk {
+		isKey[k] = true
+	}
+	var sets []string
+	for _, c := range columns {
+		if !isKey[c] {
+			q := shared.QuoteIdent(c)
+			sets = append(sets, q+" = EXCLUDED."+q)
+		}
+	}
+	action := "DO NOTHING"
+	if len(sets) > 0 {
+		action = "DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	colList := strings.Join(shared.QuoteIdents(columns), ", ")
+	tag, err := conn.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) %s",
+		target, colList, colList, stage, strings.Join(shared.QuoteIdents(pk), ", "), action))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
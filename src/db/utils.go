@@ -35,9 +35,11 @@ func Connect(cfg *types.DBConfig, database string) (*pgx.Conn, *ce.CustomError)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	dsn := shared.BuildDSN(cfg, database)
-
-	conn, err := pgx.Connect(ctx, dsn)
+	cc, cerr := shared.ConnConfig(cfg, database)
+	if cerr != nil {
+		return nil, cerr
+	}
+	conn, err := pgx.ConnectConfig(ctx, cc)
 	if err != nil {
 		return nil, &ce.CustomError{Title: "Connection failure", Message: err.Error()}
 	}
@@ -0,0 +1,206 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/18 15:10
+// Original filename: src/db/validate.go
+//
+// db validate / db restore --validate: compare restored databases with the manifest recorded at backup time.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pgtools/types"
+	"sort"
+	"strconv"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// validationDiff is one mismatch between the manifest and the restored database
+type validationDiff struct {
+	Database string `json:"database"`
+	Check    string `json:"check"`
+	Object   string `json:"object"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// validationResult sums up a validation run
+type validationResult struct {
+	Databases int              `json:"databases"`
+	Tables    int              `json:"tables"`
+	Sequences int              `json:"sequences"`
+	Diffs     []validationDiff `json:"differences"`
+}
+
+// ValidateArchive checks the databases restored from arcname against the archive's manifest.
+func ValidateArchive(cfg *types.DBConfig, arcname string) *ce.CustomError {
+	manifest, cerr := readManifest(arcname)
+	if cerr != nil {
+		return cerr
+	}
+	result, cerr := validateManifest(cfg, manifest, types.RestoreTarget)
+	if cerr != nil {
+		return cerr
+	}
+
+	printValidation(arcname, result)
+	if len(result.Diffs) > 0 {
+		return &ce.CustomError{Code: 234, Title: "Validation failed", Message: fmt.Sprintf("%d difference(s) found", len(result.Diffs))}
+	}
+	return nil
+}
+
+// validateManifest compares every database of the manifest with its restored counterpart.
+// With a non-empty target, the (single) database of the manifest is looked for under that name.
+func validateManifest(cfg *types.DBConfig, manifest *types.BackupManifest, target string) (*validationResult, *ce.CustomError) {
+	if target != "" && len(manifest.Databases) > 1 {
+		return nil, &ce.CustomError{Code: 233, Title: "Ambiguous target",
+			Message: fmt.Sprintf("the manifest holds %d databases; --target only applies to single-database archives", len(manifest.Databases))}
+	}
+
+	result := &validationResult{}
+	for _, expected := range manifest.Databases {
+		dbname := expected.Name
+		if target != "" {
+			dbname = target
+		}
+
+		hashes := false
+		for _, t := range expected.Tables {
+			if t.Hash != "" {
+				hashes = true
+				break
+			}
+		}
+
+		conn, cerr := Connect(cfg, dbname)
+		if cerr != nil {
+			return nil, cerr
+		}
+		actual, cerr := collectDatabaseManifest(conn, dbname, hashes)
+		conn.Close(context.Background())
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		result.Databases++
+		result.Tables += len(expected.Tables)
+		result.Sequences += len(expected.Sequences)
+		result.Diffs = append(result.Diffs, compareManifests(dbname, &expected, actual)...)
+	}
+	return result, nil
+}
+
+func compareManifests(dbname string, expected, actual *types.DatabaseManifest) []validationDiff {
+	var diffs []validationDiff
+	add := func(check, object, exp, act string) {
+		diffs = append(diffs, validationDiff{Database: dbname, Check: check, Object: object, Expected: exp, Actual: act})
+	}
+
+	// Tables: presence, row counts, content hashes
+	actualTables := make(map[string]types.TableManifest, len(actual.Tables))
+	for _, t := range actual.Tables {
+		actualTables[t.Schema+"."+t.Name] = t
+	}
+	for _, exp := range expected.Tables {
+		name := exp.Schema + "." + exp.Name
+		act, ok := actualTables[name]
+		if !ok {
+			add("table", name, "present", "missing")
+			continue
+		}
+		delete(actualTables, name)
+		if exp.Rows != act.Rows {
+			add("rows", name, strconv.FormatInt(exp.Rows, 10), strconv.FormatInt(act.Rows, 10))
+		}
+		if exp.Hash != "" && exp.Hash != act.Hash {
+			add("hash", name, exp.Hash, act.Hash)
+		}
+	}
+	for _, name := range sortedKeys(actualTables) {
+		add("table", name, "absent", "present")
+	}
+
+	// Sequences
+	actualSeqs := make(map[string]types.SequenceManifest, len(actual.Sequences))
+	for _, s := range actual.Sequences {
+		actualSeqs[s.Schema+"."+s.Name] = s
+	}
+	for _, exp := range expected.Sequences {
+		name := exp.Schema + "." + exp.Name
+		act, ok := actualSeqs[name]
+		if !ok {
+			add("sequence", name, "present", "missing")
+			continue
+		}
+		delete(actualSeqs, name)
+		if e, a := sequenceValue(exp.LastValue), sequenceValue(act.LastValue); e != a {
+			add("sequence", name, e, a)
+		}
+	}
+	for _, name := range sortedKeys(actualSeqs) {
+		add("sequence", name, "absent", "present")
+	}
+
+	// Object counts by type, only when the archive creates the schema: otherwise the objects of the
+	// restored database are those of the schema it was restored into
+	if !expected.Schema {
+		return diffs
+	}
+	kinds := map[string]bool{}
+	for k := range expected.Objects {
+		kinds[k] = true
+	}
+	for k := range actual.Objects {
+		kinds[k] = true
+	}
+	for _, kind := range sortedKeys(kinds) {
+		if expected.Objects[kind] != actual.Objects[kind] {
+			add("objects", kind, strconv.FormatInt(expected.Objects[kind], 10), strconv.FormatInt(actual.Objects[kind], 10))
+		}
+	}
+	return diffs
+}
+
+func sequenceValue(v *int64) string {
+	if v == nil {
+		return "not called"
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printValidation(arcname string, result *validationResult) {
+	fmt.Printf("Validation of %s: %d database(s), %d table(s), %d sequence(s)\n",
+		arcname, result.Databases, result.Tables, result.Sequences)
+
+	if len(result.Diffs) == 0 {
+		fmt.Println(hf.Green("Everything matches the backup manifest"))
+		return
+	}
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"Database", "Check", "Object", "Expected", "Actual"})
+	for _, d := range result.Diffs {
+		tw.AppendRow(table.Row{d.Database, d.Check, d.Object, hf.Green(d.Expected), hf.Red(d.Actual)})
+	}
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Render()
+	fmt.Println(hf.Red(fmt.Sprintf("%d difference(s) found", len(result.Diffs))))
+}
@@ -0,0 +1,519 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/26 10:05
+// Original filename: src/diag/envTest.go
+
+package diag
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"pgtools/db"
+	"pgtools/environment"
+	"pgtools/logging"
+	"pgtools/tunnel"
+	"pgtools/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const (
+	probeTimeout   = 5 * time.Second
+	latencySamples = 5
+)
+
+// Report is the outcome of the checks run against one environment
+type Report struct {
+	Env     string        `json:"env"`
+	Host    string        `json:"host"`
+	Port    int           `json:"port"`
+	SSLMode string        `json:"sslmode"`
+	SSH     *SSHResult    `json:"ssh,omitempty"`
+	DNS     *DNSResult    `json:"dns,omitempty"`
+	TCP     *TCPResult    `json:"tcp,omitempty"`
+	TLS     *TLSResult    `json:"tls,omitempty"`
+	Auth    *AuthResult   `json:"auth,omitempty"`
+	Server  *ServerResult `json:"server,omitempty"`
+	OK      bool          `json:"ok"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type SSHResult struct {
+	Chain string  `json:"chain"`
+	Ms    float64 `json:"ms"`
+	Error string  `json:"error,omitempty"`
+}
+
+type DNSResult struct {
+	Addresses []string `json:"addresses,omitempty"`
+	Ms        float64  `json:"ms"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type TCPResult struct {
+	Address string  `json:"address,omitempty"`
+	Ms      float64 `json:"ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type TLSResult struct {
+	Negotiated    bool       `json:"negotiated"`
+	Version       string     `json:"version,omitempty"`
+	Cipher        string     `json:"cipher,omitempty"`
+	Chain         []CertInfo `json:"chain,omitempty"`
+	Verified      bool       `json:"verified"`
+	VerifyError   string     `json:"verify_error,omitempty"`
+	HostnameOK    bool       `json:"hostname_ok"`
+	HostnameError string     `json:"hostname_error,omitempty"`
+	ClientCert    *CertInfo  `json:"client_cert,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+type CertInfo struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+func (c CertInfo) String() string {
+	return fmt.Sprintf("%s, issued by %s, expires %s (%d days)", c.Subject, c.Issuer, c.NotAfter.Format("2006-01-02"), c.DaysLeft)
+}
+
+type AuthResult struct {
+	Method string `json:"method,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ServerResult struct {
+	Version   string  `json:"version,omitempty"`
+	ConnectMs float64 `json:"connect_ms"`
+	MinMs     float64 `json:"latency_min_ms"`
+	AvgMs     float64 `json:"latency_avg_ms"`
+	MaxMs     float64 `json:"latency_max_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// TestEnvironments checks the environments one after the other, and prints the reports as tables or as JSON;
+// the current environment (-e) is tested when no name is given
+func TestEnvironments(names []string, format string) *ce.CustomError {
+	if format != "table" && format != "json" {
+		return &ce.CustomError{Code: 30, Title: "Invalid format", Message: fmt.Sprintf("%q: expected table or json", format)}
+	}
+	var reports []*Report
+	failed := 0
+	for _, name := range names {
+		r := testEnvironment(name)
+		if !r.OK {
+			failed++
+		}
+		reports = append(reports, r)
+	}
+
+	if format == "json" {
+		data, _ := json.MarshalIndent(reports, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, r := range reports {
+			r.render()
+		}
+	}
+	if failed > 0 {
+		return &ce.CustomError{Code: 31, Title: "Connectivity test failed", Message: fmt.Sprintf("%d of %d environment(s) failed", failed, len(reports))}
+	}
+	return nil
+}
+
+func testEnvironment(name string) *Report {
+	name = strings.TrimSuffix(name, ".json") + ".json"
+	r := &Report{Env: name}
+	var cfg *types.DBConfig
+	var err *ce.CustomError
+	if name == types.EnvConfigFile {
+		cfg, err = environment.LoadConfig()
+	} else {
+		cfg, err = environment.LoadEnvironment(name)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Host, r.Port, r.SSLMode = cfg.Host, cfg.Port, cfg.SSLMode
+	if r.SSLMode == "" {
+		r.SSLMode = "prefer"
+	}
+	logging.Debugf("Testing %s (%s:%d)", name, cfg.Host, cfg.Port)
+
+	conn := r.dial(cfg)
+	if conn == nil {
+		return r
+	}
+	r.probe(conn, cfg)
+	r.connect(cfg)
+	r.OK = r.Server != nil && r.Server.Error == ""
+	return r
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// dial resolves the host and opens a TCP connection to the first address that answers; through an SSH tunnel, the
+// last SSH host resolves and connects
+func (r *Report) dial(cfg *types.DBConfig) net.Conn {
+	if cfg.SSH != nil {
+		r.SSH = &SSHResult{Chain: tunnel.Describe(cfg.SSH)}
+		start := time.Now()
+		dial, err := tunnel.Dialer(cfg.SSH)
+		r.SSH.Ms = ms(time.Since(start))
+		if err != nil {
+			r.SSH.Error = err.Message
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		r.TCP = &TCPResult{Address: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))}
+		start = time.Now()
+		conn, e := dial(ctx, "tcp", r.TCP.Address)
+		r.TCP.Ms = ms(time.Since(start))
+		if e != nil {
+			r.TCP.Error = e.Error()
+		}
+		return conn
+	}
+	if strings.HasPrefix(cfg.Host, "/") {
+		socket := fmt.Sprintf("%s/.s.PGSQL.%d", cfg.Host, cfg.Port)
+		start := time.Now()
+		conn, err := net.DialTimeout("unix", socket, probeTimeout)
+		r.TCP = &TCPResult{Address: socket, Ms: ms(time.Since(start))}
+		if err != nil {
+			r.TCP.Error = err.Error()
+		}
+		return conn
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, cfg.Host)
+	r.DNS = &DNSResult{Addresses: addrs, Ms: ms(time.Since(start))}
+	if err != nil {
+		r.DNS.Error = err.Error()
+		return nil
+	}
+
+	r.TCP = &TCPResult{}
+	for _, addr := range addrs {
+		r.TCP.Address = net.JoinHostPort(addr, strconv.Itoa(cfg.Port))
+		start = time.Now()
+		conn, err := net.DialTimeout("tcp", r.TCP.Address, probeTimeout)
+		r.TCP.Ms = ms(time.Since(start))
+		if err == nil {
+			r.TCP.Error = ""
+			return conn
+		}
+		r.TCP.Error = err.Error()
+	}
+	return nil
+}
+
+// probe negotiates TLS as the server allows it, then reads the authentication request the server answers a
+// startup message with; the connection is closed before authenticating
+func (r *Report) probe(conn net.Conn, cfg *types.DBConfig) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	if conn.RemoteAddr().Network() != "unix" && r.SSLMode != "disable" {
+		r.TLS = &TLSResult{}
+		tlsConn, err := r.TLS.negotiate(conn, cfg)
+		if err != nil {
+			r.TLS.Error = err.Error()
+			return
+		}
+		if tlsConn != nil {
+			defer tlsConn.Close()
+			conn = tlsConn
+		} else if r.SSLMode == "require" || strings.HasPrefix(r.SSLMode, "verify") {
+			// the server refuses TLS, which the sslmode requires
+			return
+		}
+	}
+
+	r.Auth = &AuthResult{}
+	dbname := cfg.DefaultDB
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	fe := pgproto3.NewFrontend(conn, conn)
+	fe.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": cfg.User, "database": dbname, "application_name": types.AppNameKV},
+	})
+	if err := fe.Flush(); err != nil {
+		r.Auth.Error = err.Error()
+		return
+	}
+	msg, err := fe.Receive()
+	if err != nil {
+		r.Auth.Error = err.Error()
+		return
+	}
+	switch m := msg.(type) {
+	case *pgproto3.AuthenticationOk:
+		r.Auth.Method = "trust"
+		if r.TLS != nil && r.TLS.Negotiated && cfg.SSLclientCert != "" {
+			r.Auth.Method = "trust or cert"
+		}
+	case *pgproto3.AuthenticationCleartextPassword:
+		r.Auth.Method = "password (cleartext)"
+	case *pgproto3.AuthenticationMD5Password:
+		r.Auth.Method = "md5"
+	case *pgproto3.AuthenticationSASL:
+		r.Auth.Method = "SASL " + strings.Join(m.AuthMechanisms, ", ")
+	case *pgproto3.AuthenticationGSS:
+		r.Auth.Method = "GSSAPI"
+	case *pgproto3.ErrorResponse:
+		r.Auth.Error = fmt.Sprintf("%s (SQLSTATE %s)", m.Message, m.Code)
+	default:
+		r.Auth.Method = fmt.Sprintf("%T", m)
+	}
+	fe.Send(&pgproto3.Terminate{})
+	fe.Flush()
+}
+
+// negotiate sends the SSLRequest; it returns a nil connection when the server declines TLS. The certificates are
+// checked apart from the handshake, so that an invalid chain is described rather than only refused.
+func (t *TLSResult) negotiate(conn net.Conn, cfg *types.DBConfig) (*tls.Conn, error) {
+	request, _ := (&pgproto3.SSLRequest{}).Encode(nil)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, 1)
+	if _, err := conn.Read(answer); err != nil {
+		return nil, err
+	}
+	if answer[0] != 'S' {
+		t.Error = "the server does not accept TLS connections"
+		return nil, nil
+	}
+
+	tlsConfig, err := clientTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(tlsConfig.Certificates) > 0 {
+		leaf := tlsConfig.Certificates[0].Leaf
+		t.ClientCert = &CertInfo{Subject: leaf.Subject.String(), Issuer: leaf.Issuer.String(), NotAfter: leaf.NotAfter,
+			DaysLeft: int(time.Until(leaf.NotAfter).Hours() / 24)}
+	}
+	roots := tlsConfig.RootCAs
+	tlsConfig.InsecureSkipVerify = true
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	t.Negotiated = true
+	t.Version = tls.VersionName(state.Version)
+	t.Cipher = tls.CipherSuiteName(state.CipherSuite)
+	for _, cert := range state.PeerCertificates {
+		t.Chain = append(t.Chain, CertInfo{Subject: cert.Subject.String(), Issuer: cert.Issuer.String(),
+			NotAfter: cert.NotAfter, DaysLeft: int(time.Until(cert.NotAfter).Hours() / 24)})
+	}
+	if len(state.PeerCertificates) == 0 {
+		t.VerifyError = "no certificate"
+		return tlsConn, nil
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.VerifyError = err.Error()
+	} else {
+		t.Verified = true
+	}
+	if err := leaf.VerifyHostname(cfg.Host); err != nil {
+		t.HostnameError = err.Error()
+	} else {
+		t.HostnameOK = true
+	}
+	return tlsConn, nil
+}
+
+// clientTLSConfig trusts the sslrootcert, else the system roots, and presents the client certificate if any
+func clientTLSConfig(cfg *types.DBConfig) (*tls.Config, error) {
+	c := &tls.Config{ServerName: cfg.Host}
+	cert, err := environment.ClientCertificate(cfg)
+	if err != nil {
+		return nil, errors.New(err.Title + ": " + err.Message)
+	}
+	if cert != nil {
+		c.Certificates = []tls.Certificate{*cert}
+	}
+	if cfg.SSLRootCert != "" {
+		pem, err := os.ReadFile(cfg.SSLRootCert)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(cfg.SSLRootCert + " holds no PEM certificate")
+		}
+	}
+	return c, nil
+}
+
+// connect opens a real connection, as every other command does, and measures the round trips
+func (r *Report) connect(cfg *types.DBConfig) {
+	r.Server = &ServerResult{}
+	dbname := cfg.DefaultDB
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	start := time.Now()
+	conn, err := db.Connect(cfg, dbname)
+	r.Server.ConnectMs = ms(time.Since(start))
+	if err != nil {
+		r.Server.Error = err.Message
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	defer conn.Close(context.Background())
+
+	if e := conn.QueryRow(ctx, "SHOW server_version").Scan(&r.Server.Version); e != nil {
+		r.Server.Error = e.Error()
+		return
+	}
+	var total time.Duration
+	for i := 0; i < latencySamples; i++ {
+		start := time.Now()
+		if e := conn.Ping(ctx); e != nil {
+			r.Server.Error = e.Error()
+			return
+		}
+		d := time.Since(start)
+		total += d
+		if i == 0 || ms(d) < r.Server.MinMs {
+			r.Server.MinMs = ms(d)
+		}
+		r.Server.MaxMs = max(r.Server.MaxMs, ms(d))
+	}
+	r.Server.AvgMs = ms(total / latencySamples)
+}
+
+func (r *Report) render() {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetTitle(fmt.Sprintf("%s (%s:%d, sslmode %s)", r.Env, r.Host, r.Port, r.SSLMode))
+	t.AppendHeader(table.Row{"Check", "Result", "Time"})
+	ok := func(s string) string { return hf.Green(s) }
+	bad := func(s string) string { return hf.Red(s) }
+	timing := func(v float64) string { return fmt.Sprintf("%.1f ms", v) }
+
+	if r.Error != "" {
+		t.AppendRow(table.Row{"Environment", bad(r.Error), ""})
+	}
+	if s := r.SSH; s != nil {
+		if s.Error != "" {
+			t.AppendRow(table.Row{"SSH tunnel", bad(s.Chain + ": " + s.Error), timing(s.Ms)})
+		} else {
+			t.AppendRow(table.Row{"SSH tunnel", ok(s.Chain), timing(s.Ms)})
+		}
+	}
+	if d := r.DNS; d != nil {
+		if d.Error != "" {
+			t.AppendRow(table.Row{"DNS", bad(d.Error), timing(d.Ms)})
+		} else {
+			t.AppendRow(table.Row{"DNS", ok(strings.Join(d.Addresses, ", ")), timing(d.Ms)})
+		}
+	}
+	if c := r.TCP; c != nil {
+		if c.Error != "" {
+			t.AppendRow(table.Row{"TCP connect", bad(c.Error), timing(c.Ms)})
+		} else {
+			t.AppendRow(table.Row{"TCP connect", ok(c.Address), timing(c.Ms)})
+		}
+	}
+	if s := r.TLS; s != nil {
+		switch {
+		case s.Negotiated:
+			t.AppendRow(table.Row{"TLS", ok(s.Version + ", " + s.Cipher), ""})
+		case r.SSLMode == "require" || strings.HasPrefix(r.SSLMode, "verify"):
+			t.AppendRow(table.Row{"TLS", bad(s.Error), ""})
+		default:
+			t.AppendRow(table.Row{"TLS", hf.Yellow(s.Error), ""})
+		}
+		for i, c := range s.Chain {
+			expiry := c.String()
+			switch {
+			case c.DaysLeft < 0:
+				expiry = bad(expiry)
+			case c.DaysLeft < 30:
+				expiry = hf.Yellow(expiry)
+			}
+			t.AppendRow(table.Row{fmt.Sprintf("Certificate %d", i), expiry, ""})
+		}
+		if c := s.ClientCert; c != nil {
+			expiry := c.String()
+			if c.DaysLeft < 30 {
+				expiry = hf.Yellow(expiry)
+			}
+			t.AppendRow(table.Row{"Client certificate", expiry, ""})
+		}
+		if s.Negotiated {
+			// the verification only fails the connection with the verify-* modes
+			warn := bad
+			if !strings.HasPrefix(r.SSLMode, "verify") {
+				warn = hf.Yellow
+			}
+			if s.Verified {
+				t.AppendRow(table.Row{"Chain verification", ok("trusted"), ""})
+			} else {
+				t.AppendRow(table.Row{"Chain verification", warn(s.VerifyError), ""})
+			}
+			if r.SSLMode != "verify-full" {
+				warn = hf.Yellow
+			}
+			if s.HostnameOK {
+				t.AppendRow(table.Row{"Hostname", ok("matches " + r.Host), ""})
+			} else {
+				t.AppendRow(table.Row{"Hostname", warn(s.HostnameError), ""})
+			}
+		}
+	}
+	if a := r.Auth; a != nil {
+		if a.Error != "" {
+			t.AppendRow(table.Row{"Authentication", bad(a.Error), ""})
+		} else {
+			t.AppendRow(table.Row{"Authentication", ok(a.Method), ""})
+		}
+	}
+	if s := r.Server; s != nil {
+		if s.Error != "" {
+			t.AppendRow(table.Row{"Connection", bad(s.Error), timing(s.ConnectMs)})
+		} else {
+			t.AppendRow(table.Row{"Connection", ok("PostgreSQL " + s.Version), timing(s.ConnectMs)})
+			t.AppendRow(table.Row{"Latency", fmt.Sprintf("min %.1f / avg %.1f / max %.1f ms over %d round trips", s.MinMs, s.AvgMs, s.MaxMs, latencySamples), ""})
+		}
+	}
+	t.SetStyle(table.StyleLight)
+	t.Style().Format.Header = text.FormatDefault
+	t.Render()
+}
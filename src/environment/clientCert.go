@@ -0,0 +1,236 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/26 15:20
+// Original filename: src/environment/clientCert.go
+
+package environment
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"pgtools/types"
+	"sync"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"golang.org/x/term"
+)
+
+// A client certificate closer than this to its expiry is warned about
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// the client certificates already loaded, so that the passphrase is asked for, and the expiry reported, only once
+var (
+	clientCerts   = map[string]*tls.Certificate{}
+	clientCertsMu sync.Mutex
+)
+
+// PKCS#5 v2 identifiers, for the "ENCRYPTED PRIVATE KEY" files written by OpenSSL 3
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAESCBC     = map[string]int{"2.16.840.1.101.3.4.1.2": 16, "2.16.840.1.101.3.4.1.22": 24, "2.16.840.1.101.3.4.1.42": 32}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt      []byte
+	Iteration int
+	KeyLength int                      `asn1:"optional"`
+	PRF       pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// checkClientFiles checks that the client certificate and key go together, and that the key is only readable by its
+// owner, as libpq requires
+func checkClientFiles(cfg *types.DBConfig) *ce.CustomError {
+	if (cfg.SSLclientCert == "") != (cfg.SSLclientKey == "") {
+		return &ce.CustomError{Code: 40, Title: "Invalid client certificate", Message: "both sslclientcert and sslclientkey are needed"}
+	}
+	if cfg.SSLclientKey == "" {
+		return nil
+	}
+	info, err := os.Stat(cfg.SSLclientKey)
+	if err != nil {
+		return &ce.CustomError{Code: 40, Title: "Cannot read the client key", Message: err.Error()}
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return &ce.CustomError{Code: 41, Title: "Unsafe client key permissions",
+			Message: fmt.Sprintf("%s has mode %04o; it must only be readable by its owner: chmod 600 %s", cfg.SSLclientKey, info.Mode().Perm(), cfg.SSLclientKey)}
+	}
+	return nil
+}
+
+// KeyEncrypted tells whether a PEM key file needs a passphrase
+func KeyEncrypted(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	return block != nil && (block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block))
+}
+
+// ClientCertificate loads the client certificate of the environment, or returns nil when it has none. The key may be
+// encrypted, in the legacy PEM format or as PKCS#8 (PBES2); its passphrase is the sslpassword, else it is asked for
+// on a terminal. The certificate must match the key and be valid now; one about to expire is warned about.
+func ClientCertificate(cfg *types.DBConfig) (*tls.Certificate, *ce.CustomError) {
+	if err := checkClientFiles(cfg); err != nil || cfg.SSLclientCert == "" {
+		return nil, err
+	}
+	clientCertsMu.Lock()
+	defer clientCertsMu.Unlock()
+	if cert, ok := clientCerts[cfg.SSLclientCert+"\x00"+cfg.SSLclientKey]; ok {
+		return cert, nil
+	}
+
+	certPEM, err := os.ReadFile(cfg.SSLclientCert)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 40, Title: "Cannot read the client certificate", Message: err.Error()}
+	}
+	keyPEM, err := os.ReadFile(cfg.SSLclientKey)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 40, Title: "Cannot read the client key", Message: err.Error()}
+	}
+	keyPEM, cerr := decryptKey(keyPEM, cfg)
+	if cerr != nil {
+		return nil, cerr
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 42, Title: "Invalid client certificate",
+			Message: fmt.Sprintf("%s and %s: %s", cfg.SSLclientCert, cfg.SSLclientKey, err.Error())}
+	}
+
+	leaf := cert.Leaf
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		return nil, &ce.CustomError{Code: 43, Title: "Client certificate expired",
+			Message: fmt.Sprintf("%s expired on %s", cfg.SSLclientCert, leaf.NotAfter.Format("2006-01-02"))}
+	case now.Before(leaf.NotBefore):
+		return nil, &ce.CustomError{Code: 43, Title: "Client certificate not yet valid",
+			Message: fmt.Sprintf("%s is only valid from %s", cfg.SSLclientCert, leaf.NotBefore.Format("2006-01-02 15:04"))}
+	case leaf.NotAfter.Sub(now) < certExpiryWarning:
+		fmt.Fprintf(os.Stderr, "WARNING: the client certificate %s (%s) expires on %s, in %d days\n", cfg.SSLclientCert,
+			leaf.Subject.CommonName, leaf.NotAfter.Format("2006-01-02"), int(leaf.NotAfter.Sub(now).Hours()/24))
+	}
+	clientCerts[cfg.SSLclientCert+"\x00"+cfg.SSLclientKey] = &cert
+	return &cert, nil
+}
+
+// decryptKey returns the key in clear PEM
+func decryptKey(keyPEM []byte, cfg *types.DBConfig) ([]byte, *ce.CustomError) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, &ce.CustomError{Code: 42, Title: "Invalid client key", Message: cfg.SSLclientKey + " holds no PEM key"}
+	}
+	legacy := x509.IsEncryptedPEMBlock(block)
+	if !legacy && block.Type != "ENCRYPTED PRIVATE KEY" {
+		return keyPEM, nil
+	}
+
+	pass := cfg.SSLPassword
+	if pass == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, &ce.CustomError{Code: 44, Title: "Encrypted client key",
+				Message: cfg.SSLclientKey + " is encrypted; set its passphrase with pgtools env set NAME sslpassword=-"}
+		}
+		pass = hf.GetPassword(fmt.Sprintf("Passphrase of %s: ", cfg.SSLclientKey), types.DebugMode)
+	}
+	var der []byte
+	var err error
+	if legacy {
+		der, err = x509.DecryptPEMBlock(block, []byte(pass))
+	} else {
+		der, err = decryptPKCS8(block.Bytes, pass)
+		block.Type = "PRIVATE KEY"
+	}
+	if err != nil {
+		return nil, &ce.CustomError{Code: 44, Title: "Cannot decrypt the client key", Message: fmt.Sprintf("%s: %s", cfg.SSLclientKey, err.Error())}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// decryptPKCS8 decrypts a PBES2 (PBKDF2 and AES-CBC) encrypted PKCS#8 key
+func decryptPKCS8(data []byte, pass string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s; convert the key with openssl pkcs8 -topk8 -v2 aes256", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	keyLen, ok := oidAESCBC[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key cipher %s", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported key derivation hash %s", kdf.PRF.Algorithm)
+	}
+
+	key, err := pbkdf2.Key(prf, pass, kdf.Salt, kdf.Iteration, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := aes.NewCipher(key)
+	if len(iv) != block.BlockSize() || len(info.EncryptedData)%block.BlockSize() != 0 || len(info.EncryptedData) == 0 {
+		return nil, errors.New("malformed encrypted key")
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+	// a wrong passphrase shows as bad padding, or as a key that does not parse
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, errors.New("wrong passphrase")
+	}
+	plain = plain[:len(plain)-pad]
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return plain, nil
+}
@@ -0,0 +1,267 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/24 13:20
+// Original filename: src/environment/crypt.go
+
+package environment
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pgtools/types"
+	"strings"
+	"sync"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"golang.org/x/crypto/argon2"
+)
+
+// Passwords are stored as this prefix followed by the base64 of the GCM nonce and sealed password
+const sealedPrefix = "aesgcm:"
+
+// The key comes from a key file (the default, created on first use) or, once "env lock" was run, from a master
+// passphrase whose salt and check value are kept in the lock file.
+const (
+	keyFileName   = "pgtools.key"
+	lockFileName  = "pgtools.lock"
+	passphraseEnv = "PGTOOLS_PASSPHRASE"
+	keyFileEnv    = "PGTOOLS_KEYFILE"
+	checkValue    = "pgtools"
+)
+
+// lockFile describes how the passphrase is turned into the key
+type lockFile struct {
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Salt    string `json:"salt"`
+	Check   string `json:"check"` // checkValue sealed with the key, to tell a wrong passphrase
+}
+
+// the key, once loaded; fleet commands load environments concurrently, so the passphrase is asked for only once
+var (
+	envKey   []byte
+	envKeyMu sync.Mutex
+)
+
+func configDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "JFG", "pgtools")
+}
+
+func keyFilePath() string {
+	if path := os.Getenv(keyFileEnv); path != "" {
+		return path
+	}
+	return filepath.Join(configDir(), keyFileName)
+}
+
+func lockFilePath() string {
+	return filepath.Join(configDir(), lockFileName)
+}
+
+// Locked reports whether the passwords are protected by a master passphrase rather than by a key file
+func Locked() bool {
+	_, err := os.Stat(lockFilePath())
+	return err == nil
+}
+
+// loadKey returns the encryption key, asking for the passphrase when locked, or creating the key file on first use
+func loadKey() ([]byte, *ce.CustomError) {
+	envKeyMu.Lock()
+	defer envKeyMu.Unlock()
+	if envKey != nil {
+		return envKey, nil
+	}
+	var err *ce.CustomError
+	if Locked() {
+		envKey, err = unlockKey(passphrase("Master passphrase: "))
+	} else {
+		envKey, err = readKeyFile()
+	}
+	return envKey, err
+}
+
+// readKeyFile reads the key file, refusing one that others can read; a missing key file is created
+func readKeyFile() ([]byte, *ce.CustomError) {
+	path := keyFilePath()
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		key := newKey()
+		if cerr := writeKeyFile(key); cerr != nil {
+			return nil, cerr
+		}
+		fmt.Fprintf(os.Stderr, "Created the key file %s, which encrypts the environment passwords\n", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, &ce.CustomError{Code: 20, Title: "Cannot read the key file", Message: err.Error()}
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, &ce.CustomError{Code: 20, Title: "Unsafe key file permissions",
+			Message: fmt.Sprintf("%s has mode %04o; it must only be readable by its owner: chmod 600 %s", path, info.Mode().Perm(), path)}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 20, Title: "Cannot read the key file", Message: err.Error()}
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, &ce.CustomError{Code: 20, Title: "Invalid key file", Message: fmt.Sprintf("%s does not hold a base64 256-bit key", path)}
+	}
+	return key, nil
+}
+
+func writeKeyFile(key []byte) *ce.CustomError {
+	path := keyFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return &ce.CustomError{Code: 21, Title: "Cannot write the key file", Message: err.Error()}
+	}
+	if err := writeFileAtomic(path, encodeKey(key)); err != nil {
+		return &ce.CustomError{Code: 21, Title: "Cannot write the key file", Message: err.Error()}
+	}
+	return nil
+}
+
+// encodeKey returns the content of a key file
+func encodeKey(key []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+}
+
+func newKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// passphrase reads the master passphrase from $PGTOOLS_PASSPHRASE, else from the terminal
+func passphrase(prompt string) string {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return p
+	}
+	return hf.GetPassword(prompt, types.DebugMode)
+}
+
+// newPassphrase asks for a new passphrase twice
+func newPassphrase() (string, *ce.CustomError) {
+	p := passphrase("New master passphrase: ")
+	if p == "" {
+		return "", &ce.CustomError{Code: 22, Title: "Invalid passphrase", Message: "the passphrase cannot be empty"}
+	}
+	if os.Getenv(passphraseEnv) == "" && hf.GetPassword("Confirm the passphrase: ", types.DebugMode) != p {
+		return "", &ce.CustomError{Code: 22, Title: "Invalid passphrase", Message: "the passphrases do not match"}
+	}
+	return p, nil
+}
+
+// unlockKey derives the key from the passphrase and the lock file, and checks it
+func unlockKey(pass string) ([]byte, *ce.CustomError) {
+	b, err := os.ReadFile(lockFilePath())
+	if err != nil {
+		return nil, &ce.CustomError{Code: 20, Title: "Cannot read the lock file", Message: err.Error()}
+	}
+	var lf lockFile
+	if err := json.Unmarshal(b, &lf); err != nil || lf.KDF != "argon2id" {
+		return nil, &ce.CustomError{Code: 20, Title: "Invalid lock file", Message: fmt.Sprintf("%s is not a pgtools lock file", lockFilePath())}
+	}
+	salt, err := base64.StdEncoding.DecodeString(lf.Salt)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 20, Title: "Invalid lock file", Message: err.Error()}
+	}
+	key := argon2.IDKey([]byte(pass), salt, lf.Time, lf.Memory, lf.Threads, 32)
+	if check, err := unseal(key, lf.Check); err != nil || check != checkValue {
+		return nil, &ce.CustomError{Code: 22, Title: "Wrong passphrase", Message: "the master passphrase does not match"}
+	}
+	return key, nil
+}
+
+// newLockFile derives a key from the passphrase with a new salt; it returns the key and the lock file content
+func newLockFile(pass string) ([]byte, []byte) {
+	lf := lockFile{KDF: "argon2id", Time: 3, Memory: 64 * 1024, Threads: 4}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	lf.Salt = base64.StdEncoding.EncodeToString(salt)
+	key := argon2.IDKey([]byte(pass), salt, lf.Time, lf.Memory, lf.Threads, 32)
+	lf.Check = seal(key, checkValue)
+	b, _ := json.MarshalIndent(lf, "", "  ")
+	return key, b
+}
+
+func seal(key []byte, plaintext string) string {
+	gcm := newGCM(key)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+func unseal(key []byte, sealed string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm := newGCM(key)
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("sealed value too short")
+	}
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	return string(plain), err
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
+
+// legacyDecode reads a password written by earlier versions, which only obfuscated it
+func legacyDecode(encoded string) (plain string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return hf.DecodeString(encoded, ""), nil
+}
+
+// writeFileAtomic replaces path with data, readable by its owner only
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writeTemp writes data in a temporary file next to path, readable by its owner only, and returns its name
+func writeTemp(path string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	if err := tmp.Chmod(0o600); err == nil {
+		if _, err = tmp.Write(data); err == nil {
+			err = tmp.Close()
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,318 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/25 13:40
+// Original filename: src/environment/editSet.go
+
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"pgtools/tunnel"
+	"pgtools/types"
+	"slices"
+	"strconv"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+)
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// envFields are the keys accepted by env set: the JSON names of the env file, and a few aliases
+var envFields = map[string]func(cfg *types.DBConfig) *string{
+	"host":           func(c *types.DBConfig) *string { return &c.Host },
+	"user":           func(c *types.DBConfig) *string { return &c.User },
+	"password":       func(c *types.DBConfig) *string { return &c.Password },
+	"sslmode":        func(c *types.DBConfig) *string { return &c.SSLMode },
+	"sslrootcert":    func(c *types.DBConfig) *string { return &c.SSLRootCert },
+	"sslclientcert":  func(c *types.DBConfig) *string { return &c.SSLclientCert },
+	"sslcert":        func(c *types.DBConfig) *string { return &c.SSLclientCert },
+	"sslclientkey":   func(c *types.DBConfig) *string { return &c.SSLclientKey },
+	"sslkey":         func(c *types.DBConfig) *string { return &c.SSLclientKey },
+	"sslpassword":    func(c *types.DBConfig) *string { return &c.SSLPassword },
+	"comment":        func(c *types.DBConfig) *string { return &c.Description },
+	"description":    func(c *types.DBConfig) *string { return &c.Description },
+	"defaultdb":      func(c *types.DBConfig) *string { return &c.DefaultDB },
+	"ssh.host":       func(c *types.DBConfig) *string { return &sshOf(c).Host },
+	"ssh.user":       func(c *types.DBConfig) *string { return &sshOf(c).User },
+	"ssh.keyfile":    func(c *types.DBConfig) *string { return &sshOf(c).KeyFile },
+	"ssh.knownhosts": func(c *types.DBConfig) *string { return &sshOf(c).KnownHosts },
+}
+
+// envPorts are the numeric keys of env set
+var envPorts = map[string]func(cfg *types.DBConfig) *int{
+	"port":     func(c *types.DBConfig) *int { return &c.Port },
+	"ssh.port": func(c *types.DBConfig) *int { return &sshOf(c).Port },
+}
+
+// sshOf returns the ssh section, adding it if needed; env set drops it again when ssh.host ends up empty
+func sshOf(cfg *types.DBConfig) *types.SSHConfig {
+	if cfg.SSH == nil {
+		cfg.SSH = &types.SSHConfig{}
+	}
+	return cfg.SSH
+}
+
+// envPath returns the path of an env file, adding the .json extension
+func envPath(name string) (string, *ce.CustomError) {
+	name = strings.TrimSuffix(name, ".json") + ".json"
+	if name == ".json" || strings.ContainsRune(name, os.PathSeparator) || strings.HasPrefix(name, ".") {
+		return "", &ce.CustomError{Code: 27, Title: "Invalid environment name", Message: fmt.Sprintf("%q is not a file name", name)}
+	}
+	return filepath.Join(configDir(), name), nil
+}
+
+// checkAbsent refuses to overwrite an env file, unless --force
+func checkAbsent(path string) *ce.CustomError {
+	if _, err := os.Stat(path); err == nil && !types.EnvForce {
+		return &ce.CustomError{Code: 27, Title: "Environment exists", Message: fmt.Sprintf("%s already exists; use --force to replace it", filepath.Base(path))}
+	}
+	return nil
+}
+
+// ValidateConfig checks the values of an environment. Only the values present are checked: those left out are taken
+// from the libpq variables and service file, or their defaults, when the environment is loaded (see resolveConfig).
+func ValidateConfig(cfg *types.DBConfig) *ce.CustomError {
+	invalid := func(format string, args ...any) *ce.CustomError {
+		return &ce.CustomError{Code: 28, Title: "Invalid environment", Message: fmt.Sprintf(format, args...)}
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return invalid("port %d is not a TCP port", cfg.Port)
+	}
+	if cfg.SSLMode != "" && !slices.Contains(sslModes, cfg.SSLMode) {
+		return invalid("sslmode %q: expected one of %s", cfg.SSLMode, strings.Join(sslModes, ", "))
+	}
+	for name, path := range map[string]string{"sslrootcert": cfg.SSLRootCert, "sslclientcert": cfg.SSLclientCert, "sslclientkey": cfg.SSLclientKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return invalid("%s: %s", name, err.Error())
+		}
+	}
+	if cfg.SSH != nil {
+		if strings.HasPrefix(cfg.Host, "/") {
+			return invalid("a Unix socket cannot be reached through SSH")
+		}
+		if err := tunnel.Check(cfg.SSH); err != nil {
+			return err
+		}
+	}
+	return checkClientFiles(cfg)
+}
+
+// AddConfig saves the env file from the env add flags
+func AddConfig(name string) *ce.CustomError {
+	path, err := envPath(name)
+	if err != nil {
+		return err
+	}
+	if err := checkAbsent(path); err != nil {
+		return err
+	}
+	cfg := types.EnvAddConfig
+	if types.EnvAddSSH.Host != "" {
+		cfg.SSH = &types.EnvAddSSH
+	}
+	if types.EnvPasswordStdin {
+		cfg.Password = readStdinLine()
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 5432
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "prefer"
+	}
+	if err := ValidateConfig(&cfg); err != nil {
+		return err
+	}
+	if err := saveEnvironment(path, cfg); err != nil {
+		return err
+	}
+	fmt.Printf("%s created\n", filepath.Base(path))
+	return nil
+}
+
+// SetValues updates an env file from key=value pairs; an empty value clears the key, password=- reads it from stdin
+func SetValues(name string, pairs []string) *ce.CustomError {
+	path, err := envPath(name)
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadEnvironment(filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !found {
+			return &ce.CustomError{Code: 27, Title: "Invalid setting", Message: fmt.Sprintf("%q: expected key=value", pair)}
+		}
+		if field, ok := envPorts[key]; ok {
+			port, e := strconv.Atoi(value)
+			if e != nil && value != "" {
+				return &ce.CustomError{Code: 27, Title: "Invalid setting", Message: fmt.Sprintf("%s %q is not a number", key, value)}
+			}
+			*field(cfg) = port
+			continue
+		}
+		if key == "ssh.jump" {
+			sshOf(cfg).Jump = nil
+			for _, hop := range strings.Split(value, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					cfg.SSH.Jump = append(cfg.SSH.Jump, hop)
+				}
+			}
+			continue
+		}
+		field, ok := envFields[key]
+		if !ok {
+			keys := append(slices.Collect(maps.Keys(envFields)), "port", "ssh.port", "ssh.jump")
+			slices.Sort(keys)
+			return &ce.CustomError{Code: 27, Title: "Invalid setting", Message: fmt.Sprintf("unknown key %q; expected one of %s", key, strings.Join(keys, ", "))}
+		}
+		if (key == "password" || key == "sslpassword") && value == "-" {
+			value = readStdinLine()
+		}
+		*field(cfg) = value
+	}
+	if cfg.SSH != nil && cfg.SSH.Host == "" {
+		cfg.SSH = nil
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		return err
+	}
+	return saveEnvironment(path, *cfg)
+}
+
+// readStdinLine reads a password piped to pgtools
+func readStdinLine() string {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	return strings.TrimRight(scanner.Text(), "\r")
+}
+
+// EditConfig opens $VISUAL or $EDITOR (vi by default) on a decrypted copy of the env file, which is saved back
+// once valid. The copy is kept in the config directory, which only its owner can read, and removed afterwards.
+func EditConfig(name string) *ce.CustomError {
+	path, err := envPath(name)
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadEnvironment(filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	original, _ := json.MarshalIndent(cfg, "", "  ")
+
+	tmp, e := os.CreateTemp(configDir(), ".edit-*.json")
+	if e != nil {
+		return &ce.CustomError{Code: 29, Title: "Cannot create the temporary file", Message: e.Error()}
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0o600)
+	_, e = tmp.Write(append(original, '\n'))
+	if cerr := tmp.Close(); e == nil {
+		e = cerr
+	}
+	if e != nil {
+		return &ce.CustomError{Code: 29, Title: "Cannot write the temporary file", Message: e.Error()}
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	for {
+		cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if e := cmd.Run(); e != nil {
+			return &ce.CustomError{Code: 29, Title: "Editor failed", Message: e.Error()}
+		}
+		data, e := os.ReadFile(tmp.Name())
+		if e != nil {
+			return &ce.CustomError{Code: 29, Title: "Cannot read the temporary file", Message: e.Error()}
+		}
+		if bytes.Equal(bytes.TrimSpace(data), bytes.TrimSpace(original)) {
+			fmt.Println("No changes")
+			return nil
+		}
+		var edited types.DBConfig
+		err = nil
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if e := dec.Decode(&edited); e != nil {
+			err = &ce.CustomError{Code: 10, Title: "Invalid JSON", Message: e.Error()}
+		} else {
+			err = ValidateConfig(&edited)
+		}
+		if err == nil {
+			if err := saveEnvironment(path, edited); err != nil {
+				return err
+			}
+			fmt.Printf("%s saved\n", filepath.Base(path))
+			return nil
+		}
+		fmt.Println(err.Error())
+		if !hf.GetBoolValFromPrompt("Edit again (t/f)? ") {
+			fmt.Println("Changes discarded")
+			return nil
+		}
+	}
+}
+
+// CopyConfig copies an env file, re-encrypting its password
+func CopyConfig(src, dst string) *ce.CustomError {
+	dstPath, err := envPath(dst)
+	if err != nil {
+		return err
+	}
+	if err := checkAbsent(dstPath); err != nil {
+		return err
+	}
+	srcPath, err := envPath(src)
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadEnvironment(filepath.Base(srcPath))
+	if err != nil {
+		return err
+	}
+	if err := saveEnvironment(dstPath, *cfg); err != nil {
+		return err
+	}
+	fmt.Printf("%s copied to %s\n", filepath.Base(srcPath), filepath.Base(dstPath))
+	return nil
+}
+
+// RenameConfig renames an env file
+func RenameConfig(src, dst string) *ce.CustomError {
+	srcPath, err := envPath(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := envPath(dst)
+	if err != nil {
+		return err
+	}
+	if err := checkAbsent(dstPath); err != nil {
+		return err
+	}
+	if e := os.Rename(srcPath, dstPath); e != nil {
+		return &ce.CustomError{Code: 12, Title: "Cannot rename " + filepath.Base(srcPath), Message: e.Error()}
+	}
+	fmt.Printf("%s renamed to %s\n", filepath.Base(srcPath), filepath.Base(dstPath))
+	return nil
+}
@@ -0,0 +1,122 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/28 10:15
+// Original filename: src/environment/fleet.go
+
+package environment
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"pgtools/types"
+	"slices"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// The fleet file names groups of environments: {"nordics": ["bergen", "oslo"], "all": ["*"]}
+const fleetFileName = "fleet.json"
+
+// readFleet reads the groups of the fleet file; a missing file defines none
+func readFleet() (map[string][]string, *ce.CustomError) {
+	groups := map[string][]string{}
+	data, err := os.ReadFile(filepath.Join(configDir(), fleetFileName))
+	if os.IsNotExist(err) {
+		return groups, nil
+	}
+	if err != nil {
+		return nil, &ce.CustomError{Code: 60, Title: "Cannot read the fleet file", Message: err.Error()}
+	}
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, &ce.CustomError{Code: 60, Title: "Invalid fleet file", Message: fleetFileName + ": " + err.Error()}
+	}
+	return groups, nil
+}
+
+// IsFleet tells whether -e names several environments: a comma separated list, a glob, or a group of the fleet file
+func IsFleet(spec string) bool {
+	if strings.ContainsAny(spec, ",*?[") {
+		return true
+	}
+	groups, err := readFleet()
+	_, isGroup := groups[strings.TrimSuffix(spec, ".json")]
+	return err == nil && isGroup
+}
+
+// ResolveFleet expands -e into environment names, in the order given and without duplicates. Each comma separated
+// item is a group of the fleet file, a glob matched against the environment files, or an environment name.
+func ResolveFleet(spec string) ([]string, *ce.CustomError) {
+	groups, err := readFleet()
+	if err != nil {
+		return nil, err
+	}
+	files, err := envFiles()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	add := func(name string) {
+		if name = strings.TrimSuffix(name, ".json"); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	var expand func(item string, seen []string) *ce.CustomError
+	expand = func(item string, seen []string) *ce.CustomError {
+		item = strings.TrimSuffix(strings.TrimSpace(item), ".json")
+		switch members, isGroup := groups[item]; {
+		case item == "":
+		case isGroup:
+			if slices.Contains(seen, item) {
+				return &ce.CustomError{Code: 60, Title: "Invalid fleet file", Message: "the group " + item + " includes itself"}
+			}
+			for _, m := range members {
+				if err := expand(m, append(seen, item)); err != nil {
+					return err
+				}
+			}
+		case strings.ContainsAny(item, "*?["):
+			matched := false
+			for _, f := range files {
+				if ok, e := path.Match(item+".json", f); e != nil {
+					return &ce.CustomError{Code: 61, Title: "Invalid environment pattern", Message: item + ": " + e.Error()}
+				} else if ok {
+					add(f)
+					matched = true
+				}
+			}
+			if !matched {
+				return &ce.CustomError{Code: 61, Title: "No environment matches", Message: item}
+			}
+		default:
+			add(item)
+		}
+		return nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		if err := expand(item, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(names) == 0 {
+		return nil, &ce.CustomError{Code: 61, Title: "No environment matches", Message: spec}
+	}
+	return names, nil
+}
+
+// LoadFleetConfig loads one environment of a fleet; like LoadConfig, the settings it lacks come from the command line
+// and the libpq sources
+func LoadFleetConfig(name string) (*types.DBConfig, *ce.CustomError) {
+	cfg, err := LoadEnvironment(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,226 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/24 14:30
+// Original filename: src/environment/keyring.go
+
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"pgtools/types"
+	"slices"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// envFiles lists the environment files of the config directory, as env show does
+func envFiles() ([]string, *ce.CustomError) {
+	entries, err := os.ReadDir(configDir())
+	if err != nil {
+		return nil, &ce.CustomError{Code: 15, Title: "Unable to read config directory", Message: err.Error()}
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") && !strings.HasPrefix(e.Name(), "sample") {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// decryptAll reads every environment file with the current key
+func decryptAll() (map[string]types.DBConfig, *ce.CustomError) {
+	names, err := envFiles()
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]types.DBConfig{}
+	for _, name := range names {
+		data, e := os.ReadFile(filepath.Join(configDir(), name))
+		if e != nil {
+			return nil, &ce.CustomError{Title: "Failed to read environment file", Message: e.Error()}
+		}
+		var cfg types.DBConfig
+		if e := json.Unmarshal(data, &cfg); e != nil {
+			return nil, &ce.CustomError{Code: 10, Title: "Failed to marshal JSON", Message: fmt.Sprintf("%s: %s", name, e.Error())}
+		}
+		if err := decryptPassword(&cfg, name); err != nil {
+			return nil, err
+		}
+		all[name] = cfg
+	}
+	return all, nil
+}
+
+// reencrypt encrypts every environment file with the new key, without ever leaving a file that no key on disk opens:
+// the files are first sealed into temporary files next to them, then the new key material is written to keyPath (the
+// previous one being kept as keyPath.old), the obsolete key material, if any, is moved aside to obsolete.old, and only
+// then are the temporary files renamed into place. Should a step fail, the files already replaced and the previous key
+// material are restored.
+func reencrypt(all map[string]types.DBConfig, key []byte, keyPath string, keyData []byte, obsolete string) *ce.CustomError {
+	names := slices.Sorted(maps.Keys(all))
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	originals := map[string][]byte{}
+	for _, name := range names {
+		path := filepath.Join(configDir(), name)
+		data, err := sealEnvironment(all[name], key)
+		if err != nil {
+			return err
+		}
+		original, e := os.ReadFile(path)
+		if e != nil {
+			return &ce.CustomError{Title: "Failed to read environment file", Message: e.Error()}
+		}
+		originals[name] = original
+		tmp, e := writeTemp(path, data)
+		if e != nil {
+			return &ce.CustomError{Code: 12, Title: fmt.Sprintf("Error writing the config file %s", path), Message: e.Error()}
+		}
+		staged[name] = tmp
+	}
+
+	oldKey, readErr := os.ReadFile(keyPath)
+	backup := keyPath + ".old"
+	if readErr == nil {
+		if e := writeFileAtomic(backup, oldKey); e != nil {
+			return &ce.CustomError{Code: 21, Title: "Cannot keep the previous key material", Message: e.Error()}
+		}
+	}
+	if e := os.MkdirAll(filepath.Dir(keyPath), 0o700); e != nil {
+		return &ce.CustomError{Code: 21, Title: "Cannot write " + keyPath, Message: e.Error()}
+	}
+	if e := writeFileAtomic(keyPath, keyData); e != nil {
+		os.Remove(backup)
+		return &ce.CustomError{Code: 21, Title: "Cannot write " + keyPath, Message: e.Error()}
+	}
+	restoreKey := func() {
+		if readErr == nil {
+			writeFileAtomic(keyPath, oldKey)
+		} else {
+			os.Remove(keyPath)
+		}
+		os.Remove(backup)
+	}
+
+	// moving the obsolete key material aside switches Locked() before the files change, so that a failure below can
+	// still be rolled back by moving it back
+	kept := backup
+	if obsolete != "" {
+		kept = obsolete + ".old"
+		if e := os.Rename(obsolete, kept); e != nil && !os.IsNotExist(e) {
+			restoreKey()
+			return &ce.CustomError{Code: 21, Title: "Cannot remove " + obsolete, Message: e.Error()}
+		}
+	}
+
+	for i, name := range names {
+		if e := os.Rename(staged[name], filepath.Join(configDir(), name)); e != nil {
+			restored := true
+			for _, done := range names[:i] {
+				if writeFileAtomic(filepath.Join(configDir(), done), originals[done]) != nil {
+					restored = false
+				}
+			}
+			if !restored {
+				return &ce.CustomError{Code: 12, Title: "Cannot re-encrypt the environment files",
+					Message: fmt.Sprintf("%s: %s; some files could not be restored, the previous key material is kept in %s", name, e.Error(), kept)}
+			}
+			restoreKey()
+			if obsolete != "" {
+				os.Rename(kept, obsolete)
+			}
+			return &ce.CustomError{Code: 12, Title: "Cannot re-encrypt the environment files", Message: fmt.Sprintf("%s: %s; nothing was changed", name, e.Error())}
+		}
+		delete(staged, name)
+	}
+	envKeyMu.Lock()
+	envKey = key
+	envKeyMu.Unlock()
+	os.Remove(backup)
+	if obsolete != "" {
+		os.Remove(kept)
+	}
+	return nil
+}
+
+// Rekey encrypts every environment password with a new key: a new key file, or a new passphrase when locked
+func Rekey() *ce.CustomError {
+	all, err := decryptAll()
+	if err != nil {
+		return err
+	}
+	if Locked() {
+		pass, err := newPassphrase()
+		if err != nil {
+			return err
+		}
+		key, lock := newLockFile(pass)
+		if err := reencrypt(all, key, lockFilePath(), lock, ""); err != nil {
+			return err
+		}
+	} else {
+		key := newKey()
+		if err := reencrypt(all, key, keyFilePath(), encodeKey(key), ""); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d environment file(s) re-encrypted with a new key\n", len(all))
+	return nil
+}
+
+// Lock protects the environment passwords with a master passphrase instead of the key file, which is removed
+func Lock() *ce.CustomError {
+	if Locked() {
+		return &ce.CustomError{Code: 24, Title: "Already locked", Message: "the passwords are already protected by a passphrase; use env rekey to change it"}
+	}
+	all, err := decryptAll()
+	if err != nil {
+		return err
+	}
+	pass, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	key, lock := newLockFile(pass)
+	// a key file named by $PGTOOLS_KEYFILE may live on removable media or be shared; it is left to its owner
+	obsolete := keyFilePath()
+	if os.Getenv(keyFileEnv) != "" {
+		obsolete = ""
+	}
+	if err := reencrypt(all, key, lockFilePath(), lock, obsolete); err != nil {
+		return err
+	}
+	if obsolete == "" {
+		fmt.Printf("The key file %s is no longer needed and can be destroyed\n", keyFilePath())
+	}
+	fmt.Printf("%d environment file(s) are now protected by the master passphrase (or $%s)\n", len(all), passphraseEnv)
+	return nil
+}
+
+// Unlock goes back to a key file, so that no passphrase is asked for
+func Unlock() *ce.CustomError {
+	if !Locked() {
+		return &ce.CustomError{Code: 24, Title: "Not locked", Message: "the passwords are protected by the key file " + keyFilePath()}
+	}
+	all, err := decryptAll()
+	if err != nil {
+		return err
+	}
+	key := newKey()
+	if err := reencrypt(all, key, keyFilePath(), encodeKey(key), lockFilePath()); err != nil {
+		return err
+	}
+	fmt.Printf("%d environment file(s) are now protected by the key file %s\n", len(all), keyFilePath())
+	return nil
+}
@@ -0,0 +1,245 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/25 09:15
+// Original filename: src/environment/libpq.go
+
+package environment
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pgtools/logging"
+	"pgtools/types"
+	"strconv"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// The settings are taken, field by field, from the first source that has them:
+//  1. the command line flags (--host, --port, --user, --sslmode)
+//  2. the env file (-e)
+//  3. the libpq variables (PGHOST, PGPORT, PGUSER, PGPASSWORD, PGDATABASE, PGSSLMODE...)
+//  4. the service file entry (--service, or $PGSERVICE)
+// A password still missing is then looked up in the password file (~/.pgpass or $PGPASSFILE) when connecting.
+
+// pgVariables are the libpq variables read, with the service file key they match
+var pgVariables = map[string]string{
+	"host": "PGHOST", "port": "PGPORT", "user": "PGUSER", "password": "PGPASSWORD", "dbname": "PGDATABASE",
+	"sslmode": "PGSSLMODE", "sslrootcert": "PGSSLROOTCERT", "sslcert": "PGSSLCERT", "sslkey": "PGSSLKEY",
+}
+
+// resolveConfig completes the env file settings, which may be empty, with the other sources
+func resolveConfig(cfg *types.DBConfig) *ce.CustomError {
+	flags := map[string]string{"host": types.ConnFlags.Host, "user": types.ConnFlags.User, "sslmode": types.ConnFlags.SSLMode}
+	if types.ConnFlags.Port != 0 {
+		flags["port"] = strconv.Itoa(types.ConnFlags.Port)
+	}
+	env := map[string]string{}
+	for key, name := range pgVariables {
+		env[key] = os.Getenv(name)
+	}
+	service, err := serviceSettings()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		key    string
+		value  *string
+		isFlag bool
+	}{
+		{"host", &cfg.Host, true}, {"user", &cfg.User, true}, {"password", &cfg.Password, false},
+		{"sslmode", &cfg.SSLMode, true}, {"sslrootcert", &cfg.SSLRootCert, false}, {"sslcert", &cfg.SSLclientCert, false},
+		{"sslkey", &cfg.SSLclientKey, false}, {"sslpassword", &cfg.SSLPassword, false}, {"dbname", &cfg.DefaultDB, false},
+	} {
+		if f.isFlag && flags[f.key] != "" {
+			*f.value = flags[f.key]
+			continue
+		}
+		for _, source := range []struct {
+			name   string
+			values map[string]string
+		}{{"PG variables", env}, {"service file", service}} {
+			if *f.value == "" && source.values[f.key] != "" {
+				*f.value = source.values[f.key]
+				logging.Debugf("%s taken from the %s", f.key, source.name)
+			}
+		}
+	}
+
+	port := flags["port"]
+	if port == "" && cfg.Port == 0 {
+		if port = env["port"]; port == "" {
+			port = service["port"]
+		}
+	}
+	if port != "" {
+		p, e := strconv.Atoi(port)
+		if e != nil || p <= 0 || p > 65535 {
+			return &ce.CustomError{Code: 25, Title: "Invalid port", Message: fmt.Sprintf("%q is not a TCP port", port)}
+		}
+		cfg.Port = p
+	}
+	// the libpq defaults
+	if cfg.Host == "" {
+		cfg.Host = "localhost"
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 5432
+	}
+	if cfg.User == "" {
+		cfg.User = os.Getenv("USER")
+	}
+	return nil
+}
+
+// libpqConfigured tells whether a source other than the env file names a server
+func libpqConfigured() bool {
+	return types.ConnFlags.Host != "" || types.ConnFlags.Service != "" || os.Getenv("PGHOST") != "" || os.Getenv("PGSERVICE") != ""
+}
+
+// serviceSettings reads the --service (or $PGSERVICE) entry: from $PGSERVICEFILE or ~/.pg_service.conf first, then
+// from pg_service.conf in $PGSYSCONFDIR (/etc/postgresql-common by default), like libpq
+func serviceSettings() (map[string]string, *ce.CustomError) {
+	name := types.ConnFlags.Service
+	if name == "" {
+		name = os.Getenv("PGSERVICE")
+	}
+	if name == "" {
+		return nil, nil
+	}
+	userFile := os.Getenv("PGSERVICEFILE")
+	if userFile == "" {
+		userFile = filepath.Join(os.Getenv("HOME"), ".pg_service.conf")
+	}
+	sysconfdir := os.Getenv("PGSYSCONFDIR")
+	if sysconfdir == "" {
+		sysconfdir = "/etc/postgresql-common"
+	}
+	for _, path := range []string{userFile, filepath.Join(sysconfdir, "pg_service.conf")} {
+		settings, err := readService(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if settings != nil {
+			logging.Debugf("Service %s read from %s", name, path)
+			return settings, nil
+		}
+	}
+	return nil, &ce.CustomError{Code: 26, Title: "Unknown service", Message: fmt.Sprintf("service %q is not defined in %s or %s", name, userFile, filepath.Join(sysconfdir, "pg_service.conf"))}
+}
+
+// readService returns the settings of the [name] section of a service file, or nil when the file or section is missing
+func readService(path, name string) (map[string]string, *ce.CustomError) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &ce.CustomError{Code: 26, Title: "Cannot read the service file", Message: err.Error()}
+	}
+	defer f.Close()
+
+	var settings map[string]string
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+		case line[0] == '[':
+			if inSection {
+				return settings, nil
+			}
+			if inSection = strings.TrimSpace(strings.Trim(line, "[]")) == name; inSection {
+				settings = map[string]string{}
+			}
+		case inSection:
+			key, value, found := strings.Cut(line, "=")
+			if !found {
+				return nil, &ce.CustomError{Code: 26, Title: "Invalid service file", Message: fmt.Sprintf("%s:%d: expected key=value", path, n)}
+			}
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ce.CustomError{Code: 26, Title: "Cannot read the service file", Message: err.Error()}
+	}
+	return settings, nil
+}
+
+// PassfilePassword looks the password up in $PGPASSFILE or ~/.pgpass, whose lines read host:port:database:user:password
+// with * matching anything; like libpq, a file that others can read is ignored
+func PassfilePassword(cfg *types.DBConfig, dbname string) string {
+	path := os.Getenv("PGPASSFILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".pgpass")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: password file %s has group or world access; permissions should be u=rw (0600) or less\n", path)
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	host := cfg.Host
+	if strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	want := []string{host, strconv.Itoa(cfg.Port), dbname, cfg.User}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := splitPassfileLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			logging.Debugf("Password taken from %s", path)
+			return fields[4]
+		}
+	}
+	return ""
+}
+
+// splitPassfileLine splits on the colons that are not escaped by a backslash
+func splitPassfileLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
@@ -79,7 +79,7 @@ func ExplainEnvFile(envfiles []string) *ce.CustomError {
 
 		// error code 99 is specifically tailored for this use; it means that defaultEnv does not exist, and that
 		// we can continue. otherwise we abort
-		if e, err := LoadConfig(); err != nil && err.Code != 99 {
+		if e, err := LoadEnvironment(envfile); err != nil && err.Code != 99 {
 			types.EnvConfigFile = oldEnvFile
 			return err
 		} else {
@@ -106,7 +106,7 @@ func ExplainEnvFile(envfiles []string) *ce.CustomError {
 			}
 
 			t.AppendRow([]interface{}{hf.Green(envfile), hf.Green(e.Host), hf.Green(strconv.Itoa(e.Port)),
-				hf.Green(e.User), hf.Yellow("*ENCODED*"),
+				hf.Green(e.User), hf.Yellow("*ENCRYPTED*"),
 				sslmode, hf.Green(sslcacert), hf.Green(sslclientcert),
 				hf.Green(sslclientkey), hf.Green(e.DefaultDB), hf.Green(e.Description)})
 		}
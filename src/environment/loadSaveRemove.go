@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"pgtools/logging"
 	"pgtools/types"
 	"strings"
 
@@ -18,15 +19,41 @@ import (
 )
 
 // Loads the configuration file if -e is not passed, it defaults to $HOME/.config/JFG/pgtools/defaultEnv.json
+// The settings missing from the file are then taken from the libpq variables and service file (see resolveConfig);
+// --service, or a missing default file when the libpq sources name a server, skips the default file altogether.
 func LoadConfig() (*types.DBConfig, *ce.CustomError) {
+	if IsFleet(types.EnvConfigFile) {
+		return nil, &ce.CustomError{Code: 62, Title: "Several environments given",
+			Message: types.EnvConfigFile + " names a fleet; only show dbs, show sessions, conf get, roles show and srv version run across one"}
+	}
 	if !strings.HasSuffix(types.EnvConfigFile, ".json") {
 		types.EnvConfigFile += ".json"
 	}
-	path := filepath.Join(os.Getenv("HOME"), ".config", "JFG", "pgtools", types.EnvConfigFile)
+	cfg := &types.DBConfig{}
+	isDefault := types.EnvConfigFile == "defaultEnv.json"
+	_, statErr := os.Stat(filepath.Join(configDir(), types.EnvConfigFile))
+	if !isDefault || (types.ConnFlags.Service == "" && (statErr == nil || !libpqConfigured())) {
+		var err *ce.CustomError
+		if cfg, err = LoadEnvironment(types.EnvConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := resolveConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadEnvironment loads a named environment file, regardless of -e; used by commands that talk to two environments
+func LoadEnvironment(envfile string) (*types.DBConfig, *ce.CustomError) {
+	if !strings.HasSuffix(envfile, ".json") {
+		envfile += ".json"
+	}
+	path := filepath.Join(configDir(), envfile)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		_, a := os.Stat(path)
-		if a != nil && !os.IsNotExist(a) && types.EnvConfigFile == "defaultEnv.json" {
+		if a != nil && !os.IsNotExist(a) && envfile == "defaultEnv.json" {
 			// The code is ignored everywhere except in the for__loop in pgtools env info
 			return nil, &ce.CustomError{Title: "Failed to read environment file", Message: err.Error(), Code: 99}
 		}
@@ -37,10 +64,86 @@ func LoadConfig() (*types.DBConfig, *ce.CustomError) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, &ce.CustomError{Code: 10, Title: "Failed to marshal JSON", Message: err.Error()}
 	}
-	cfg.Password = hf.DecodeString(cfg.Password, "")
+	legacy := (cfg.Password != "" && !strings.HasPrefix(cfg.Password, sealedPrefix)) ||
+		(cfg.SSLPassword != "" && !strings.HasPrefix(cfg.SSLPassword, sealedPrefix))
+	if err := decryptPassword(&cfg, envfile); err != nil {
+		return nil, err
+	}
+	// files written by earlier versions are converted on first read
+	if legacy {
+		if err := saveEnvironment(path, cfg); err != nil {
+			logging.Errorf("Unable to convert %s to the encrypted format: %s", envfile, err.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "The password of %s is now encrypted with AES-GCM\n", envfile)
+		}
+	}
 	return &cfg, nil
 }
 
+// decryptPassword replaces the stored passwords (the user's and the client key's) with their clear text; a client key
+// passphrase that is not encrypted was written by hand, and is taken as is
+func decryptPassword(cfg *types.DBConfig, envfile string) *ce.CustomError {
+	for _, secret := range []*string{&cfg.Password, &cfg.SSLPassword} {
+		switch {
+		case *secret == "" || (secret == &cfg.SSLPassword && !strings.HasPrefix(*secret, sealedPrefix)):
+		case strings.HasPrefix(*secret, sealedPrefix):
+			key, err := loadKey()
+			if err != nil {
+				return err
+			}
+			plain, e := unseal(key, *secret)
+			if e != nil {
+				return &ce.CustomError{Code: 23, Title: "Cannot decrypt the password of " + envfile,
+					Message: "the password was encrypted with another key; set it again with pgtools env set"}
+			}
+			*secret = plain
+		default:
+			plain, e := legacyDecode(*secret)
+			if e != nil {
+				return &ce.CustomError{Code: 23, Title: "Cannot decode the password of " + envfile, Message: e.Error()}
+			}
+			*secret = plain
+		}
+	}
+	return nil
+}
+
+// saveEnvironment writes an environment file, readable by its owner only, with the passwords encrypted
+func saveEnvironment(path string, cfg types.DBConfig) *ce.CustomError {
+	var key []byte
+	if cfg.Password != "" || cfg.SSLPassword != "" {
+		var err *ce.CustomError
+		if key, err = loadKey(); err != nil {
+			return err
+		}
+	}
+	jStream, cerr := sealEnvironment(cfg, key)
+	if cerr != nil {
+		return cerr
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return &ce.CustomError{Code: 12, Title: fmt.Sprintf("Error writing the config file %s", path), Message: err.Error()}
+	}
+	if err := writeFileAtomic(path, jStream); err != nil {
+		return &ce.CustomError{Code: 12, Title: fmt.Sprintf("Error writing the config file %s", path), Message: err.Error()}
+	}
+	return nil
+}
+
+// sealEnvironment returns the JSON of an environment, with its passwords encrypted with key
+func sealEnvironment(cfg types.DBConfig, key []byte) ([]byte, *ce.CustomError) {
+	for _, secret := range []*string{&cfg.Password, &cfg.SSLPassword} {
+		if *secret != "" {
+			*secret = seal(key, *secret)
+		}
+	}
+	jStream, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, &ce.CustomError{Code: 11, Title: "Error marshalling JSON", Message: err.Error()}
+	}
+	return jStream, nil
+}
+
 // Prompts the user to create an environment environment file, and saves it
 func CreateConfig() *ce.CustomError {
 	var dbc types.DBConfig
@@ -49,7 +152,7 @@ func CreateConfig() *ce.CustomError {
 	dbc.Host = hf.GetStringValFromPrompt("PGSQL server hostname: ")
 	dbc.Port = hf.GetIntValFromPrompt("PGSQL server port: ")
 	dbc.User = hf.GetStringValFromPrompt("PGSQL server username: ")
-	dbc.Password = hf.EncodeString(hf.GetPassword("Please enter the user's password: ", types.DebugMode), "")
+	dbc.Password = hf.GetPassword("Please enter the user's password: ", types.DebugMode)
 	sslmode := hf.GetBoolValFromPrompt("PGSQL SSL mode (t/f): ")
 	if sslmode {
 		dbc.SSLMode = "prefer"
@@ -59,20 +162,13 @@ func CreateConfig() *ce.CustomError {
 	dbc.SSLRootCert = hf.GetStringValFromPrompt("[optional] Path to the CA certificate: ")
 	dbc.SSLclientCert = hf.GetStringValFromPrompt("[optional] Path to the client SSL certificate: ")
 	dbc.SSLclientKey = hf.GetStringValFromPrompt("[optional] Path to the client SSL key: ")
-	dbc.DefaultDB = hf.GetStringValFromPrompt("[optional] Default database to fall back on: ")
-
-	jStream, err := json.MarshalIndent(dbc, "", "  ")
-	if err != nil {
-		return &ce.CustomError{Code: 11, Title: "Error marshalling JSON", Message: err.Error()}
+	if dbc.SSLclientKey != "" && KeyEncrypted(dbc.SSLclientKey) {
+		dbc.SSLPassword = hf.GetPassword("Passphrase of the client SSL key: ", types.DebugMode)
 	}
-	types.EnvConfigFile = filepath.Join(os.Getenv("HOME"), ".config", "JFG", "pgtools", types.EnvConfigFile)
+	dbc.DefaultDB = hf.GetStringValFromPrompt("[optional] Default database to fall back on: ")
 
-	if err = os.WriteFile(types.EnvConfigFile, jStream, 0700); err != nil {
-		return &ce.CustomError{Code: 12,
-			Title:   fmt.Sprintf("Error writing the config file %s", types.EnvConfigFile),
-			Message: err.Error()}
-	}
-	return nil
+	types.EnvConfigFile = filepath.Join(configDir(), types.EnvConfigFile)
+	return saveEnvironment(types.EnvConfigFile, dbc)
 }
 
 // Remove the config file
@@ -80,7 +176,7 @@ func RemoveEnvFile(envfile string) *ce.CustomError {
 	if !strings.HasSuffix(envfile, ".json") {
 		envfile += ".json"
 	}
-	if err := os.Remove(filepath.Join(os.Getenv("HOME"), ".config", "JFG", "pgtools", envfile)); err != nil {
+	if err := os.Remove(filepath.Join(configDir(), envfile)); err != nil {
 		return &ce.CustomError{Code: 13, Title: "Error removing " + envfile, Message: err.Error()}
 	}
 
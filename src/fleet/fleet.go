@@ -0,0 +1,107 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/28 11:02
+// Original filename: src/fleet/fleet.go
+
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pgtools/environment"
+	"pgtools/logging"
+	"pgtools/types"
+	"sync"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// Task collects the rows of one environment
+type Task func(ctx context.Context, cfg *types.DBConfig) ([]table.Row, *ce.CustomError)
+
+// Result is what one environment returned
+type Result struct {
+	Env  string
+	Rows []table.Row
+	Err  *ce.CustomError
+}
+
+// Run resolves -e into environments and runs the task on each of them, at most types.FleetParallel at a time. The
+// results come back in the order of the environments; the failure of one does not stop the others.
+func Run(ctx context.Context, spec string, task Task) ([]Result, *ce.CustomError) {
+	names, err := environment.ResolveFleet(spec)
+	if err != nil {
+		return nil, err
+	}
+	limit := types.FleetParallel
+	if limit < 1 {
+		limit = 1
+	}
+	logging.Debugf("Running on %d environments, %d at a time", len(names), limit)
+
+	results := make([]Result, len(names))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i].Env = name
+			if ctx.Err() != nil {
+				results[i].Err = &ce.CustomError{Code: 64, Title: "Cancelled", Message: ctx.Err().Error()}
+				return
+			}
+			cfg, err := environment.LoadFleetConfig(name)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Rows, results[i].Err = task(ctx, cfg)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// Render prints the rows of every environment in one table, behind an ENV column, then the failures on stderr; it
+// returns an error when an environment failed, so that scripts can tell
+func Render(header table.Row, results []Result) *ce.CustomError {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(append(table.Row{"ENV"}, header...))
+	t.SetStyle(table.StyleBold)
+	t.Style().Format.Header = text.FormatDefault
+	t.Style().Color.Header = text.Colors{text.Bold}
+
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Env)
+			continue
+		}
+		for _, row := range r.Rows {
+			t.AppendRow(append(table.Row{r.Env}, row...))
+		}
+	}
+	if t.Length() > 0 {
+		t.Render()
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", hf.Red(r.Env), r.Err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return &ce.CustomError{Code: 63, Title: "Fleet command failed",
+			Message: fmt.Sprintf("%d of %d environments failed: %v", len(failed), len(results), failed)}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 14:20
+// Original filename: src/migrate/files.go
+
+package migrate
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// 0001_create_users.sql or 0001_create_users.up.sql, with an optional 0001_create_users.down.sql
+var fileRx = regexp.MustCompile(`^([0-9]+)(?:[_-](.*?))?(?:\.(up|down))?\.sql$`)
+
+// A file containing this line runs outside of a transaction, for CREATE INDEX CONCURRENTLY and the like
+var noTransactionRx = regexp.MustCompile(`(?m)^\s*--\s*pgtools:no-transaction\s*$`)
+
+// Migration is one numbered migration of the directory
+type Migration struct {
+	Version  int64
+	Label    string // the version as written in the file name, 0001
+	Name     string
+	Up       string // file paths; Down may be empty
+	Down     string
+	Checksum string // of the up file
+}
+
+func (m *Migration) String() string {
+	if m.Name == "" {
+		return m.Label
+	}
+	return m.Label + " " + m.Name
+}
+
+// script is a migration file's content and whether it runs in a transaction
+type script struct {
+	path string
+	sql  []byte
+	inTx bool
+}
+
+func readScript(path string) (*script, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &script{path: path, sql: b, inTx: !noTransactionRx.Match(b)}, nil
+}
+
+// checksum ignores the line endings, so that a checkout with CRLFs does not look like an edit
+func checksum(b []byte) string {
+	sum := sha256.Sum256(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadMigrations reads the migration directory, sorted by version
+func loadMigrations(dir string) ([]*Migration, *ce.CustomError) {
+	invalid := func(format string, args ...any) ([]*Migration, *ce.CustomError) {
+		return nil, &ce.CustomError{Code: 790, Title: "Invalid migration directory", Message: fmt.Sprintf(format, args...)}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return invalid("%s", err.Error())
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := fileRx.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return invalid("%s: version out of range", e.Name())
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Label: m[1], Name: m[2]}
+			byVersion[version] = mig
+		}
+		if m[2] != mig.Name {
+			return invalid("version %s is used by two migrations: %q and %q", m[1], mig.Name, m[2])
+		}
+
+		path := filepath.Join(dir, e.Name())
+		slot := &mig.Up
+		if m[3] == "down" {
+			slot = &mig.Down
+		}
+		if *slot != "" {
+			return invalid("version %s has two %s files: %s and %s", m[1], direction(m[3]), filepath.Base(*slot), e.Name())
+		}
+		*slot = path
+	}
+
+	var migrations []*Migration
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return invalid("version %s has a down file but no up file", mig.Label)
+		}
+		b, err := os.ReadFile(mig.Up)
+		if err != nil {
+			return invalid("%s", err.Error())
+		}
+		mig.Checksum = checksum(b)
+		migrations = append(migrations, mig)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+func direction(d string) string {
+	if d == "down" {
+		return "down"
+	}
+	return "up"
+}
@@ -0,0 +1,134 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 14:50
+// Original filename: src/migrate/history.go
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"pgtools/shared"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// historyTable is created in the first schema of the search_path, and named with that schema from then on: the
+// migrations may change the search_path
+const historyTable = "pgtools_schema_migrations"
+
+const createHistory = `CREATE TABLE IF NOT EXISTS %s (
+	version     bigint PRIMARY KEY,
+	name        text NOT NULL,
+	checksum    text NOT NULL,
+	baseline    boolean NOT NULL DEFAULT false,
+	applied_at  timestamptz NOT NULL DEFAULT now(),
+	applied_by  text NOT NULL DEFAULT current_user,
+	duration_ms bigint
+)`
+
+// applied is a row of the history table
+type applied struct {
+	version   int64
+	name      string
+	checksum  string
+	baseline  bool
+	appliedAt time.Time
+}
+
+// lockKey is the advisory lock held while migrating; it is the same for every pgtools run against the database
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(historyTable))
+	return int64(h.Sum64())
+}
+
+// lock takes the session-level advisory lock, waiting for another run to finish if needed
+func lock(ctx context.Context, conn *pgx.Conn) *ce.CustomError {
+	var ok bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey()).Scan(&ok); err != nil {
+		return &ce.CustomError{Code: 791, Title: "Cannot take the migration lock", Message: err.Error()}
+	}
+	if ok {
+		return nil
+	}
+	fmt.Println("Another migration is running on this database; waiting for it to finish...")
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey()); err != nil {
+		return &ce.CustomError{Code: 791, Title: "Cannot take the migration lock", Message: err.Error()}
+	}
+	return nil
+}
+
+func unlock(ctx context.Context, conn *pgx.Conn) {
+	if !conn.IsClosed() {
+		conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", lockKey())
+	}
+}
+
+// loadHistory finds the history table in the search_path, creating it when create is set, and returns its qualified
+// name and its rows by version. Without create, a missing table is an empty history and an empty name.
+func loadHistory(ctx context.Context, conn *pgx.Conn, create bool) (string, map[int64]*applied, *ce.CustomError) {
+	failed := func(err error) (string, map[int64]*applied, *ce.CustomError) {
+		return "", nil, &ce.CustomError{Code: 791, Title: "Cannot read the migration history", Message: err.Error()}
+	}
+	var schema *string
+	err := conn.QueryRow(ctx, `SELECT n.nspname FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE c.oid = to_regclass($1)`, historyTable).Scan(&schema)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return failed(err)
+	}
+	history := map[int64]*applied{}
+	if schema == nil {
+		if !create {
+			return "", history, nil
+		}
+		if err := conn.QueryRow(ctx, "SELECT current_schema()").Scan(&schema); err != nil {
+			return failed(err)
+		}
+		if schema == nil {
+			return failed(errors.New("no schema of the search_path exists to create " + historyTable + " in"))
+		}
+	}
+	table := shared.QuoteQualifiedIdent(*schema, historyTable)
+	if create {
+		if _, err := conn.Exec(ctx, fmt.Sprintf(createHistory, table)); err != nil {
+			return failed(err)
+		}
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version, name, checksum, baseline, applied_at FROM "+table)
+	if err != nil {
+		return failed(err)
+	}
+	var a applied
+	_, err = pgx.ForEachRow(rows, []any{&a.version, &a.name, &a.checksum, &a.baseline, &a.appliedAt}, func() error {
+		row := a
+		history[a.version] = &row
+		return nil
+	})
+	if err != nil {
+		return failed(err)
+	}
+	return table, history, nil
+}
+
+// dbExec is a connection or a transaction
+type dbExec interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func record(ctx context.Context, db dbExec, table string, m *Migration, baseline bool, duration time.Duration) error {
+	_, err := db.Exec(ctx, "INSERT INTO "+table+" (version, name, checksum, baseline, duration_ms) VALUES ($1, $2, $3, $4, $5)",
+		m.Version, m.Name, m.Checksum, baseline, duration.Milliseconds())
+	return err
+}
+
+func forget(ctx context.Context, db dbExec, table string, version int64) error {
+	_, err := db.Exec(ctx, "DELETE FROM "+table+" WHERE version = $1", version)
+	return err
+}
@@ -0,0 +1,437 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 15:30
+// Original filename: src/migrate/migrate.go
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"pgtools/db"
+	"pgtools/logging"
+	"pgtools/query"
+	"pgtools/types"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// runner holds the migration lock and the state of the directory and of the history table
+type runner struct {
+	ctx        context.Context
+	conn       *pgx.Conn
+	migrations []*Migration
+	history    map[int64]*applied
+	table      string // the history table, qualified; empty when it does not exist
+	session    *query.Session
+	locked     bool
+}
+
+// open connects and loads the migrations and their history; the advisory lock is taken when locked is set, and the
+// history table is created when create is set
+func open(cfg *types.DBConfig, dbname string, locked, create bool) (*runner, *ce.CustomError) {
+	if err := query.CheckSafeDDL(&types.SafeDDL); err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(types.MigrateDir)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Connect(cfg, dbname)
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{ctx: context.Background(), conn: conn, migrations: migrations, locked: locked}
+	if locked {
+		if err := lock(r.ctx, conn); err != nil {
+			conn.Close(r.ctx)
+			return nil, err
+		}
+	}
+	if r.table, r.history, err = loadHistory(r.ctx, conn, create); err != nil {
+		r.close()
+		return nil, err
+	}
+	// the scripts run like those of pgtools sql -f, without printing their results
+	r.session = &query.Session{Conn: conn, Vars: query.Variables{}, Format: "table", Out: io.Discard}
+	if types.SafeDDL.Enabled {
+		r.session.SafeDDL = &types.SafeDDL
+	}
+	return r, nil
+}
+
+func (r *runner) close() {
+	if r.session != nil {
+		r.session.Close()
+	}
+	if r.locked {
+		unlock(r.ctx, r.conn)
+	}
+	r.conn.Close(r.ctx)
+}
+
+// Up applies the pending migrations in order; all of them when n is 0
+func Up(cfg *types.DBConfig, dbname string, n int) *ce.CustomError {
+	logging.Debugf("Entering function: migrate.Up(%s, %d)", dbname, n)
+	r, err := open(cfg, dbname, true, true)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if err := r.verify(); err != nil {
+		return err
+	}
+
+	pending, err := r.pending(math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+	for _, m := range pending {
+		if err := r.apply(m, true); err != nil {
+			return err
+		}
+	}
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+	}
+	r.summary()
+	return nil
+}
+
+// Down reverts the n most recent migrations
+func Down(cfg *types.DBConfig, dbname string, n int) *ce.CustomError {
+	logging.Debugf("Entering function: migrate.Down(%s, %d)", dbname, n)
+	r, err := open(cfg, dbname, true, false)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if err := r.verify(); err != nil {
+		return err
+	}
+
+	versions := r.appliedVersions()
+	if n < len(versions) {
+		versions = versions[len(versions)-n:]
+	}
+	slices.Reverse(versions)
+	if err := r.revert(versions); err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Println("Nothing to revert")
+	}
+	r.summary()
+	return nil
+}
+
+// Goto migrates up or down to the given version; version 0 reverts everything
+func Goto(cfg *types.DBConfig, dbname string, version int64) *ce.CustomError {
+	logging.Debugf("Entering function: migrate.Goto(%s, %d)", dbname, version)
+	r, err := open(cfg, dbname, true, true)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if version != 0 && r.find(version) == nil && r.history[version] == nil {
+		return &ce.CustomError{Code: 790, Title: "Unknown version", Message: fmt.Sprintf("there is no migration %d in %s", version, types.MigrateDir)}
+	}
+	if err := r.verify(); err != nil {
+		return err
+	}
+
+	var above []int64
+	for _, v := range r.appliedVersions() {
+		if v > version {
+			above = append(above, v)
+		}
+	}
+	slices.Reverse(above)
+	pending, err := r.pending(version)
+	if err != nil {
+		return err
+	}
+	if err := r.revert(above); err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if err := r.apply(m, true); err != nil {
+			return err
+		}
+	}
+	if len(above)+len(pending) == 0 {
+		fmt.Println("Nothing to do")
+	}
+	r.summary()
+	return nil
+}
+
+// Baseline records every migration up to version as applied without running them, for a database whose schema
+// predates the migrations
+func Baseline(cfg *types.DBConfig, dbname string, version int64) *ce.CustomError {
+	logging.Debugf("Entering function: migrate.Baseline(%s, %d)", dbname, version)
+	r, err := open(cfg, dbname, true, true)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if r.find(version) == nil {
+		return &ce.CustomError{Code: 790, Title: "Unknown version", Message: fmt.Sprintf("there is no migration %d in %s", version, types.MigrateDir)}
+	}
+
+	count := 0
+	for _, m := range r.migrations {
+		if m.Version > version || r.history[m.Version] != nil {
+			continue
+		}
+		if e := record(r.ctx, r.conn, r.table, m, true, 0); e != nil {
+			return &ce.CustomError{Code: 791, Title: "Cannot record the baseline", Message: e.Error()}
+		}
+		count++
+	}
+	fmt.Printf("Baselined %d migration(s) up to %s\n", count, r.find(version))
+	return nil
+}
+
+// Status lists the migrations of the directory and of the history table with their state
+func Status(cfg *types.DBConfig, dbname string) *ce.CustomError {
+	logging.Debugf("Entering function: migrate.Status(%s)", dbname)
+	r, err := open(cfg, dbname, false, false)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	latest := r.latest()
+	versions := r.appliedVersions()
+	for _, m := range r.migrations {
+		if r.history[m.Version] == nil {
+			versions = append(versions, m.Version)
+		}
+	}
+	slices.Sort(versions)
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(table.Row{"Version", "Name", "Status", "Applied at"})
+	for _, v := range versions {
+		m, a := r.find(v), r.history[v]
+		var label, name, status, at string
+		if m != nil {
+			label, name = m.Label, m.Name
+		}
+		switch {
+		case a == nil && v < latest:
+			status = hf.Yellow("pending (out of order)")
+		case a == nil:
+			status = hf.Yellow("pending")
+		case m == nil:
+			label, name, status = fmt.Sprint(v), a.name, hf.Red("applied, file missing")
+		case a.checksum != m.Checksum:
+			status = hf.Red("modified since applied")
+		case a.baseline:
+			status = hf.Green("baseline")
+		default:
+			status = hf.Green("applied")
+		}
+		if a != nil {
+			at = a.appliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		tw.AppendRow(table.Row{label, name, status, at})
+	}
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Render()
+	return nil
+}
+
+// verify refuses to migrate when an applied migration's file has been edited since
+func (r *runner) verify() *ce.CustomError {
+	var modified []string
+	for _, m := range r.migrations {
+		if a := r.history[m.Version]; a != nil && a.checksum != m.Checksum {
+			modified = append(modified, fmt.Sprintf("%s (%s)", m, filepath.Base(m.Up)))
+		}
+	}
+	if len(modified) > 0 {
+		return &ce.CustomError{Code: 792, Title: "Applied migrations were modified",
+			Message: fmt.Sprintf("these files changed after they were applied; revert the edits and add a new migration instead:\n  %s", strings.Join(modified, "\n  "))}
+	}
+	return nil
+}
+
+// pending lists the migrations to apply up to version. Migrations older than the latest applied one were most likely
+// merged late, and are only applied with --out-of-order.
+func (r *runner) pending(version int64) ([]*Migration, *ce.CustomError) {
+	var latest int64
+	for v := range r.history {
+		if v <= version {
+			latest = max(latest, v)
+		}
+	}
+	var pending []*Migration
+	for _, m := range r.migrations {
+		if r.history[m.Version] != nil || m.Version > version {
+			continue
+		}
+		if m.Version < latest && !types.MigrateOutOfOrder {
+			return nil, &ce.CustomError{Code: 792, Title: "Out of order migration",
+				Message: fmt.Sprintf("%s is older than the latest applied migration (%d); use --out-of-order to apply it anyway", m, latest)}
+		}
+		pending = append(pending, m)
+	}
+	return pending, nil
+}
+
+// revert runs the down scripts of the given applied versions, in that order
+func (r *runner) revert(versions []int64) *ce.CustomError {
+	for _, v := range versions {
+		m, a := r.find(v), r.history[v]
+		switch {
+		case a.baseline:
+			return &ce.CustomError{Code: 794, Title: "Cannot revert a baseline", Message: fmt.Sprintf("%d was baselined, not applied by pgtools", v)}
+		case m == nil:
+			return &ce.CustomError{Code: 794, Title: "Missing migration", Message: fmt.Sprintf("%d %s is applied but its file is no longer in %s", v, a.name, types.MigrateDir)}
+		case m.Down == "":
+			return &ce.CustomError{Code: 794, Title: "No down migration", Message: fmt.Sprintf("%s has no .down.sql file", m)}
+		}
+		if err := r.apply(m, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply runs a migration's up or down script and records it, in one transaction unless the file says otherwise.
+// With --safe-ddl, a transaction whose DDL did not get its locks is rolled back and run again after a pause, so that
+// the locks it already held are not kept while it waits.
+func (r *runner) apply(m *Migration, up bool) *ce.CustomError {
+	path, verb := m.Up, "Applying"
+	if !up {
+		path, verb = m.Down, "Reverting"
+	}
+	s, e := readScript(path)
+	if e != nil {
+		return &ce.CustomError{Code: 790, Title: "Cannot read the migration", Message: e.Error()}
+	}
+	failed := func(format string, args ...any) *ce.CustomError {
+		return &ce.CustomError{Code: 793, Title: "Migration failed", Message: fmt.Sprintf("%s (%s): ", m, filepath.Base(path)) + fmt.Sprintf(format, args...)}
+	}
+
+	fmt.Printf("%s %s... ", verb, m)
+	start := time.Now()
+	attempts := 1
+	if s.inTx && r.session.SafeDDL != nil {
+		attempts += r.session.SafeDDL.Retries
+	}
+	for attempt := 1; ; attempt++ {
+		err := r.applyOnce(m, up, s, start)
+		if err == nil {
+			break
+		}
+		if err.Code != query.CodeLockTimeout || attempt == attempts {
+			fmt.Println(hf.Red("failed"))
+			if !s.inTx {
+				return failed("%s\nThe file runs outside of a transaction: the statements before the failure were not rolled back", err.Message)
+			}
+			if err.Code == query.CodeLockTimeout {
+				return failed("gave up after %d attempts: %s", attempts, err.Message)
+			}
+			return failed("%s", err.Message)
+		}
+		delay := query.Backoff(attempt)
+		fmt.Fprintf(os.Stderr, "attempt %d/%d: rolled back, retrying in %s\n", attempt, attempts, delay.Round(10*time.Millisecond))
+		time.Sleep(delay)
+	}
+
+	if up {
+		r.history[m.Version] = &applied{version: m.Version, name: m.Name, checksum: m.Checksum, appliedAt: time.Now()}
+	} else {
+		delete(r.history, m.Version)
+	}
+	fmt.Printf("%s (%s)\n", hf.Green("done"), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// applyOnce runs a script and records it, committing when it runs in a transaction
+func (r *runner) applyOnce(m *Migration, up bool, s *script, start time.Time) *ce.CustomError {
+	var history dbExec = r.conn
+	if s.inTx {
+		tx, e := r.conn.Begin(r.ctx)
+		if e != nil {
+			return &ce.CustomError{Code: 793, Message: e.Error()}
+		}
+		defer tx.Rollback(r.ctx)
+		history = tx
+	}
+
+	if err := r.session.RunScript(r.ctx, bytes.NewReader(s.sql), false); err != nil {
+		return err
+	}
+	var e error
+	if up {
+		m.Checksum = checksum(s.sql)
+		e = record(r.ctx, history, r.table, m, false, time.Since(start))
+	} else {
+		e = forget(r.ctx, history, r.table, m.Version)
+	}
+	if tx, ok := history.(pgx.Tx); ok && e == nil {
+		e = tx.Commit(r.ctx)
+	}
+	if e != nil {
+		return &ce.CustomError{Code: 793, Message: "cannot record the migration: " + e.Error()}
+	}
+	return nil
+}
+
+func (r *runner) summary() {
+	if v := r.latest(); v == 0 {
+		fmt.Println("No migration is applied")
+	} else if m := r.find(v); m != nil {
+		fmt.Printf("The database is at version %s\n", m)
+	} else {
+		fmt.Printf("The database is at version %d\n", v)
+	}
+}
+
+func (r *runner) find(version int64) *Migration {
+	for _, m := range r.migrations {
+		if m.Version == version {
+			return m
+		}
+	}
+	return nil
+}
+
+// appliedVersions are the versions of the history table, ascending
+func (r *runner) appliedVersions() []int64 {
+	var versions []int64
+	for v := range r.history {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+func (r *runner) latest() int64 {
+	var latest int64
+	for v := range r.history {
+		latest = max(latest, v)
+	}
+	return latest
+}
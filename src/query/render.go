@@ -0,0 +1,230 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 15:10
+// Original filename: src/query/render.go
+
+package query
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// Formats are the output formats of result sets
+var Formats = []string{"table", "csv", "tsv", "json", "jsonl", "markdown"}
+
+var numericOIDs = []uint32{pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID, pgtype.OIDOID, pgtype.Float4OID, pgtype.Float8OID, pgtype.NumericOID}
+
+// ResultSet holds the rows of one statement in the server's text format; a nil cell is a NULL
+type ResultSet struct {
+	Columns  []string
+	TypeOIDs []uint32
+	Rows     [][]*string
+}
+
+// CheckFormat validates an output format name
+func CheckFormat(format string) *ce.CustomError {
+	if !slices.Contains(Formats, format) {
+		return &ce.CustomError{Code: 750, Title: "Invalid output format", Message: fmt.Sprintf("%q: expected one of %s", format, strings.Join(Formats, ", "))}
+	}
+	return nil
+}
+
+// Render writes the result set in the given format; null is what a NULL looks like in the text formats
+func (r *ResultSet) Render(w io.Writer, format, null string) error {
+	switch format {
+	case "csv":
+		return r.renderCSV(w, null)
+	case "tsv":
+		return r.renderTSV(w, null)
+	case "json":
+		return r.renderJSON(w)
+	case "jsonl":
+		return r.renderJSONLines(w)
+	case "markdown":
+		fmt.Fprintln(w, r.tableWriter(null).RenderMarkdown())
+		return nil
+	}
+	fmt.Fprintln(w, r.Table(null))
+	if len(r.Rows) == 1 {
+		fmt.Fprintln(w, "(1 row)")
+	} else {
+		fmt.Fprintf(w, "(%d rows)\n", len(r.Rows))
+	}
+	return nil
+}
+
+// RenderExpanded writes each row as a block of "column | value" lines, as psql's \x does
+func (r *ResultSet) RenderExpanded(w io.Writer, null string) error {
+	if len(r.Rows) == 0 {
+		_, err := fmt.Fprintln(w, "(0 rows)")
+		return err
+	}
+	width := 0
+	for _, c := range r.Columns {
+		width = max(width, text.StringWidthWithoutEscSequences(c))
+	}
+	var sb strings.Builder
+	for n, row := range r.Rows {
+		fmt.Fprintf(&sb, "-[ RECORD %d ]%s\n", n+1, strings.Repeat("-", max(0, width-8)))
+		for i, value := range r.cells(row, null) {
+			pad := strings.Repeat(" ", width-text.StringWidthWithoutEscSequences(r.Columns[i]))
+			lines := strings.Split(value, "\n")
+			fmt.Fprintf(&sb, "%s%s | %s\n", r.Columns[i], pad, lines[0])
+			for _, l := range lines[1:] {
+				fmt.Fprintf(&sb, "%s | %s\n", strings.Repeat(" ", width), l)
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Table returns the rows as a table, without the row count
+func (r *ResultSet) Table(null string) string {
+	return r.tableWriter(null).Render()
+}
+
+func (r *ResultSet) cells(row []*string, null string) []string {
+	out := make([]string, len(row))
+	for i, c := range row {
+		if c == nil {
+			out[i] = null
+		} else {
+			out[i] = *c
+		}
+	}
+	return out
+}
+
+func (r *ResultSet) tableWriter(null string) table.Writer {
+	tw := table.NewWriter()
+	header := table.Row{}
+	for _, c := range r.Columns {
+		header = append(header, c)
+	}
+	tw.AppendHeader(header)
+	for _, row := range r.Rows {
+		tr := table.Row{}
+		for _, c := range r.cells(row, null) {
+			tr = append(tr, c)
+		}
+		tw.AppendRow(tr)
+	}
+
+	var configs []table.ColumnConfig
+	for i, oid := range r.TypeOIDs {
+		if slices.Contains(numericOIDs, oid) {
+			configs = append(configs, table.ColumnConfig{Number: i + 1, Align: text.AlignRight})
+		}
+	}
+	tw.SetColumnConfigs(configs)
+	tw.SetStyle(table.StyleLight)
+	tw.Style().Format.Header = text.FormatDefault
+	return tw
+}
+
+func (r *ResultSet) renderCSV(w io.Writer, null string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(r.Columns); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		if err := cw.Write(r.cells(row, null)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tsvEscaper escapes values the way COPY ... (FORMAT text) does
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (r *ResultSet) renderTSV(w io.Writer, null string) error {
+	lines := []string{strings.Join(r.Columns, "\t")}
+	for _, row := range r.Rows {
+		fields := make([]string, len(row))
+		for i, c := range row {
+			if c == nil {
+				fields[i] = null
+			} else {
+				fields[i] = tsvEscaper.Replace(*c)
+			}
+		}
+		lines = append(lines, strings.Join(fields, "\t"))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func (r *ResultSet) renderJSON(w io.Writer) error {
+	if len(r.Rows) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString("[\n")
+	for i, row := range r.Rows {
+		sb.WriteString("  ")
+		sb.Write(r.object(row))
+		if i < len(r.Rows)-1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("]")
+	_, err := fmt.Fprintln(w, sb.String())
+	return err
+}
+
+func (r *ResultSet) renderJSONLines(w io.Writer) error {
+	for _, row := range r.Rows {
+		if _, err := fmt.Fprintln(w, string(r.object(row))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// object encodes a row as a JSON object, keeping the column order
+func (r *ResultSet) object(row []*string) []byte {
+	buf := []byte{'{'}
+	for i, c := range row {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(r.Columns[i])
+		buf = append(buf, name...)
+		buf = append(buf, ':')
+		buf = append(buf, jsonValue(r.TypeOIDs[i], c)...)
+	}
+	return append(buf, '}')
+}
+
+// jsonValue maps a text-format value to JSON: booleans, numbers and json documents keep their type, anything else
+// (and NaN or Infinity) becomes a string
+func jsonValue(oid uint32, v *string) []byte {
+	if v == nil {
+		return []byte("null")
+	}
+	switch {
+	case oid == pgtype.BoolOID:
+		return []byte(fmt.Sprintf("%t", *v == "t"))
+	case slices.Contains(numericOIDs, oid), oid == pgtype.JSONOID, oid == pgtype.JSONBOID:
+		if json.Valid([]byte(*v)) {
+			return []byte(*v)
+		}
+	}
+	s, _ := json.Marshal(*v)
+	return s
+}
@@ -0,0 +1,311 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 15:45
+// Original filename: src/query/run.go
+
+package query
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"pgtools/db"
+	"pgtools/logging"
+	"pgtools/types"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+var copyToStdoutRx = regexp.MustCompile(`(?is)^COPY\s.+\sTO\s+stdout\b`)
+
+// CodeTxAborted is the error code of a statement the server aborted to resolve a serialization failure or a deadlock;
+// with --tx the whole script is run again, up to txAttempts times
+const CodeTxAborted = 758
+
+const txAttempts = 5
+
+// Session runs statements on one connection and prints their results
+type Session struct {
+	Conn     *pgx.Conn
+	Vars     Variables
+	Format   string
+	Null     string
+	Expanded bool // one "column | value" block per row, in the table format
+	Timing   bool // print how long each statement took
+	Out      io.Writer
+	SafeDDL  *types.SafeDDLOptions // DDL waits at most lock_timeout for its locks and is retried; nil when off
+
+	monitor *pgx.Conn // finds the sessions blocking a DDL statement
+}
+
+// RunSQL runs the statements given on the command line, or those of types.SQLFile ("-" is stdin), against dbname.
+func RunSQL(cfg *types.DBConfig, dbname, statements string) *ce.CustomError {
+	logging.Debugf("Entering function: RunSQL(%s)", dbname)
+	if err := CheckFormat(types.SQLFormat); err != nil {
+		return err
+	}
+	vars, err := ParseVariables(types.SQLVars)
+	if err != nil {
+		return err
+	}
+	if err := CheckSafeDDL(&types.SafeDDL); err != nil {
+		return err
+	}
+
+	var input io.Reader = strings.NewReader(statements)
+	switch types.SQLFile {
+	case "":
+	case "-":
+		input = os.Stdin
+	default:
+		f, e := os.Open(types.SQLFile)
+		if e != nil {
+			return &ce.CustomError{Code: 751, Title: "Unable to read the script", Message: e.Error()}
+		}
+		defer f.Close()
+		input = f
+	}
+
+	conn, err := db.Connect(cfg, dbname)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	s := &Session{Conn: conn, Vars: vars, Format: types.SQLFormat, Null: types.SQLNull, Out: os.Stdout}
+	if types.SafeDDL.Enabled {
+		s.SafeDDL = &types.SafeDDL
+	}
+	defer s.Close()
+	return s.RunScript(context.Background(), input, types.SQLTransaction)
+}
+
+// RunScript runs every statement of the script, stopping at the first error. With inTx the whole script runs in
+// one transaction, which is rolled back when a statement fails, and run again from the start when the server aborted
+// it over a serialization failure or a deadlock.
+func (s *Session) RunScript(ctx context.Context, r io.Reader, inTx bool) *ce.CustomError {
+	if !inTx {
+		return s.runItems(ctx, db.NewSQLSplitter(r))
+	}
+
+	// the script is kept in memory to be replayed, along with the variables it started with
+	script, e := io.ReadAll(r)
+	if e != nil {
+		return &ce.CustomError{Code: 751, Title: "Unable to read the script", Message: e.Error()}
+	}
+	vars := maps.Clone(s.Vars)
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, script)
+		if err == nil || err.Code != CodeTxAborted {
+			return err
+		}
+		if attempt == txAttempts {
+			err.Message = fmt.Sprintf("gave up after %d attempts: %s", txAttempts, err.Message)
+			return err
+		}
+		delay := Backoff(attempt)
+		fmt.Fprintf(os.Stderr, "attempt %d/%d: %s, retrying the transaction in %s\n", attempt, txAttempts, strings.ToLower(err.Title), delay.Round(10*time.Millisecond))
+		s.Vars = maps.Clone(vars)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return &ce.CustomError{Code: 754, Title: "Unable to start the transaction", Message: ctx.Err().Error()}
+		}
+	}
+}
+
+// runTx runs the script in one transaction, rolling it back when a statement fails
+func (s *Session) runTx(ctx context.Context, script []byte) *ce.CustomError {
+	if _, e := s.Conn.Exec(ctx, "BEGIN"); e != nil {
+		return &ce.CustomError{Code: 754, Title: "Unable to start the transaction", Message: e.Error()}
+	}
+	if err := s.runItems(ctx, db.NewSQLSplitter(bytes.NewReader(script))); err != nil {
+		if !s.Conn.IsClosed() {
+			if _, e := s.Conn.Exec(ctx, "ROLLBACK"); e != nil {
+				logging.Errorf("Rollback failed: %s", e.Error())
+			} else {
+				s.status("ROLLBACK")
+			}
+		}
+		return err
+	}
+	// a serializable transaction may only find out at COMMIT that it conflicted with another one
+	if _, e := s.Conn.Exec(ctx, "COMMIT"); e != nil {
+		if isTxAborted(e) {
+			return &ce.CustomError{Code: CodeTxAborted, Title: "Transaction aborted", Message: e.Error()}
+		}
+		return &ce.CustomError{Code: 754, Title: "Unable to commit the transaction", Message: e.Error()}
+	}
+	s.status("COMMIT")
+	return nil
+}
+
+func (s *Session) runItems(ctx context.Context, splitter *db.SQLSplitter) *ce.CustomError {
+	for {
+		item, e := splitter.Next()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return &ce.CustomError{Code: 751, Title: "Unable to read the script", Message: fmt.Sprintf("line %d: %s", splitter.Line(), e.Error())}
+		}
+
+		if item.Meta {
+			if err := s.Meta(item); err != nil {
+				return err
+			}
+			continue
+		}
+
+		text := s.Vars.Substitute(item.Text)
+		var err *ce.CustomError
+		if db.IsCopyFromStdin(text) {
+			err = s.copyFrom(ctx, text, splitter.CopyData())
+		} else {
+			err = s.Exec(ctx, text)
+		}
+		if err != nil {
+			err.Message = fmt.Sprintf("line %d: %s", item.Line, err.Message)
+			return err
+		}
+	}
+}
+
+// Meta handles the psql meta-commands that make sense in a script: \set, \unset and \echo
+func (s *Session) Meta(item *db.SQLItem) *ce.CustomError {
+	fields := strings.Fields(item.Text)
+	switch fields[0] {
+	case `\set`:
+		if len(fields) < 2 {
+			return &ce.CustomError{Code: 753, Title: "Invalid meta-command", Message: fmt.Sprintf("line %d: \\set needs a variable name", item.Line)}
+		}
+		value := strings.Join(fields[2:], " ")
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+		s.Vars[fields[1]] = value
+	case `\unset`:
+		for _, name := range fields[1:] {
+			delete(s.Vars, name)
+		}
+	case `\echo`:
+		fmt.Fprintln(s.Out, s.Vars.Substitute(strings.Join(fields[1:], " ")))
+	default:
+		return &ce.CustomError{Code: 753, Title: "Unsupported meta-command", Message: fmt.Sprintf("line %d: %s", item.Line, fields[0])}
+	}
+	return nil
+}
+
+// Exec runs one statement, printing its rows or, for statements that return none, its command tag
+func (s *Session) Exec(ctx context.Context, text string) *ce.CustomError {
+	started := time.Now()
+	defer func() {
+		if s.Timing {
+			fmt.Fprintf(s.Out, "Time: %.3f ms\n", float64(time.Since(started).Microseconds())/1000)
+		}
+	}()
+
+	if copyToStdoutRx.MatchString(text) {
+		tag, e := s.Conn.PgConn().CopyTo(ctx, s.Out, text)
+		if e != nil {
+			return statementFailed(text, e)
+		}
+		s.status(tag.String())
+		return nil
+	}
+
+	query := s.Query
+	if s.SafeDDL != nil && ddlRx.MatchString(text) {
+		query = s.safeQuery
+	}
+	rs, tag, e := query(ctx, text)
+	if e != nil && isLockTimeout(e) {
+		return &ce.CustomError{Code: CodeLockTimeout, Title: "Lock not obtained", Message: fmt.Sprintf("%s\n%s", e.Error(), text)}
+	}
+	if e != nil {
+		return statementFailed(text, e)
+	}
+	if len(rs.Columns) == 0 {
+		s.status(tag.String())
+		return nil
+	}
+	if s.Expanded && s.Format == "table" {
+		e = rs.RenderExpanded(s.Out, s.Null)
+	} else {
+		e = rs.Render(s.Out, s.Format, s.Null)
+	}
+	if e != nil {
+		return &ce.CustomError{Code: 755, Title: "Unable to write the results", Message: e.Error()}
+	}
+	return nil
+}
+
+// Query runs one statement and returns its rows; a statement that returns no rows has no columns.
+func (s *Session) Query(ctx context.Context, text string) (*ResultSet, pgconn.CommandTag, error) {
+	// The simple protocol returns every value in its text form, which is what psql prints
+	rows, e := s.Conn.Query(ctx, text, pgx.QueryExecModeSimpleProtocol)
+	if e != nil {
+		return nil, pgconn.CommandTag{}, e
+	}
+	defer rows.Close()
+
+	rs := &ResultSet{}
+	for _, fd := range rows.FieldDescriptions() {
+		rs.Columns = append(rs.Columns, fd.Name)
+		rs.TypeOIDs = append(rs.TypeOIDs, fd.DataTypeOID)
+	}
+	for rows.Next() {
+		raw := rows.RawValues()
+		row := make([]*string, len(raw))
+		for i, v := range raw {
+			if v != nil {
+				str := string(v)
+				row[i] = &str
+			}
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+	rows.Close()
+	return rs, rows.CommandTag(), rows.Err()
+}
+
+// isTxAborted tells serialization failures and deadlocks, after which the transaction can only be run again
+func isTxAborted(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+func statementFailed(text string, e error) *ce.CustomError {
+	if isTxAborted(e) {
+		return &ce.CustomError{Code: CodeTxAborted, Title: "Transaction aborted", Message: fmt.Sprintf("%s\n%s", e.Error(), text)}
+	}
+	return &ce.CustomError{Code: 752, Title: "Statement failed", Message: fmt.Sprintf("%s\n%s", e.Error(), text)}
+}
+
+// copyFrom streams the inline data of a COPY ... FROM stdin to the server
+func (s *Session) copyFrom(ctx context.Context, text string, data io.Reader) *ce.CustomError {
+	tag, e := s.Conn.PgConn().CopyFrom(ctx, data, text)
+	if e != nil {
+		return statementFailed(text, e)
+	}
+	s.status(tag.String())
+	return nil
+}
+
+// status prints a command tag; it goes to stderr when the output is meant for another program
+func (s *Session) status(tag string) {
+	if s.Format == "table" {
+		fmt.Fprintln(s.Out, tag)
+	} else {
+		fmt.Fprintln(os.Stderr, tag)
+	}
+}
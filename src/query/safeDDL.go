@@ -0,0 +1,208 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/24 09:30
+// Original filename: src/query/safeDDL.go
+
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"pgtools/logging"
+	"pgtools/types"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// Statements that take strong locks; with --safe-ddl they wait at most lock_timeout for them, and are retried
+var ddlRx = regexp.MustCompile(`(?is)^\s*(ALTER|CREATE|DROP|TRUNCATE|REINDEX|CLUSTER|LOCK|COMMENT|GRANT|REVOKE|VACUUM|REFRESH)\b`)
+
+const (
+	retryBase = 500 * time.Millisecond
+	retryMax  = 30 * time.Second
+)
+
+// CodeLockTimeout is the error code of a statement that did not get its locks within --lock-timeout
+const CodeLockTimeout = 757
+
+// blocker is a session holding a lock the statement waited for
+type blocker struct {
+	pid                    int32
+	user, app, state, xact string
+	query                  string
+}
+
+func (b blocker) String() string {
+	s := fmt.Sprintf("pid %d (%s", b.pid, b.user)
+	if b.app != "" {
+		s += ", " + b.app
+	}
+	if b.state != "" {
+		s += ", " + b.state
+	}
+	if b.xact != "" {
+		s += ", transaction open for " + b.xact
+	}
+	return s + "): " + b.query
+}
+
+// CheckSafeDDL validates the --safe-ddl flags
+func CheckSafeDDL(o *types.SafeDDLOptions) *ce.CustomError {
+	if o.Enabled && (o.LockTimeout < time.Millisecond || o.Retries < 0) {
+		return &ce.CustomError{Code: 756, Title: "Invalid --safe-ddl option", Message: "--lock-timeout must be at least 1ms and --ddl-retries cannot be negative"}
+	}
+	return nil
+}
+
+// safeQuery runs a DDL statement with a short lock_timeout, so that it does not queue behind a long transaction
+// while every later query queues behind it. A statement that times out is retried after a jittered, growing pause,
+// and the sessions that held the lock are reported after each failed attempt. Inside a transaction it is not retried:
+// the locks taken by the earlier statements would be held through every pause, blocking others in turn, so the
+// statement fails at once and the caller retries the whole transaction (as migrate does).
+func (s *Session) safeQuery(ctx context.Context, text string) (*ResultSet, pgconn.CommandTag, error) {
+	var previous string
+	if err := s.Conn.QueryRow(ctx, "SHOW lock_timeout").Scan(&previous); err != nil {
+		return nil, pgconn.CommandTag{}, err
+	}
+	if _, err := s.Conn.Exec(ctx, "SELECT set_config('lock_timeout', $1, false)", fmt.Sprintf("%dms", s.SafeDDL.LockTimeout.Milliseconds())); err != nil {
+		return nil, pgconn.CommandTag{}, err
+	}
+	defer func() {
+		if !s.Conn.IsClosed() && s.Conn.PgConn().TxStatus() != 'E' {
+			s.Conn.Exec(ctx, "SELECT set_config('lock_timeout', $1, false)", previous)
+		}
+	}()
+
+	inTx := s.Conn.PgConn().TxStatus() == 'T'
+	attempts := s.SafeDDL.Retries + 1
+	if inTx {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		stop := s.watchBlockers(ctx)
+		rs, tag, err := s.Query(ctx, text)
+		blockers := stop()
+		if err == nil || !isLockTimeout(err) {
+			return rs, tag, err
+		}
+
+		report := fmt.Sprintf("attempt %d/%d: lock not obtained within %s", attempt, attempts, s.SafeDDL.LockTimeout)
+		if inTx {
+			fmt.Fprintf(os.Stderr, "lock not obtained within %s inside a transaction\n", s.SafeDDL.LockTimeout)
+			printBlockers(blockers)
+			return nil, pgconn.CommandTag{}, err
+		}
+		if attempt == attempts {
+			fmt.Fprintln(os.Stderr, report)
+			printBlockers(blockers)
+			return nil, pgconn.CommandTag{}, fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+		}
+		delay := Backoff(attempt)
+		fmt.Fprintf(os.Stderr, "%s, retrying in %s\n", report, delay.Round(10*time.Millisecond))
+		printBlockers(blockers)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, pgconn.CommandTag{}, ctx.Err()
+		}
+	}
+}
+
+// Backoff doubles the pause at every attempt, up to retryMax, and picks it at random in its upper half so that
+// several deployments waiting on the same table do not retry in lockstep
+func Backoff(attempt int) time.Duration {
+	delay := min(retryBase<<(attempt-1), retryMax)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func isLockTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03"
+}
+
+func printBlockers(blockers []blocker) {
+	if blockers == nil {
+		fmt.Fprintln(os.Stderr, "  the blocking sessions could not be identified")
+	}
+	for _, b := range blockers {
+		fmt.Fprintf(os.Stderr, "  blocked by %s\n", b)
+	}
+}
+
+// watchBlockers polls pg_blocking_pids from a second connection while the statement waits: once the lock_timeout
+// fires the statement no longer waits, and the blockers cannot be found afterwards. stop returns the last sessions
+// seen blocking it.
+func (s *Session) watchBlockers(ctx context.Context) (stop func() []blocker) {
+	done := make(chan struct{})
+	result := make(chan []blocker, 1)
+	pid := s.Conn.PgConn().PID()
+	timeout := s.SafeDDL.LockTimeout
+
+	go func() {
+		var last []blocker
+		defer func() { result <- last }()
+		// a statement that gets its locks at once does not need a look
+		wait := timeout / 2
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+			if b, err := s.blockers(ctx, pid); err != nil {
+				logging.Debugf("Cannot list the blocking sessions: %s", err.Error())
+			} else if len(b) > 0 {
+				last = b
+			}
+			wait = max(timeout/4, 50*time.Millisecond)
+		}
+	}()
+
+	return func() []blocker {
+		close(done)
+		return <-result
+	}
+}
+
+func (s *Session) blockers(ctx context.Context, pid uint32) ([]blocker, error) {
+	if s.monitor == nil {
+		conn, err := pgx.ConnectConfig(ctx, s.Conn.Config())
+		if err != nil {
+			return nil, err
+		}
+		s.monitor = conn
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	rows, err := s.monitor.Query(ctx, `SELECT pid, coalesce(usename, ''), coalesce(application_name, ''), coalesce(state, ''),
+		coalesce(date_trunc('second', now() - xact_start)::text, ''), coalesce(query, '')
+		FROM pg_stat_activity WHERE pid = ANY (pg_blocking_pids($1)) ORDER BY pid`, int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (blocker, error) {
+		var b blocker
+		err := row.Scan(&b.pid, &b.user, &b.app, &b.state, &b.xact, &b.query)
+		b.query = strings.Join(strings.Fields(b.query), " ")
+		if q := []rune(b.query); len(q) > 100 {
+			b.query = string(q[:100]) + "..."
+		}
+		return b, err
+	})
+}
+
+// Close closes the connection used to find the blocking sessions
+func (s *Session) Close() {
+	if s.monitor != nil {
+		s.monitor.Close(context.Background())
+		s.monitor = nil
+	}
+}
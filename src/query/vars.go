@@ -0,0 +1,126 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 14:45
+// Original filename: src/query/vars.go
+
+package query
+
+import (
+	"fmt"
+	"pgtools/shared"
+	"regexp"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// dollarTag matches the opening of a dollar-quoted string: $$ or $tag$
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// Variables are the psql-style variables set with -v name=value or \set
+type Variables map[string]string
+
+// ParseVariables reads name=value definitions
+func ParseVariables(defs []string) (Variables, *ce.CustomError) {
+	vars := Variables{}
+	for _, d := range defs {
+		name, value, ok := strings.Cut(d, "=")
+		name = strings.TrimSpace(name)
+		if !ok || varName.FindString(name) != name {
+			return nil, &ce.CustomError{Code: 750, Title: "Invalid variable", Message: fmt.Sprintf("-v %q: expected name=value", d)}
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// Substitute replaces :name with the value of the variable, :'name' with the value as a literal and :"name" with
+// the value as an identifier. As in psql, quoted text is left alone, :: casts are not references and unknown
+// variables are kept verbatim. Comments are expected to be gone already (the splitter drops them).
+func (v Variables) Substitute(text string) string {
+	if len(v) == 0 || !strings.Contains(text, ":") {
+		return text
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (text[i-1] == 'E' || text[i-1] == 'e') && (i == 1 || !shared.IsIdentByte(text[i-2]))
+			end := skipQuoted(text, i, escapes)
+			sb.WriteString(text[i:end])
+			i = end
+		case c == '$' && (i == 0 || !shared.IsIdentByte(text[i-1])) && dollarTag.MatchString(text[i:]):
+			tag := dollarTag.FindString(text[i:])
+			end := len(text)
+			if j := strings.Index(text[i+len(tag):], tag); j >= 0 {
+				end = i + len(tag) + j + len(tag)
+			}
+			sb.WriteString(text[i:end])
+			i = end
+		case c == ':' && i+1 < len(text) && text[i+1] == ':':
+			sb.WriteString("::")
+			i += 2
+		case c == ':':
+			value, n := v.reference(text[i+1:])
+			if n == 0 {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+			sb.WriteString(value)
+			i += 1 + n
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// reference resolves the variable reference at the start of s; n is the length consumed, 0 if there is nothing to
+// substitute.
+func (v Variables) reference(s string) (string, int) {
+	if s == "" {
+		return "", 0
+	}
+	if q := s[0]; q == '\'' || q == '"' {
+		end := strings.IndexByte(s[1:], q)
+		if end < 0 {
+			return "", 0
+		}
+		name := s[1 : end+1]
+		value, ok := v[name]
+		if !ok || varName.FindString(name) != name {
+			return "", 0
+		}
+		if q == '\'' {
+			return shared.QuoteLiteral(value), end + 2
+		}
+		return shared.QuoteIdent(value), end + 2
+	}
+	name := varName.FindString(s)
+	value, ok := v[name]
+	if name == "" || !ok {
+		return "", 0
+	}
+	return value, len(name)
+}
+
+// skipQuoted returns the index just past the quoted text starting at i; doubled quotes are part of the text
+func skipQuoted(text string, i int, backslashEscapes bool) int {
+	q := text[i]
+	for j := i + 1; j < len(text); j++ {
+		switch {
+		case backslashEscapes && text[j] == '\\':
+			j++
+		case text[j] == q && j+1 < len(text) && text[j+1] == q:
+			j++
+		case text[j] == q:
+			return j + 1
+		}
+	}
+	return len(text)
+}
@@ -43,10 +43,69 @@ type queryConn interface {
 func ListRoles(cfg *types.DBConfig, includeMembers bool, verbose bool) *ce.CustomError {
 	logging.Debugf("Entering function: ListRoles(includeMembers=%v, verbose=%v)", includeMembers, verbose)
 
-	conn, err := db.Connect(cfg, "postgres")
+	list, members, err := collectRoles(cfg, includeMembers)
 	if err != nil {
 		return err
 	}
+
+	// Quiet mode: print names (optionally with members)
+	if types.Quiet {
+		for _, r := range list {
+			if includeMembers {
+				ms := members[r.Name]
+				sort.Strings(ms)
+				if len(ms) > 0 {
+					fmt.Printf("%s: %s\n", r.Name, strings.Join(ms, ","))
+				} else {
+					fmt.Println(r.Name)
+				}
+			} else {
+				fmt.Println(r.Name)
+			}
+		}
+		return nil
+	}
+
+	// Pretty table
+	tw := table.NewWriter()
+	tw.SetStyle(table.StyleRounded)
+	tw.Style().Options.SeparateRows = false
+	tw.Style().Color.Header = text.Colors{text.Bold}
+	tw.AppendHeader(buildHeader(includeMembers, verbose))
+
+	for _, r := range list {
+		row := buildRow(r, includeMembers, verbose, members)
+		tw.AppendRow(row)
+	}
+
+	fmt.Println(tw.Render())
+	return nil
+}
+
+// RoleHeader names the columns of the rows returned by RoleRows
+func RoleHeader(includeMembers bool, verbose bool) table.Row {
+	return buildHeader(includeMembers, verbose)
+}
+
+// RoleRows returns the rows ListRoles would show, for commands that merge several servers in one table
+func RoleRows(cfg *types.DBConfig, includeMembers bool, verbose bool) ([]table.Row, *ce.CustomError) {
+	list, members, err := collectRoles(cfg, includeMembers)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]table.Row, 0, len(list))
+	for _, r := range list {
+		rows = append(rows, buildRow(r, includeMembers, verbose, members))
+	}
+	return rows, nil
+}
+
+// collectRoles reads pg_roles and, if asked, the role memberships
+func collectRoles(cfg *types.DBConfig, includeMembers bool) ([]roleRow, map[string][]string, *ce.CustomError) {
+	conn, err := db.Connect(cfg, "postgres")
+	if err != nil {
+		return nil, nil, err
+	}
 	defer conn.Close(context.Background())
 
 	// Fetch roles from pg_roles (visible to all users)
@@ -65,7 +124,7 @@ ORDER BY rolname;
 `
 	rows, qerr := conn.Query(context.Background(), qRoles)
 	if qerr != nil {
-		return &ce.CustomError{Code: 350, Title: "Failed to query pg_roles", Message: qerr.Error()}
+		return nil, nil, &ce.CustomError{Code: 350, Title: "Failed to query pg_roles", Message: qerr.Error()}
 	}
 	defer rows.Close()
 
@@ -75,12 +134,12 @@ ORDER BY rolname;
 		if scanErr := rows.Scan(
 			&r.Name, &r.Login, &r.Superuser, &r.CreateDB, &r.CreateRole, &r.Inherit, &r.Replication, &r.BypassRLS,
 		); scanErr != nil {
-			return &ce.CustomError{Code: 351, Title: "Failed to scan pg_roles row", Message: scanErr.Error()}
+			return nil, nil, &ce.CustomError{Code: 351, Title: "Failed to scan pg_roles row", Message: scanErr.Error()}
 		}
 		list = append(list, r)
 	}
 	if rows.Err() != nil {
-		return &ce.CustomError{Code: 352, Title: "pg_roles iteration error", Message: rows.Err().Error()}
+		return nil, nil, &ce.CustomError{Code: 352, Title: "pg_roles iteration error", Message: rows.Err().Error()}
 	}
 
 	// Optionally fetch membership map: role -> members[]
@@ -88,43 +147,11 @@ ORDER BY rolname;
 	if includeMembers {
 		m, merr := fetchMemberships(conn) // conn satisfies queryConn
 		if merr != nil {
-			return merr
+			return nil, nil, merr
 		}
 		members = m
 	}
-
-	// Quiet mode: print names (optionally with members)
-	if types.Quiet {
-		for _, r := range list {
-			if includeMembers {
-				ms := members[r.Name]
-				sort.Strings(ms)
-				if len(ms) > 0 {
-					fmt.Printf("%s: %s\n", r.Name, strings.Join(ms, ","))
-				} else {
-					fmt.Println(r.Name)
-				}
-			} else {
-				fmt.Println(r.Name)
-			}
-		}
-		return nil
-	}
-
-	// Pretty table
-	tw := table.NewWriter()
-	tw.SetStyle(table.StyleRounded)
-	tw.Style().Options.SeparateRows = false
-	tw.Style().Color.Header = text.Colors{text.Bold}
-	tw.AppendHeader(buildHeader(includeMembers, verbose))
-
-	for _, r := range list {
-		row := buildRow(r, includeMembers, verbose, members)
-		tw.AppendRow(row)
-	}
-
-	fmt.Println(tw.Render())
-	return nil
+	return list, members, nil
 }
 
 // fetchMemberships builds a map: role -> []member
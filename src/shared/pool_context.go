@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"pgtools/environment"
+	"pgtools/types"
 	"syscall"
 	"time"
 
@@ -33,16 +34,23 @@ func CancellableContext() (context.Context, context.CancelFunc) {
 // - Replace cfg.BuildDSN() import/path above to match your codebase.
 // - If BuildDSN requires context or args, adjust the call accordingly.
 func GetPool(ctx context.Context) (*pgxpool.Pool, *ce.CustomError) {
+	return GetPoolForDB(ctx, "postgres")
+}
+
+// GetPoolForDB opens a new pgx pool for a specific database name using the current env config.
+func GetPoolForDB(ctx context.Context, dbName string) (*pgxpool.Pool, *ce.CustomError) {
 	cfg, err := environment.LoadConfig()
 	if err != nil {
 		return nil, err
 	}
+	return OpenPool(ctx, cfg, dbName)
+}
 
-	dsn := BuildDSN(cfg, "postgres")
-
-	pc, perr := pgxpool.ParseConfig(dsn)
-	if perr != nil {
-		return nil, &ce.CustomError{Code: 803, Title: "Error parsing DSN", Message: perr.Error()}
+// OpenPool opens a new pgx pool on a database of the given environment.
+func OpenPool(ctx context.Context, cfg *types.DBConfig, dbName string) (*pgxpool.Pool, *ce.CustomError) {
+	pc, err := PoolConfig(cfg, dbName)
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure RuntimeParams exists
@@ -68,33 +76,3 @@ func GetPool(ctx context.Context) (*pgxpool.Pool, *ce.CustomError) {
 	}
 	return pool, nil
 }
-
-// GetPoolForDB opens a new pgx pool for a specific database name using the current env config.
-func GetPoolForDB(ctx context.Context, dbName string) (*pgxpool.Pool, *ce.CustomError) {
-	cfg, err := environment.LoadConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	dsn := BuildDSN(cfg, dbName)
-
-	pc, pErr := pgxpool.ParseConfig(dsn)
-	if pErr != nil {
-		return nil, &ce.CustomError{Code: 801, Title: "Error parsing DSN", Message: pErr.Error()}
-	}
-
-	// Ensure application_name is set
-	if _, exists := pc.ConnConfig.RuntimeParams["application_name"]; !exists {
-		appName := os.Getenv("PGAPPNAME")
-		if appName == "" {
-			appName = "pgtools"
-		}
-		pc.ConnConfig.RuntimeParams["application_name"] = appName
-	}
-
-	pool, perr := pgxpool.NewWithConfig(ctx, pc)
-	if perr != nil {
-		return nil, &ce.CustomError{Code: 804, Title: "Error connecting to pool", Message: perr.Error()}
-	}
-	return pool, nil
-}
@@ -10,6 +10,16 @@ import (
 	"strings"
 )
 
+// TextOutputSettings make the server's text output predictable: UTC timestamps in ISO format, hex bytea and
+// round-trippable floats
+var TextOutputSettings = []string{
+	"SET TimeZone = 'UTC'",
+	"SET DateStyle = 'ISO, YMD'",
+	"SET IntervalStyle = 'iso_8601'",
+	"SET bytea_output = 'hex'",
+	"SET extra_float_digits = 3",
+}
+
 // QuoteIdent quotes a single identifier with double quotes, escaping any internal quotes.
 func QuoteIdent(ident string) string {
 	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
@@ -29,6 +39,22 @@ func QuoteIdents(in []string) []string {
 	return out
 }
 
+// QuoteLiteral quotes s as a SQL string literal, using the E'...' form when it holds backslashes, so that it reads
+// the same whatever standard_conforming_strings is set to.
+func QuoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	if strings.Contains(s, `\`) {
+		return `E'` + strings.ReplaceAll(s, `\`, `\\`) + `'`
+	}
+	return "'" + s + "'"
+}
+
+// IsIdentByte reports whether c can be part of an unquoted identifier or keyword (any byte of a multibyte character
+// counts).
+func IsIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= 0x80
+}
+
 // HumanizeBytes formats a byte count into MB or GB with 1 decimal place.
 // It deliberately avoids KB/TB to keep the output compact and aligned.
 func HumanizeBytes(b int64) string {
@@ -0,0 +1,131 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 09:10
+// Original filename: src/shell/complete.go
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"pgtools/shared"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/term"
+)
+
+const maxListed = 100
+
+// completer offers the schema, table and column names of the current database on Tab
+type completer struct {
+	words []string // sorted, quoted where needed
+	term  *term.Terminal
+}
+
+// load reads the names to complete from the catalog; on failure completion only offers meta-commands
+func (c *completer) load(ctx context.Context, conn *pgx.Conn) error {
+	c.words = nil
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, coalesce(a.attname, '')
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname !~ '^pg_toast'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	add := func(w string) {
+		if !seen[w] {
+			seen[w] = true
+			c.words = append(c.words, w)
+		}
+	}
+	for rows.Next() {
+		var schema, table, column string
+		if err := rows.Scan(&schema, &table, &column); err != nil {
+			return err
+		}
+		add(shared.QuoteIdentIfNeeded(schema))
+		add(shared.QuoteIdentIfNeeded(table))
+		add(shared.QuoteIdentIfNeeded(schema) + "." + shared.QuoteIdentIfNeeded(table))
+		if column != "" {
+			add(shared.QuoteIdentIfNeeded(column))
+		}
+	}
+	slices.Sort(c.words)
+	return rows.Err()
+}
+
+// complete is the term.Terminal AutoCompleteCallback: the word before the cursor is completed up to the longest
+// common prefix of its candidates; when that adds nothing, the candidates are listed.
+func (c *completer) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := pos
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+	word := line[start:pos]
+
+	var candidates []string
+	if start == 0 && strings.HasPrefix(word, `\`) {
+		candidates = matching(metaCommandNames(), word)
+	} else if word != "" {
+		candidates = matching(c.words, word)
+	}
+	if len(candidates) == 0 {
+		return line, pos, true
+	}
+
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		completion += " "
+	}
+	if len(completion) > len(word) {
+		return line[:start] + completion + line[pos:], start + len(completion), true
+	}
+
+	list := candidates
+	if len(list) > maxListed {
+		list = append(list[:maxListed:maxListed], fmt.Sprintf("... %d more", len(candidates)-maxListed))
+	}
+	if c.term != nil {
+		fmt.Fprintln(c.term, strings.Join(list, "  "))
+	}
+	return line, pos, true
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b == '.' || b == '"' || b == '\\' || b == '$' || b >= '0' && b <= '9' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= 0x80
+}
+
+// matching returns the words starting with prefix, ignoring case
+func matching(words []string, prefix string) []string {
+	prefix = strings.ToLower(prefix)
+	var out []string
+	for _, w := range words {
+		if strings.HasPrefix(strings.ToLower(w), prefix) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		n := 0
+		for n < len(prefix) && n < len(w) && strings.EqualFold(prefix[n:n+1], w[n:n+1]) {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
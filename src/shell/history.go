@@ -0,0 +1,83 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 08:30
+// Original filename: src/shell/history.go
+
+package shell
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"pgtools/logging"
+	"strings"
+)
+
+const historySize = 1000
+
+// history is the shell's line history, kept in ~/.config/JFG/pgtools/shell_history; it implements term.History
+type history struct {
+	path    string
+	entries []string // oldest first
+}
+
+func historyPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "JFG", "pgtools", "shell_history")
+}
+
+// loadHistory reads the history file, trimming it to its last historySize entries
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	f.Close()
+
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+		if err := os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600); err != nil {
+			logging.Errorf("Unable to rewrite the shell history: %s", err.Error())
+		}
+	}
+	return h
+}
+
+// Add records a line, skipping blank lines and repeats of the previous one
+func (h *history) Add(entry string) {
+	entry = strings.TrimRight(entry, " \t")
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > historySize {
+		h.entries = h.entries[1:]
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logging.Errorf("Unable to write the shell history: %s", err.Error())
+		return
+	}
+	defer f.Close()
+	f.WriteString(entry + "\n")
+}
+
+func (h *history) Len() int {
+	return len(h.entries)
+}
+
+// At returns the idx-th most recent entry
+func (h *history) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
@@ -0,0 +1,246 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 10:05
+// Original filename: src/shell/meta.go
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pgtools/db"
+	"pgtools/roles"
+	"pgtools/shared"
+	"pgtools/show"
+	"slices"
+	"strings"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+)
+
+// metaCommand is a backslash command of the shell
+type metaCommand struct {
+	name, args, help string
+	run              func(sh *shell, args []string) *ce.CustomError
+}
+
+var metaCommands []metaCommand
+
+func init() {
+	metaCommands = []metaCommand{
+		{`\?`, "", "show this help", func(sh *shell, _ []string) *ce.CustomError { printHelp(); return nil }},
+		{`\q`, "", "quit", func(sh *shell, _ []string) *ce.CustomError { sh.quit = true; return nil }},
+		{`\l`, "", "list databases", func(sh *shell, _ []string) *ce.CustomError {
+			_, err := show.ShowDatabases(sh.cfg, false)
+			return err
+		}},
+		{`\c`, "[DB]", "connect to another database, or show the connection", (*shell).metaConnect},
+		{`\d`, "[NAME]", "list relations, or describe a table, view, sequence or index", (*shell).metaDescribe},
+		{`\dt`, "[PATTERN]", "list tables", (*shell).metaTables},
+		{`\dn`, "", "list schemas", (*shell).metaSchemas},
+		{`\du`, "", "list roles", func(sh *shell, _ []string) *ce.CustomError { return roles.ListRoles(sh.cfg, true, false) }},
+		{`\x`, "[on|off]", "toggle expanded output", func(sh *shell, args []string) *ce.CustomError {
+			return toggle(&sh.session.Expanded, args, "Expanded display")
+		}},
+		{`\timing`, "[on|off]", "toggle the timing of statements", func(sh *shell, args []string) *ce.CustomError {
+			return toggle(&sh.session.Timing, args, "Timing")
+		}},
+		{`\set`, "[NAME [VALUE]]", "set a variable, or list them", (*shell).metaSet},
+		{`\unset`, "NAME", "unset a variable", nil},
+		{`\echo`, "TEXT", "print text", nil},
+	}
+}
+
+func metaCommandNames() []string {
+	var names []string
+	for _, m := range metaCommands {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// meta runs a backslash command; commands without a handler of their own are the script ones of query.Session
+func (sh *shell) meta(line string) {
+	fields := strings.Fields(line)
+	i := slices.IndexFunc(metaCommands, func(m metaCommand) bool { return m.name == fields[0] })
+	if fields[0] == `\quit` {
+		i = slices.IndexFunc(metaCommands, func(m metaCommand) bool { return m.name == `\q` })
+	}
+
+	var err *ce.CustomError
+	switch {
+	case i < 0:
+		fmt.Fprintf(os.Stderr, "Invalid command %s. Try \\? for help.\n", fields[0])
+		return
+	case metaCommands[i].run == nil:
+		err = sh.session.Meta(&db.SQLItem{Meta: true, Text: line})
+	default:
+		err = metaCommands[i].run(sh, fields[1:])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
+
+func printHelp() {
+	for _, m := range metaCommands {
+		fmt.Printf("  %-22s %s\n", strings.TrimSpace(m.name+" "+m.args), m.help)
+	}
+	fmt.Println("Statements end with a semicolon and may span several lines; Tab completes schema, table and column names.")
+}
+
+func toggle(flag *bool, args []string, what string) *ce.CustomError {
+	switch {
+	case len(args) == 0:
+		*flag = !*flag
+	case args[0] == "on" || args[0] == "true":
+		*flag = true
+	case args[0] == "off" || args[0] == "false":
+		*flag = false
+	default:
+		return &ce.CustomError{Code: 761, Title: "Invalid meta-command", Message: fmt.Sprintf("unrecognized value %q: expected on or off", args[0])}
+	}
+	state := "off"
+	if *flag {
+		state = "on"
+	}
+	fmt.Printf("%s is %s.\n", what, state)
+	return nil
+}
+
+func (sh *shell) metaConnect(args []string) *ce.CustomError {
+	if len(args) == 0 {
+		fmt.Printf("You are connected to database %q as user %q on host %q at port \"%d\".\n", sh.dbname, sh.cfg.User, sh.cfg.Host, sh.cfg.Port)
+		return nil
+	}
+	if err := sh.connect(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("You are now connected to database %q as user %q.\n", sh.dbname, sh.cfg.User)
+	return nil
+}
+
+func (sh *shell) metaSet(args []string) *ce.CustomError {
+	if len(args) > 0 {
+		return sh.session.Meta(&db.SQLItem{Meta: true, Text: `\set ` + strings.Join(args, " ")})
+	}
+	names := make([]string, 0, len(sh.session.Vars))
+	for name := range sh.session.Vars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%s = '%s'\n", name, sh.session.Vars[name])
+	}
+	return nil
+}
+
+func (sh *shell) metaSchemas(_ []string) *ce.CustomError {
+	ctx, cancel := shared.CancellableContext()
+	defer cancel()
+	pool, err := shared.OpenPool(ctx, sh.cfg, sh.dbname)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	rows, err := show.CollectSchemas(ctx, pool, sh.dbname)
+	if err != nil {
+		return err
+	}
+	show.RenderSchemas(rows)
+	return nil
+}
+
+// metaTables shows the tables with their sizes through show tables; a pattern lists the matching ones instead
+func (sh *shell) metaTables(args []string) *ce.CustomError {
+	if len(args) == 0 {
+		ctx, cancel := shared.CancellableContext()
+		defer cancel()
+		return show.ShowTables(ctx, sh.cfg, []string{sh.dbname})
+	}
+	return sh.listRelations("'r', 'p'", args[0])
+}
+
+const relkindName = `CASE c.relkind WHEN 'r' THEN 'table' WHEN 'p' THEN 'partitioned table' WHEN 'v' THEN 'view'
+	WHEN 'm' THEN 'materialized view' WHEN 'S' THEN 'sequence' WHEN 'f' THEN 'foreign table' WHEN 'i' THEN 'index'
+	WHEN 'I' THEN 'partitioned index' END`
+
+// listRelations lists the relations of the given kinds; pattern uses psql's * and ? wildcards and may be
+// schema-qualified
+func (sh *shell) listRelations(kinds, pattern string) *ce.CustomError {
+	filter := ""
+	if pattern != "" {
+		like := strings.NewReplacer("_", `\_`, "%", `\%`, "*", "%", "?", "_").Replace(pattern)
+		subject := "c.relname"
+		if strings.Contains(pattern, ".") {
+			subject = "n.nspname || '.' || c.relname"
+		}
+		filter = " AND " + subject + " LIKE " + shared.QuoteLiteral(like)
+	}
+	return sh.session.Exec(context.Background(), `
+		SELECT n.nspname AS "Schema", c.relname AS "Name", `+relkindName+` AS "Type",
+			pg_catalog.pg_get_userbyid(c.relowner) AS "Owner"
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN (`+kinds+`) AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname !~ '^pg_toast'`+filter+`
+		ORDER BY 1, 2`)
+}
+
+// metaDescribe lists the relations, or describes one: columns, then indexes, constraints, triggers or the view
+// definition, as they apply
+func (sh *shell) metaDescribe(args []string) *ce.CustomError {
+	if len(args) == 0 {
+		return sh.listRelations("'r', 'p', 'v', 'm', 'S', 'f'", "")
+	}
+	ctx := context.Background()
+	name := strings.Join(args, " ")
+	failed := func(e error) *ce.CustomError {
+		return &ce.CustomError{Code: 762, Title: "Unable to describe " + name, Message: e.Error()}
+	}
+
+	rel, _, e := sh.session.Query(ctx, `
+		SELECT c.oid, n.nspname, c.relname, `+relkindName+`, c.relkind
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.oid = pg_catalog.to_regclass(`+shared.QuoteLiteral(name)+`)`)
+	if e != nil {
+		return failed(e)
+	}
+	if len(rel.Rows) == 0 {
+		return &ce.CustomError{Code: 762, Title: "Unable to describe " + name, Message: fmt.Sprintf("did not find any relation named %q", name)}
+	}
+	oid, kind := *rel.Rows[0][0], *rel.Rows[0][4]
+	title := *rel.Rows[0][3]
+	fmt.Printf("%s%s %s\n", strings.ToUpper(title[:1]), title[1:], shared.QuoteQualifiedIdent(*rel.Rows[0][1], *rel.Rows[0][2]))
+
+	sections := []struct {
+		kinds, sql string
+	}{
+		{"rpvmSfiI", `SELECT a.attname AS "Column", pg_catalog.format_type(a.atttypid, a.atttypmod) AS "Type",
+			CASE WHEN a.attnotnull THEN 'not null' ELSE '' END AS "Nullable",
+			coalesce(pg_catalog.pg_get_expr(d.adbin, d.adrelid), '') AS "Default"
+			FROM pg_catalog.pg_attribute a
+			LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			WHERE a.attrelid = ` + oid + ` AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum`},
+		{"rpm", `SELECT i.indexrelid::regclass::text AS "Index", pg_catalog.pg_get_indexdef(i.indexrelid) AS "Definition"
+			FROM pg_catalog.pg_index i WHERE i.indrelid = ` + oid + ` ORDER BY i.indisprimary DESC, 1`},
+		{"rpf", `SELECT conname AS "Constraint", pg_catalog.pg_get_constraintdef(oid, true) AS "Definition"
+			FROM pg_catalog.pg_constraint WHERE conrelid = ` + oid + ` AND contype <> 'n' ORDER BY contype, conname`},
+		{"rpvf", `SELECT tgname AS "Trigger", pg_catalog.pg_get_triggerdef(oid, true) AS "Definition"
+			FROM pg_catalog.pg_trigger WHERE tgrelid = ` + oid + ` AND NOT tgisinternal ORDER BY tgname`},
+		{"vm", `SELECT pg_catalog.pg_get_viewdef(` + oid + `, true) AS "View definition"`},
+	}
+	for _, s := range sections {
+		if !strings.Contains(s.kinds, kind) {
+			continue
+		}
+		rs, _, e := sh.session.Query(ctx, s.sql)
+		if e != nil {
+			return failed(e)
+		}
+		if len(rs.Rows) > 0 {
+			fmt.Println(rs.Table(sh.session.Null))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 07:45
+// Original filename: src/shell/shell.go
+
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"pgtools/db"
+	"pgtools/logging"
+	"pgtools/query"
+	"pgtools/types"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	"golang.org/x/term"
+)
+
+// shell is an interactive session: lines are accumulated until they hold complete statements, which are run as
+// they complete; backslash lines are meta-commands.
+type shell struct {
+	cfg     *types.DBConfig
+	dbname  string
+	session *query.Session
+	comp    *completer
+	history *history
+	term    *term.Terminal // nil when stdin is not a terminal; lines are then read from input
+	input   *bufio.Reader
+	keys    *keyReader // the terminal's input, to tell Ctrl-C from Ctrl-D
+	fd      int
+	pending string // the statement being typed
+	quit    bool
+
+	mu      sync.Mutex
+	running *pgx.Conn // the connection busy with a statement, for Ctrl-C to cancel
+}
+
+// Run starts the interactive shell on dbname. When stdin is not a terminal its lines are run without prompting.
+func Run(cfg *types.DBConfig, dbname string) *ce.CustomError {
+	logging.Debugf("Entering function: shell.Run(%s)", dbname)
+	sh := &shell{
+		cfg:     cfg,
+		comp:    &completer{},
+		session: &query.Session{Vars: query.Variables{}, Format: "table", Out: os.Stdout},
+		fd:      int(os.Stdin.Fd()),
+		keys:    &keyReader{r: os.Stdin},
+	}
+	if err := sh.connect(dbname); err != nil {
+		return err
+	}
+	defer func() { sh.session.Conn.Close(context.Background()) }()
+
+	if term.IsTerminal(sh.fd) {
+		sh.history = loadHistory(historyPath())
+		sh.newTerminal()
+		sh.watchInterrupts()
+		fmt.Printf("pgtools shell, server %s. Type \\? for help, \\q to quit.\n", sh.session.Conn.PgConn().ParameterStatus("server_version"))
+	} else {
+		sh.input = bufio.NewReader(os.Stdin)
+	}
+
+	for !sh.quit {
+		line, err := sh.readLine()
+		if err == io.EOF {
+			if sh.term == nil {
+				// psql runs an unterminated last statement too
+				if strings.TrimSpace(sh.pending) != "" {
+					sh.run(sh.pending)
+				}
+				break
+			}
+			fmt.Println()
+			// the terminal ends the line the same way for both: Ctrl-D on an empty line quits when nothing is
+			// pending, Ctrl-C drops the statement being typed
+			if !sh.keys.ctrlC && sh.pending == "" {
+				break
+			}
+			sh.pending = ""
+			sh.newTerminal()
+			continue
+		}
+		if err != nil {
+			return &ce.CustomError{Code: 760, Title: "Unable to read input", Message: err.Error()}
+		}
+		sh.handle(line)
+	}
+	return nil
+}
+
+// newTerminal sets up line editing; it is also used to start over with a clean line after Ctrl-C
+func (sh *shell) newTerminal() {
+	sh.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{sh.keys, os.Stdout}, "")
+	sh.term.History = sh.history
+	sh.term.AutoCompleteCallback = sh.comp.complete
+	sh.comp.term = sh.term
+}
+
+// keyReader passes the keys on to the terminal, noting whether the last ones read held a Ctrl-C
+type keyReader struct {
+	r     io.Reader
+	ctrlC bool
+}
+
+func (k *keyReader) Read(p []byte) (int, error) {
+	n, err := k.r.Read(p)
+	k.ctrlC = bytes.IndexByte(p[:n], 3) >= 0
+	return n, err
+}
+
+// readLine reads one line. The terminal is only in raw mode while a line is being edited, so that everything
+// else (results, the show and roles tables) prints as usual.
+func (sh *shell) readLine() (string, error) {
+	if sh.term == nil {
+		line, err := sh.input.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	sh.term.SetPrompt(sh.prompt())
+	if w, h, err := term.GetSize(sh.fd); err == nil {
+		sh.term.SetSize(w, h)
+	}
+	state, err := term.MakeRaw(sh.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(sh.fd, state)
+
+	line, err := sh.term.ReadLine()
+	if err == term.ErrPasteIndicator {
+		err = nil
+	}
+	return line, err
+}
+
+// prompt follows psql: db=> when idle, db-> while a statement continues, with * inside a transaction and ! inside
+// a failed one
+func (sh *shell) prompt() string {
+	mark := "="
+	if sh.pending != "" {
+		mark = "-"
+	}
+	switch sh.session.Conn.PgConn().TxStatus() {
+	case 'T':
+		mark += "*"
+	case 'E':
+		mark += "!"
+	}
+	return sh.dbname + mark + "> "
+}
+
+// handle adds a line to the statement being typed and runs every statement it completes
+func (sh *shell) handle(line string) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, `\`) {
+		sh.meta(trimmed)
+		return
+	}
+	if sh.pending == "" && trimmed == "" {
+		return
+	}
+
+	sh.pending += line + "\n"
+	items, err := splitStatements(sh.pending)
+	if err != nil {
+		// an open quote, dollar quote or comment: keep reading
+		return
+	}
+	sh.pending = ""
+	for i, item := range items {
+		switch {
+		case item.Meta:
+			sh.meta(item.Text)
+		case i == len(items)-1 && !strings.HasSuffix(item.Text, ";"):
+			sh.pending = item.Text + "\n"
+		default:
+			sh.run(item.Text)
+		}
+	}
+}
+
+func splitStatements(text string) ([]*db.SQLItem, error) {
+	splitter := db.NewSQLSplitter(strings.NewReader(text))
+	var items []*db.SQLItem
+	for {
+		item, err := splitter.Next()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// run executes one statement; Ctrl-C sends a cancel request while it runs
+func (sh *shell) run(text string) {
+	text = sh.session.Vars.Substitute(text)
+	if db.IsCopyFromStdin(text) {
+		fmt.Fprintln(os.Stderr, "COPY ... FROM stdin is not supported in the shell; run the script with pgtools sql -f")
+		return
+	}
+
+	sh.mu.Lock()
+	sh.running = sh.session.Conn
+	sh.mu.Unlock()
+	err := sh.session.Exec(context.Background(), text)
+	sh.mu.Lock()
+	sh.running = nil
+	sh.mu.Unlock()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	if sh.session.Conn.IsClosed() {
+		fmt.Fprintln(os.Stderr, "The connection to the server was lost; use \\c to reconnect.")
+	}
+}
+
+func (sh *shell) watchInterrupts() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		for range ch {
+			sh.mu.Lock()
+			conn := sh.running
+			sh.mu.Unlock()
+			if conn != nil {
+				if err := conn.PgConn().CancelRequest(context.Background()); err != nil {
+					logging.Errorf("Cancel request failed: %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// connect switches the session to dbname and reloads the completion names
+func (sh *shell) connect(dbname string) *ce.CustomError {
+	ctx := context.Background()
+	conn, err := db.Connect(sh.cfg, dbname)
+	if err != nil {
+		return err
+	}
+	if sh.session.Conn != nil {
+		sh.session.Conn.Close(ctx)
+	}
+	sh.session.Conn = conn
+	sh.dbname = dbname
+	if e := sh.comp.load(ctx, conn); e != nil {
+		logging.Errorf("Tab completion of names is unavailable: %s", e.Error())
+	}
+	return nil
+}
@@ -28,9 +28,42 @@ import (
 func ShowDatabases(cfg *types.DBConfig, sortBySize bool) ([]string, *ce.CustomError) {
 	logging.Debugf("Entering function: show.ShowDatabases")
 
+	data, err := CollectDatabases(cfg, sortBySize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Print unless quiet
+	if !types.Quiet {
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Database", "Size"})
+		t.SetStyle(table.StyleBold)
+		t.Style().Format.Header = text.FormatDefault
+		t.Style().Color.Header = text.Colors{text.Bold}
+
+		for _, r := range data {
+			t.AppendRow(table.Row{r.Name, shared.HumanizeBytes(r.SizeBytes)}) // uses helperFunctions HumanizeBytes
+		}
+		t.Render()
+	}
+
+	// Return names
+	names := make([]string, 0, len(data))
+	for _, r := range data {
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+// CollectDatabases returns the non-template databases with their sizes, sorted as ShowDatabases shows them
+func CollectDatabases(cfg *types.DBConfig, sortBySize bool) ([]DbRow, *ce.CustomError) {
 	// Connect to the maintenance DB "postgres".
-	dsn := shared.BuildDSN(cfg, "postgres")
-	conn, err := pgx.Connect(context.Background(), dsn)
+	cc, cerr := shared.ConnConfig(cfg, "postgres")
+	if cerr != nil {
+		return nil, cerr
+	}
+	conn, err := pgx.ConnectConfig(context.Background(), cc)
 	if err != nil {
 		return nil, &ce.CustomError{Title: "Connection failure", Message: err.Error(), Code: 200}
 	}
@@ -68,28 +101,7 @@ func ShowDatabases(cfg *types.DBConfig, sortBySize bool) ([]string, *ce.CustomEr
 			return data[i].Name < data[j].Name // ascending by name
 		})
 	}
-
-	// Print unless quiet
-	if !types.Quiet {
-		t := table.NewWriter()
-		t.SetOutputMirror(os.Stdout)
-		t.AppendHeader(table.Row{"Database", "Size"})
-		t.SetStyle(table.StyleBold)
-		t.Style().Format.Header = text.FormatDefault
-		t.Style().Color.Header = text.Colors{text.Bold}
-
-		for _, r := range data {
-			t.AppendRow(table.Row{r.Name, shared.HumanizeBytes(r.SizeBytes)}) // uses helperFunctions HumanizeBytes
-		}
-		t.Render()
-	}
-
-	// Return names
-	names := make([]string, 0, len(data))
-	for _, r := range data {
-		names = append(names, r.Name)
-	}
-	return names, nil
+	return data, nil
 }
 
 // ListTables returns a show of "schema.table" names for the connected DB.
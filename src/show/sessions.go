@@ -22,21 +22,39 @@ import (
 // NOTE: This version is identical in behavior but uses sql.NullString / sql.NullInt32
 // to avoid crashes when scanning NULLs from background/system backends.
 func ShowSessions(ctx context.Context, pool *pgxpool.Pool) *ce.CustomError {
+	rows, err := CollectSessions(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	tw := table.NewWriter()
+	tw.SetOutputMirror(os.Stdout)
+	tw.AppendHeader(SessionsHeader)
+	tw.AppendRows(rows)
+	tw.SetStyle(table.StyleBold)
+	tw.Style().Format.Header = text.FormatDefault
+	tw.Style().Color.Header = text.Colors{text.Bold}
+	tw.Render()
+	return nil
+}
+
+// SessionsHeader names the columns of the rows returned by CollectSessions
+var SessionsHeader = table.Row{
+	"PID", "User", "DB", "App", "Client", "Port", "Started",
+	"State", "WaitType", "WaitEvent",
+}
+
+// CollectSessions returns the sessions of pg_stat_activity, oldest first, as table rows
+func CollectSessions(ctx context.Context, pool *pgxpool.Pool) ([]table.Row, *ce.CustomError) {
 	const q = `SELECT pid,usename,datname,application_name,client_addr::text AS client_addr,client_port,backend_start,
        state,wait_event_type,wait_event FROM pg_stat_activity ORDER BY backend_start ASC;`
 	rows, err := pool.Query(ctx, q)
 	if err != nil {
-		return &ce.CustomError{Code: 801, Title: "Query error", Message: err.Error()}
+		return nil, &ce.CustomError{Code: 801, Title: "Query error", Message: err.Error()}
 	}
 	defer rows.Close()
 
-	tw := table.NewWriter()
-	tw.SetOutputMirror(os.Stdout)
-	tw.AppendHeader(table.Row{
-		"PID", "User", "DB", "App", "Client", "Port", "Started",
-		"State", "WaitType", "WaitEvent",
-	})
-
+	var out []table.Row
 	for rows.Next() {
 		var (
 			pid          int32
@@ -52,7 +70,7 @@ func ShowSessions(ctx context.Context, pool *pgxpool.Pool) *ce.CustomError {
 			//			query        sql.NullString
 		)
 		if err := rows.Scan(&pid, &usename, &datname, &app, &addr, &port, &backendStart, &state, &waitType, &waitEvent); err != nil {
-			return &ce.CustomError{Code: 802, Title: "Error scanning sessions", Message: err.Error()}
+			return nil, &ce.CustomError{Code: 802, Title: "Error scanning sessions", Message: err.Error()}
 		}
 
 		userStr := ""
@@ -89,19 +107,14 @@ func ShowSessions(ctx context.Context, pool *pgxpool.Pool) *ce.CustomError {
 			portVal = port.Int32
 		}
 
-		tw.AppendRow(table.Row{
+		out = append(out, table.Row{
 			pid, userStr, dbStr, appStr, addrStr, portVal,
 			backendStart.Format(time.RFC3339),
 			stateStr, wtStr, weStr,
 		})
 	}
 	if err := rows.Err(); err != nil {
-		return &ce.CustomError{Code: 803, Title: "Row scanning error", Message: err.Error()}
+		return nil, &ce.CustomError{Code: 803, Title: "Row scanning error", Message: err.Error()}
 	}
-
-	tw.SetStyle(table.StyleBold)
-	tw.Style().Format.Header = text.FormatDefault
-	tw.Style().Color.Header = text.Colors{text.Bold}
-	tw.Render()
-	return nil
+	return out, nil
 }
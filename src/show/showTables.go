@@ -30,8 +30,11 @@ func ShowTables(ctx context.Context, cfg *types.DBConfig, dbs []string) *ce.Cust
 
 	for _, dbname := range dbs {
 		// Build a DSN for this DB and open a short-lived pool
-		dsn := shared.BuildDSN(cfg, dbname)
-		pool, err := pgxpool.New(ctx, dsn)
+		pc, cerr := shared.PoolConfig(cfg, dbname)
+		if cerr != nil {
+			return cerr
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, pc)
 		if err != nil {
 			return &ce.CustomError{Code: 801, Title: "Error creating DB connection", Message: fmt.Sprintf("%s: %v", dbname, err)}
 		}
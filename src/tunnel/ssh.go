@@ -0,0 +1,422 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/27 09:40
+// Original filename: src/tunnel/ssh.go
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"pgtools/logging"
+	"pgtools/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ce "github.com/jeanfrancoisgratton/customError/v2"
+	hf "github.com/jeanfrancoisgratton/helperFunctions/v2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+const (
+	dialTimeout       = 10 * time.Second
+	keepAliveInterval = 30 * time.Second
+)
+
+// DialFunc opens a connection to addr, as seen from the last SSH host
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// the SSH chains already connected, shared by every database connection going through the same chain, and those
+// being connected, that other callers wait for instead of opening the same chain twice
+var (
+	tunnels    = map[string]*sshTunnel{}
+	connecting = map[string]*pending{}
+	tunnelsMu  sync.Mutex
+)
+
+// pending is a chain being connected; done is closed once t or err is set
+type pending struct {
+	done chan struct{}
+	t    *sshTunnel
+	err  error
+}
+
+// sshTunnel is a connected chain: the client of every hop, the last one opening the connections
+type sshTunnel struct {
+	clients []*ssh.Client
+	done    chan struct{}
+}
+
+func (t *sshTunnel) client() *ssh.Client {
+	return t.clients[len(t.clients)-1]
+}
+
+// close disconnects the hops, the last one first
+func (t *sshTunnel) close() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	for i := len(t.clients) - 1; i >= 0; i-- {
+		t.clients[i].Close()
+	}
+}
+
+// keepAlive pings the SSH host, so that idle tunnels are not dropped by firewalls and a dead one is noticed before
+// the next connection needs it; a tunnel that does not answer is closed and forgotten
+func (t *sshTunnel) keepAlive(key string) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := t.client().SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		var err error
+		select {
+		case err = <-reply:
+		case <-time.After(dialTimeout):
+			err = errors.New("no answer to the keepalive")
+		}
+		if err != nil {
+			logging.Debugf("SSH tunnel %s lost: %s", key, err.Error())
+			forget(key, t)
+			return
+		}
+	}
+}
+
+// forget closes a tunnel and removes it from the cache, unless it was replaced already
+func forget(key string, t *sshTunnel) {
+	tunnelsMu.Lock()
+	if tunnels[key] == t {
+		delete(tunnels, key)
+	}
+	tunnelsMu.Unlock()
+	t.close()
+}
+
+// hop is one SSH server of the chain
+type hop struct {
+	user, host string
+	port       int
+}
+
+func (h hop) addr() string {
+	return net.JoinHostPort(h.host, strconv.Itoa(h.port))
+}
+
+// parseHop reads [user@]host[:port]; the user and port default to those given
+func parseHop(spec, user string, port int) (hop, error) {
+	h := hop{user: user, port: port}
+	if u, rest, found := strings.Cut(spec, "@"); found {
+		h.user, spec = u, rest
+	}
+	h.host = spec
+	if host, p, err := net.SplitHostPort(spec); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			return h, fmt.Errorf("%q: invalid port", spec)
+		}
+		h.host, h.port = host, n
+	}
+	if h.host == "" || h.user == "" {
+		return h, fmt.Errorf("%q: expected [user@]host[:port]", spec)
+	}
+	return h, nil
+}
+
+// chain lists the jump hosts, then the SSH host itself
+func chain(c *types.SSHConfig) ([]hop, error) {
+	user, port := c.User, c.Port
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	if port == 0 {
+		port = 22
+	}
+	var hops []hop
+	for _, spec := range c.Jump {
+		h, err := parseHop(spec, user, 22)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, h)
+	}
+	h, err := parseHop(c.Host, user, port)
+	return append(hops, h), err
+}
+
+// Describe shows the chain as user@host:port -> user@host:port...
+func Describe(c *types.SSHConfig) string {
+	hops, _ := chain(c)
+	return describe(hops)
+}
+
+func describe(hops []hop) string {
+	var names []string
+	for _, h := range hops {
+		names = append(names, h.user+"@"+h.addr())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Check validates the SSH settings without connecting
+func Check(c *types.SSHConfig) *ce.CustomError {
+	if c.Host == "" {
+		return &ce.CustomError{Code: 50, Title: "Invalid SSH settings", Message: "the ssh host is missing"}
+	}
+	if _, err := chain(c); err != nil {
+		return &ce.CustomError{Code: 50, Title: "Invalid SSH settings", Message: err.Error()}
+	}
+	for _, path := range []string{c.KeyFile, c.KnownHosts} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(expandHome(path)); err != nil {
+			return &ce.CustomError{Code: 50, Title: "Invalid SSH settings", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// Dialer connects the SSH chain, once per process, and returns the function that opens connections through it.
+// Host keys are checked against known_hosts; the user is authenticated with the key file, else with the keys of the
+// SSH agent and the default ~/.ssh keys. A connection that fails because the chain went down reconnects it once.
+func Dialer(c *types.SSHConfig) (DialFunc, *ce.CustomError) {
+	hops, err := chain(c)
+	if err != nil {
+		return nil, &ce.CustomError{Code: 50, Title: "Invalid SSH settings", Message: err.Error()}
+	}
+	key := describe(hops)
+	if _, err := open(context.Background(), c, hops, key); err != nil {
+		return nil, &ce.CustomError{Code: 51, Title: "SSH tunnel failed", Message: err.Error()}
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t, err := open(ctx, c, hops, key)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := t.client().DialContext(ctx, network, addr)
+		var refused *ssh.OpenChannelError
+		if err == nil || errors.As(err, &refused) || ctx.Err() != nil {
+			// the SSH host answered: the chain is up, the address is what failed
+			return conn, err
+		}
+		logging.Debugf("SSH tunnel %s: %s; reconnecting", key, err.Error())
+		forget(key, t)
+		if t, err = open(ctx, c, hops, key); err != nil {
+			return nil, err
+		}
+		return t.client().DialContext(ctx, network, addr)
+	}, nil
+}
+
+// open returns the cached tunnel of the chain, connecting it if needed; the lock is only held to look up the cache,
+// so that other chains are not held up by this one's handshakes, and a caller that finds the chain being connected
+// waits for that attempt
+func open(ctx context.Context, c *types.SSHConfig, hops []hop, key string) (*sshTunnel, error) {
+	tunnelsMu.Lock()
+	if t, ok := tunnels[key]; ok {
+		tunnelsMu.Unlock()
+		return t, nil
+	}
+	if p, ok := connecting[key]; ok {
+		tunnelsMu.Unlock()
+		select {
+		case <-p.done:
+			return p.t, p.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	p := &pending{done: make(chan struct{})}
+	connecting[key] = p
+	tunnelsMu.Unlock()
+
+	p.t, p.err = connect(ctx, c, hops)
+
+	tunnelsMu.Lock()
+	delete(connecting, key)
+	if p.err == nil {
+		tunnels[key] = p.t
+	}
+	tunnelsMu.Unlock()
+	close(p.done)
+	if p.err != nil {
+		return nil, p.err
+	}
+	go p.t.keepAlive(key)
+	logging.Debugf("SSH tunnel open: %s", key)
+	return p.t, nil
+}
+
+// connect opens the chain hop by hop, each through the previous one; when a hop fails, those already connected are
+// closed. Each hop's dial and handshake is bounded by dialTimeout, and cancelling ctx aborts the one in progress
+func connect(ctx context.Context, c *types.SSHConfig, hops []hop) (*sshTunnel, error) {
+	knownHostsFile := expandHome(c.KnownHosts)
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts: %w", err)
+	}
+	auth, closeAgent, err := authMethods(c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	// the agent signs during the handshakes only
+	defer closeAgent()
+
+	t := &sshTunnel{done: make(chan struct{})}
+	for _, h := range hops {
+		config := &ssh.ClientConfig{User: h.user, Auth: auth, HostKeyCallback: checkHostKey(hostKeys, knownHostsFile), Timeout: dialTimeout}
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		var conn net.Conn
+		if len(t.clients) == 0 {
+			conn, err = (&net.Dialer{}).DialContext(dialCtx, "tcp", h.addr())
+		} else {
+			conn, err = t.client().DialContext(dialCtx, "tcp", h.addr())
+		}
+		cancel()
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("%s: %w", h.addr(), err)
+		}
+		sshConn, chans, reqs, err := handshake(ctx, conn, h.addr(), config)
+		if err != nil {
+			conn.Close()
+			t.close()
+			return nil, fmt.Errorf("%s@%s: %w", h.user, h.addr(), err)
+		}
+		t.clients = append(t.clients, ssh.NewClient(sshConn, chans, reqs))
+	}
+	return t, nil
+}
+
+// handshake runs the SSH handshake over conn within dialTimeout, which ssh.ClientConfig.Timeout does not cover; conn is
+// closed when ctx is cancelled before the handshake is over
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return sshConn, chans, reqs, nil
+}
+
+// checkHostKey explains how to trust a host that known_hosts does not list
+func checkHostKey(hostKeys ssh.HostKeyCallback, file string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hostKeys(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				scan := hostname
+				if host, port, err := net.SplitHostPort(hostname); err == nil {
+					scan = host
+					if port != "22" {
+						scan = "-p " + port + " " + host
+					}
+				}
+				return fmt.Errorf("%s is not in %s; check its %s key fingerprint %s, then add it with: ssh-keyscan -H %s >> %s",
+					hostname, file, key.Type(), ssh.FingerprintSHA256(key), scan, file)
+			}
+			return fmt.Errorf("the host key of %s does not match %s: it may have been replaced, or the connection intercepted", hostname, file)
+		}
+		return err
+	}
+}
+
+// authMethods uses the key file if given, else the SSH agent and the usual ~/.ssh keys; the returned func closes the
+// connection to the agent, once the handshakes are done
+func authMethods(keyFile string) ([]ssh.AuthMethod, func(), error) {
+	var signers []ssh.Signer
+	closeAgent := func() {}
+	if keyFile != "" {
+		signer, err := loadKey(expandHome(keyFile), true)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	} else {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if conn, err := net.Dial("unix", sock); err == nil {
+				closeAgent = func() { conn.Close() }
+				if s, err := agent.NewClient(conn).Signers(); err == nil {
+					signers = append(signers, s...)
+				}
+			}
+		}
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			path := filepath.Join(os.Getenv("HOME"), ".ssh", name)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			// an encrypted key is only asked about when the agent has none
+			if signer, err := loadKey(path, len(signers) == 0); err == nil {
+				signers = append(signers, signer)
+			} else {
+				logging.Debugf("Skipping %s: %s", path, err.Error())
+			}
+		}
+	}
+	if len(signers) == 0 {
+		closeAgent()
+		return nil, nil, errors.New("no SSH key: set ssh.keyfile, or load a key in the SSH agent")
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, closeAgent, nil
+}
+
+// loadKey reads a private key, asking for its passphrase on a terminal if prompt is set
+func loadKey(path string, prompt bool) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if !prompt || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("%s is encrypted; load it in the SSH agent", path)
+		}
+		pass := hf.GetPassword(fmt.Sprintf("Passphrase of %s: ", path), types.DebugMode)
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(pass))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+func expandHome(path string) string {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		return filepath.Join(os.Getenv("HOME"), rest)
+	}
+	return path
+}
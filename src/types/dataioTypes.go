@@ -0,0 +1,43 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/22 14:05
+// Original filename: src/types/dataioTypes.go
+
+package types
+
+// ExportOptions holds the export flags
+type ExportOptions struct {
+	Table     string
+	Query     string
+	Where     string
+	Output    string
+	Format    string // csv, jsonl or parquet; taken from the output extension when empty
+	Delimiter string
+	Quote     string
+	Header    bool
+	Null      string
+	Numeric   string // string or decimal
+	ChunkRows int64
+	ChunkSize string // 500MB, 2GB...
+}
+
+var ExportDatabase string
+var ExportOpts = ExportOptions{Delimiter: ",", Quote: `"`, Header: true, Numeric: "string"}
+
+// ImportOptions holds the import flags
+type ImportOptions struct {
+	Table     string
+	Format    string // csv or jsonl; taken from the file extension when empty
+	Delimiter string
+	Quote     string
+	Header    bool
+	Null      string
+	Map       []string // SOURCE=COLUMN, or SOURCE= to skip a source column
+	Encoding  string
+	Rejects   string
+	Create    bool
+	Sample    int
+}
+
+var ImportDatabase string
+var ImportOpts = ImportOptions{Delimiter: ",", Quote: `"`, Header: true, Sample: 1000}
@@ -0,0 +1,150 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/17 17:20
+// Original filename: src/types/dbTypes.go
+
+package types
+
+// db restore / db convert flags
+var (
+	RestoreList   bool
+	RestoreTarget string
+)
+
+// db restore ownership / privilege remapping
+var (
+	RestoreNoOwner      bool
+	RestoreNoPrivileges bool
+	RestoreOwnerMap     []string
+	RestoreRoleMap      []string
+)
+
+// db backup / db restore / db validate manifest flags
+var (
+	BackupHashes    bool
+	BackupSchema    bool
+	RestoreValidate bool
+	ManifestFile    string
+)
+
+// BackupManifest is written next to every pgtools archive; it records what the archive is expected
+// to restore so that db validate can check a restored database against it.
+type BackupManifest struct {
+	Version       int                `json:"version"`
+	Created       string             `json:"created"`
+	Host          string             `json:"host"`
+	ServerVersion string             `json:"serverVersion"`
+	Databases     []DatabaseManifest `json:"databases"`
+}
+
+type DatabaseManifest struct {
+	Name      string             `json:"name"`
+	Tables    []TableManifest    `json:"tables"`
+	Sequences []SequenceManifest `json:"sequences"`
+	Objects   map[string]int64   `json:"objects"`
+	Schema    bool               `json:"schema,omitempty"` // the archive creates the schema (db backup --schema)
+}
+
+type TableManifest struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+type SequenceManifest struct {
+	Schema    string `json:"schema"`
+	Name      string `json:"name"`
+	LastValue *int64 `json:"lastValue"`
+}
+
+// db drill flags
+var (
+	DrillSmokeFiles []string
+	DrillReport     string
+)
+
+// schema / table filters and parallelism, shared by db clone, db diff and db datadiff
+var (
+	FilterSchemas        []string
+	FilterExcludeSchemas []string
+	FilterTables         []string
+	FilterExcludeTables  []string
+	Jobs                 int
+)
+
+// db clone flags
+var (
+	CloneToEnv      string
+	CloneAs         string
+	CloneReplace    bool
+	CloneSchemaOnly bool
+)
+
+// db copy-table flags
+var (
+	CopyWhere        string
+	CopyQuery        string
+	CopyCreateTarget bool
+	CopyTruncate     bool
+	CopyUpsertOn     string
+)
+
+// db diff / db datadiff flags
+var (
+	DiffOutput        string
+	DataDiffChunkSize int
+	DataDiffRepair    string
+	DataDiffMaxRows   int
+)
+
+// CreateDBOptions holds the CREATE DATABASE options of db create; empty strings and a nil
+// ConnectionLimit leave the server defaults in place.
+type CreateDBOptions struct {
+	Owner           string
+	Template        string
+	Encoding        string
+	Locale          string
+	LcCollate       string
+	LcCtype         string
+	IcuLocale       string
+	Tablespace      string
+	Strategy        string
+	ConnectionLimit *int
+	IsTemplate      bool
+	IfNotExists     bool
+	Grants          []string // ROLE[:PRIV,...], applied once the database exists
+	RevokePublic    bool
+}
+
+// db create flags
+var (
+	CreateOpts      CreateDBOptions
+	CreateConnLimit int
+)
+
+// AlterDBOptions holds the changes requested with db alter; nil and empty fields are left alone
+type AlterDBOptions struct {
+	RenameTo         string
+	Owner            string
+	ConnectionLimit  *int
+	AllowConnections *bool
+	IsTemplate       *bool
+	Tablespace       string
+	Set              []string // name=value
+	Reset            []string // name, or ALL
+	Force            bool     // disconnect sessions before RENAME and SET TABLESPACE
+}
+
+// db alter flags
+var (
+	AlterOpts             AlterDBOptions
+	AlterConnLimit        int
+	AlterAllowConnections bool
+	AlterIsTemplate       bool
+)
+
+// db info flags
+var (
+	InfoJSON bool
+)
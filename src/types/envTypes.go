@@ -8,19 +8,50 @@ package types
 var CreateOwner string
 var DropForce bool
 
+// env add, copy and rename flags
+var EnvAddConfig DBConfig
+var EnvAddSSH SSHConfig
+var EnvPasswordStdin bool
+var EnvForce bool
+var EnvTestFormat = "table"
+
 type DBConfig struct {
-	Host          string `json:"host"`
-	Port          int    `json:"port"`
-	User          string `json:"user"`
-	Password      string `json:"password"`
-	SSLMode       string `json:"sslmode"`
-	SSLRootCert   string `json:"sslrootcert,omitempty"`
-	SSLclientCert string `json:"sslclientcert,omitempty"`
-	SSLclientKey  string `json:"sslclientkey,omitempty"`
-	Description   string `json:"comment,omitempty"`
-	DefaultDB     string `json:"defaultdb,omitempty"`
+	Host          string     `json:"host"`
+	Port          int        `json:"port"`
+	User          string     `json:"user"`
+	Password      string     `json:"password"`
+	SSLMode       string     `json:"sslmode"`
+	SSLRootCert   string     `json:"sslrootcert,omitempty"`
+	SSLclientCert string     `json:"sslclientcert,omitempty"`
+	SSLclientKey  string     `json:"sslclientkey,omitempty"`
+	SSLPassword   string     `json:"sslpassword,omitempty"`
+	Description   string     `json:"comment,omitempty"`
+	DefaultDB     string     `json:"defaultdb,omitempty"`
+	SSH           *SSHConfig `json:"ssh,omitempty"`
+}
+
+// SSHConfig describes the SSH tunnel the server is reached through; Jump lists the bastions to cross first, in the
+// ProxyJump syntax ([user@]host[:port])
+type SSHConfig struct {
+	Host       string   `json:"host"`
+	Port       int      `json:"port,omitempty"`
+	User       string   `json:"user,omitempty"`
+	KeyFile    string   `json:"keyfile,omitempty"`
+	KnownHosts string   `json:"knownhosts,omitempty"`
+	Jump       []string `json:"jump,omitempty"`
 }
 type DBSize struct {
 	Name      string
 	SizeBytes int64
 }
+
+// ConnOverrides are the connection settings given on the command line, which take precedence over the env file
+type ConnOverrides struct {
+	Host    string
+	Port    int
+	User    string
+	SSLMode string
+	Service string
+}
+
+var ConnFlags ConnOverrides
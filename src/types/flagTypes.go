@@ -12,3 +12,6 @@ var AllDBs = false
 var UserRoles = false
 var LogLevel = "none"
 var AppNameKV = "pgtools"
+
+// how many environments a fleet command talks to at once
+var FleetParallel = 4
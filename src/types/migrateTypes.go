@@ -0,0 +1,13 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/23 14:10
+// Original filename: src/types/migrateTypes.go
+
+package types
+
+// migrate flags
+var (
+	MigrateDatabase   string
+	MigrateDir        = "migrations"
+	MigrateOutOfOrder bool
+)
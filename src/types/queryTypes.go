@@ -0,0 +1,30 @@
+// pgtools
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original timestamp: 2025/09/21 14:40
+// Original filename: src/types/queryTypes.go
+
+package types
+
+import "time"
+
+// sql flags
+var (
+	SQLDatabase    string
+	SQLFile        string
+	SQLFormat      = "table"
+	SQLVars        []string
+	SQLTransaction bool
+	SQLNull        string
+)
+
+// shell flags
+var ShellDatabase string
+
+// SafeDDLOptions are the --safe-ddl flags, shared by sql and migrate
+type SafeDDLOptions struct {
+	Enabled     bool
+	LockTimeout time.Duration
+	Retries     int
+}
+
+var SafeDDL = SafeDDLOptions{LockTimeout: 2 * time.Second, Retries: 5}